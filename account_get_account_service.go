@@ -35,6 +35,9 @@ type Account struct {
 }
 
 func (s *GetAccountService) Do(ctx context.Context) (*Account, error) {
+	if s.c.paper != nil {
+		return s.c.paper.account(s.c), nil
+	}
 	r := newRequest(http.MethodGet, endPointWithFormat("/account"), true)
 	byteData, err := s.c.callAPI(ctx, r)
 	if err != nil {
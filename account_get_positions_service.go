@@ -23,7 +23,10 @@ type PositionsResponse struct {
 }
 
 func (s *GetPositionsService) Do(ctx context.Context) ([]Position, error) {
-	r := newRequest(http.MethodGet, endPointWithFormat("/account"), true)
+	if s.c.paper != nil {
+		return s.c.paper.listPositions(s.c), nil
+	}
+	r := newRequest(http.MethodGet, endPointWithFormat("/positions"), true)
 	if s.showAvgPrice != nil {
 		r.setParam("showAvgPrice", BoolToString(*s.showAvgPrice))
 	}
@@ -0,0 +1,653 @@
+package ftxapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	bracketClientIDPrefix = "brk-"
+	bracketSizeEpsilon    = 1e-9
+	bracketActionTimeout  = 10 * time.Second
+	bracketRetryDelay     = 5 * time.Second
+)
+
+var ErrBracketNotFound = errors.New("bracket not found")
+
+type BracketState string
+
+const (
+	// BracketStatePending waits for the entry to fill
+	BracketStatePending BracketState = "pending"
+	// BracketStateActive has a filled entry protected by a take profit and a stop
+	BracketStateActive BracketState = "active"
+	// BracketStateTakeProfit was closed by the take profit
+	BracketStateTakeProfit BracketState = "take_profit"
+	// BracketStateStopped was closed by the stop
+	BracketStateStopped BracketState = "stopped"
+	// BracketStateCancelled was cancelled before or without a fill, or with Cancel
+	BracketStateCancelled BracketState = "cancelled"
+)
+
+func (s BracketState) Done() bool {
+	return s == BracketStateTakeProfit || s == BracketStateStopped || s == BracketStateCancelled
+}
+
+// BracketParams describes an entry and its protective orders. The take profit and the stop
+// become market orders when triggered unless a limit price is set.
+type BracketParams struct {
+	Entry           PlaceOrderParams
+	TakeProfitPrice float64
+	StopPrice       float64
+	TakeProfitLimit *float64
+	StopLimit       *float64
+}
+
+// Bracket is the persisted state of a bracket order, it can be saved as JSON and given back to Restore.
+// ExitOrders holds the orders spawned by the current protective legs, ExitFills the fills of every
+// exit order and ExitType the leg that closed the position last.
+type Bracket struct {
+	ID            string
+	Params        BracketParams
+	State         BracketState
+	EntryOrderID  int64
+	EntryClosed   bool
+	EntryFilled   float64
+	EntryAvgPrice float64
+	TakeProfitID  int64
+	StopID        int64
+	ProtectedSize float64
+	ExitOrders    map[int64]TriggerType
+	ExitFills     map[int64]float64
+	ExitType      TriggerType
+	LastError     string
+	UpdatedAt     time.Time
+}
+
+// ExitFilled is the size closed by the protective orders.
+func (b Bracket) ExitFilled() float64 {
+	var res float64
+	for _, f := range b.ExitFills {
+		res += f
+	}
+	return res
+}
+
+type BracketHandler func(b Bracket)
+
+// BracketManager places entries with attached take profit and stop trigger orders. The
+// protective orders follow the filled entry size and one side is cancelled once the other
+// filled. Feed it the orders and fills channels with Handle and persist the brackets from
+// OnChange to recover them with Restore after a restart.
+type BracketManager struct {
+	l         *zap.SugaredLogger
+	c         *Client
+	mu        sync.Mutex
+	brackets  map[string]*bracketEntry
+	byEntryID map[int64]*bracketEntry
+	handlers  []BracketHandler
+}
+
+type bracketEntry struct {
+	actionMu sync.Mutex
+	b        Bracket
+	// reduce only orders of the market not attributed yet, order id to filled size
+	pendingExits map[int64]float64
+	// a failed action is retried once after bracketRetryDelay, guarded by the manager mu
+	retryPending bool
+}
+
+func NewBracketManager(c *Client, l *zap.SugaredLogger) *BracketManager {
+	return &BracketManager{
+		l:         l,
+		c:         c,
+		brackets:  make(map[string]*bracketEntry),
+		byEntryID: make(map[int64]*bracketEntry),
+	}
+}
+
+// OnChange registers h to be called with every new state of a bracket.
+func (m *BracketManager) OnChange(h BracketHandler) *BracketManager {
+	m.mu.Lock()
+	m.handlers = append(m.handlers, h)
+	m.mu.Unlock()
+	return m
+}
+
+func (m *BracketManager) Place(ctx context.Context, params BracketParams) (Bracket, error) {
+	id := NewClientID()[:16]
+	params.Entry.ClientID = StringPointer(bracketClientIDPrefix + id)
+	e := &bracketEntry{
+		b: Bracket{
+			ID:         id,
+			Params:     params,
+			State:      BracketStatePending,
+			ExitOrders: make(map[int64]TriggerType),
+			ExitFills:  make(map[int64]float64),
+			UpdatedAt:  time.Now(),
+		},
+		pendingExits: make(map[int64]float64),
+	}
+	// registered before the request, the entry events can beat the REST answer and find it by client id
+	m.mu.Lock()
+	m.brackets[id] = e
+	m.mu.Unlock()
+	order, err := m.c.NewPlaceOrderService().Params(params.Entry).Do(ctx)
+	if err != nil {
+		m.mu.Lock()
+		linked := e.b.EntryOrderID != 0
+		if !linked {
+			delete(m.brackets, id)
+		}
+		snapshot := e.b.copy()
+		m.mu.Unlock()
+		if !linked {
+			return Bracket{}, err
+		}
+		// the entry was seen on the orders channel, it exists whatever the answer said
+		m.l.With("func", "BracketManager.Place").Warnw("entry placed despite error", "bracket", id, "err", err)
+		return snapshot, nil
+	}
+	m.mu.Lock()
+	e.b.EntryOrderID = order.ID
+	m.byEntryID[order.ID] = e
+	m.updateEntry(e, order.Status, order.FilledSize, order.AvgFillPrice)
+	snapshot := e.b.copy()
+	m.mu.Unlock()
+	m.notify(snapshot)
+	go m.reconcile(e)
+	return snapshot, nil
+}
+
+func (m *BracketManager) Bracket(id string) (Bracket, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.brackets[id]
+	if !ok {
+		return Bracket{}, false
+	}
+	return e.b.copy(), true
+}
+
+// Snapshot returns every bracket for persistence.
+func (m *BracketManager) Snapshot() []Bracket {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]Bracket, 0, len(m.brackets))
+	for _, e := range m.brackets {
+		res = append(res, e.b.copy())
+	}
+	return res
+}
+
+// Restore loads persisted brackets, refreshes the unfinished ones over REST and brings
+// their protective orders back in line with the filled size.
+func (m *BracketManager) Restore(ctx context.Context, brackets []Bracket) error {
+	var entries []*bracketEntry
+	m.mu.Lock()
+	for _, b := range brackets {
+		e := &bracketEntry{b: b.copy(), pendingExits: make(map[int64]float64)}
+		m.brackets[b.ID] = e
+		if b.EntryOrderID != 0 {
+			m.byEntryID[b.EntryOrderID] = e
+		}
+		if !b.State.Done() {
+			entries = append(entries, e)
+		}
+	}
+	m.mu.Unlock()
+	for _, e := range entries {
+		var order *Order
+		var err error
+		if e.b.EntryOrderID != 0 {
+			order, err = m.c.NewGetOrderStatusService().OrderID(e.b.EntryOrderID).Do(ctx)
+		} else {
+			// saved while the placement was in flight
+			order, err = m.c.NewGetOrderStatusByClientIDService().ClientID(bracketClientIDPrefix + e.b.ID).Do(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		e.b.EntryOrderID = order.ID
+		m.byEntryID[order.ID] = e
+		m.updateEntry(e, order.Status, order.FilledSize, order.AvgFillPrice)
+		m.mu.Unlock()
+		if err := m.refreshExits(ctx, e); err != nil {
+			return err
+		}
+		m.reconcile(e)
+	}
+	return nil
+}
+
+// Cancel cancels the entry and the protective orders, a filled position is left open.
+func (m *BracketManager) Cancel(ctx context.Context, id string) error {
+	m.mu.Lock()
+	e, ok := m.brackets[id]
+	m.mu.Unlock()
+	if !ok {
+		return ErrBracketNotFound
+	}
+	e.actionMu.Lock()
+	defer e.actionMu.Unlock()
+	m.mu.Lock()
+	b := e.b.copy()
+	m.mu.Unlock()
+	if !b.EntryClosed {
+		if err := m.c.NewCancelOrderService().OrderID(b.EntryOrderID).Do(ctx); err != nil && !isAlreadyClosed(err) {
+			return err
+		}
+	}
+	if err := m.cancelTrigger(ctx, b.TakeProfitID); err != nil {
+		return err
+	}
+	if err := m.cancelTrigger(ctx, b.StopID); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	e.b.TakeProfitID, e.b.StopID = 0, 0
+	e.b.State = BracketStateCancelled
+	e.b.UpdatedAt = time.Now()
+	snapshot := e.b.copy()
+	m.mu.Unlock()
+	m.notify(snapshot)
+	return nil
+}
+
+// Handle consumes the orders and fills events of the websocket data handler.
+func (m *BracketManager) Handle(res WsReponse) {
+	if res.Orders != nil {
+		m.handleOrder(res.Orders.Data)
+	}
+	if res.Fills != nil {
+		// fills carry no filled total, the matching orders event does the accounting
+		m.handleFill(res.Fills.Data)
+	}
+}
+
+func (m *BracketManager) handleOrder(o WsOrders) {
+	m.mu.Lock()
+	e, ok := m.byEntryID[o.ID]
+	if !ok && o.ClientID != nil && strings.HasPrefix(*o.ClientID, bracketClientIDPrefix) {
+		if e, ok = m.brackets[strings.TrimPrefix(*o.ClientID, bracketClientIDPrefix)]; ok {
+			e.b.EntryOrderID = o.ID
+			m.byEntryID[o.ID] = e
+		}
+	}
+	if ok {
+		m.updateEntry(e, o.Status, o.FilledSize, o.AvgFillPrice)
+		m.mu.Unlock()
+		go m.reconcile(e)
+		return
+	}
+	var touched []*bracketEntry
+	for _, e := range m.brackets {
+		if e.b.State.Done() || e.b.Params.Entry.Market != o.Market || o.Side == e.b.Params.Entry.Side {
+			continue
+		}
+		if _, ok := e.b.ExitOrders[o.ID]; ok {
+			e.b.ExitFills[o.ID] = o.FilledSize
+			touched = append(touched, e)
+		} else if o.ReduceOnly && e.b.State == BracketStateActive {
+			e.pendingExits[o.ID] = o.FilledSize
+			touched = append(touched, e)
+		}
+	}
+	m.mu.Unlock()
+	for _, e := range touched {
+		go m.reconcile(e)
+	}
+}
+
+func (m *BracketManager) handleFill(f WsFills) {
+	m.mu.Lock()
+	var touched []*bracketEntry
+	for _, e := range m.brackets {
+		if e.b.State != BracketStateActive || e.b.Params.Entry.Market != f.Market || f.Side == e.b.Params.Entry.Side {
+			continue
+		}
+		if _, ok := e.b.ExitOrders[f.OrderID]; ok {
+			continue
+		}
+		if _, ok := e.pendingExits[f.OrderID]; !ok {
+			e.pendingExits[f.OrderID] = 0
+			touched = append(touched, e)
+		}
+	}
+	m.mu.Unlock()
+	for _, e := range touched {
+		go m.reconcile(e)
+	}
+}
+
+// updateEntry must be called with m.mu held.
+func (m *BracketManager) updateEntry(e *bracketEntry, status OrderStatus, filled, avg float64) {
+	if filled > e.b.EntryFilled {
+		e.b.EntryFilled = filled
+		e.b.EntryAvgPrice = avg
+	}
+	if status == OrderStatusClosed || status == OrderStatusCancelled || status == OrderStatusFilled {
+		e.b.EntryClosed = true
+	}
+	e.b.UpdatedAt = time.Now()
+}
+
+// reconcile brings the protective orders in line with the bracket state, one action at a time per bracket.
+func (m *BracketManager) reconcile(e *bracketEntry) {
+	e.actionMu.Lock()
+	defer e.actionMu.Unlock()
+	l := m.l.With("func", "BracketManager.reconcile")
+	ctx, cancel := context.WithTimeout(context.Background(), bracketActionTimeout)
+	defer cancel()
+
+	if err := m.attributeExits(ctx, e); err != nil {
+		l.Errorw("cannot attribute exit orders", "bracket", e.b.ID, "err", err)
+	}
+	m.mu.Lock()
+	b := e.b.copy()
+	m.mu.Unlock()
+	if b.State.Done() {
+		return
+	}
+
+	fired, firedSize := b.firedLeg()
+	remaining := b.EntryFilled - b.ExitFilled()
+	var settled []int64
+	var err error
+	switch {
+	case fired != "" && firedSize < b.ProtectedSize-bracketSizeEpsilon:
+		// a partially filled leg still needs its sibling for the rest of the position
+	case fired != "":
+		// the leg closed all it protected, the other one must go
+		sibling := &b.StopID
+		if fired == TriggerTypeStop {
+			sibling = &b.TakeProfitID
+		}
+		if err = m.cancelTrigger(ctx, *sibling); err != nil {
+			break
+		}
+		for id := range b.ExitOrders {
+			settled = append(settled, id)
+		}
+		b.TakeProfitID, b.StopID, b.ProtectedSize = 0, 0, 0
+		b.ExitType = fired
+		if remaining > bracketSizeEpsilon {
+			// the entry filled further since the legs were sized
+			err = m.placeProtection(ctx, &b, remaining)
+		} else if b.EntryClosed {
+			b.State = exitState(fired)
+		}
+	case remaining > bracketSizeEpsilon && (b.TakeProfitID == 0 || b.StopID == 0):
+		err = m.placeProtection(ctx, &b, remaining)
+	case remaining > bracketSizeEpsilon && math.Abs(remaining-b.ProtectedSize) > bracketSizeEpsilon:
+		err = m.resizeProtection(ctx, &b, remaining)
+	case b.EntryClosed && b.EntryFilled <= bracketSizeEpsilon:
+		b.State = BracketStateCancelled
+	case b.EntryClosed && remaining <= bracketSizeEpsilon && b.ExitType != "":
+		b.State = exitState(b.ExitType)
+	}
+	if err != nil {
+		l.Errorw("bracket action failed", "bracket", b.ID, "err", err)
+		b.LastError = err.Error()
+	}
+
+	m.mu.Lock()
+	e.b.State = b.State
+	e.b.TakeProfitID = b.TakeProfitID
+	e.b.StopID = b.StopID
+	e.b.ProtectedSize = b.ProtectedSize
+	e.b.ExitType = b.ExitType
+	e.b.LastError = b.LastError
+	for _, id := range settled {
+		delete(e.b.ExitOrders, id)
+	}
+	e.b.UpdatedAt = time.Now()
+	snapshot := e.b.copy()
+	retry := err != nil && !b.State.Done() && !e.retryPending
+	if retry {
+		e.retryPending = true
+	}
+	m.mu.Unlock()
+	m.notify(snapshot)
+	if retry {
+		time.AfterFunc(bracketRetryDelay, func() {
+			m.mu.Lock()
+			e.retryPending = false
+			m.mu.Unlock()
+			m.reconcile(e)
+		})
+	}
+}
+
+// firedLeg returns the protective leg that spawned orders and the size they filled.
+func (b Bracket) firedLeg() (TriggerType, float64) {
+	filled := make(map[TriggerType]float64)
+	for id, t := range b.ExitOrders {
+		filled[t] += b.ExitFills[id]
+	}
+	var fired TriggerType
+	for t, f := range filled {
+		if fired == "" || f > filled[fired] {
+			fired = t
+		}
+	}
+	return fired, filled[fired]
+}
+
+func exitState(t TriggerType) BracketState {
+	if t == TriggerTypeTakeProfit {
+		return BracketStateTakeProfit
+	}
+	return BracketStateStopped
+}
+
+// placeProtection places the missing protective orders. When the second one fails the first is
+// cancelled again, so a bracket is never left with a take profit and no stop, and the next
+// reconcile places both. A leg left from an earlier failure is resized to size.
+func (m *BracketManager) placeProtection(ctx context.Context, b *Bracket, size float64) error {
+	exitSide := oppositeSide(b.Params.Entry.Side)
+	leftover := b.TakeProfitID != 0 || b.StopID != 0
+	placedTP := false
+	if b.TakeProfitID == 0 {
+		tp, err := m.c.NewPlaceTriggerOrderService().Params(PlaceTriggerOrderParams{
+			Market:       b.Params.Entry.Market,
+			Side:         exitSide,
+			Size:         size,
+			Type:         TriggerTypeTakeProfit,
+			TriggerPrice: &b.Params.TakeProfitPrice,
+			OrderPrice:   b.Params.TakeProfitLimit,
+			ReduceOnly:   BoolPointer(true),
+		}).Do(ctx)
+		if err != nil {
+			return err
+		}
+		b.TakeProfitID = int64(tp.ID)
+		placedTP = true
+	}
+	if b.StopID == 0 {
+		stop, err := m.c.NewPlaceTriggerOrderService().Params(PlaceTriggerOrderParams{
+			Market:       b.Params.Entry.Market,
+			Side:         exitSide,
+			Size:         size,
+			Type:         TriggerTypeStop,
+			TriggerPrice: &b.Params.StopPrice,
+			OrderPrice:   b.Params.StopLimit,
+			ReduceOnly:   BoolPointer(true),
+		}).Do(ctx)
+		if err != nil {
+			if placedTP {
+				if cancelErr := m.cancelTrigger(ctx, b.TakeProfitID); cancelErr != nil {
+					return fmt.Errorf("place stop: %w, cancel take profit: %s", err, cancelErr)
+				}
+				b.TakeProfitID = 0
+			}
+			return fmt.Errorf("place stop: %w", err)
+		}
+		b.StopID = int64(stop.ID)
+	}
+	if leftover {
+		return m.resizeProtection(ctx, b, size)
+	}
+	b.ProtectedSize = size
+	b.State = BracketStateActive
+	return nil
+}
+
+// resizeProtection modifies both protective orders, FTX gives modified trigger orders new ids.
+func (m *BracketManager) resizeProtection(ctx context.Context, b *Bracket, size float64) error {
+	if b.TakeProfitID != 0 {
+		tp, err := m.c.NewModifyTriggerOrderService().OrderID(b.TakeProfitID).Params(ModifyTriggerOrderParams{
+			Size:         size,
+			TriggerPrice: &b.Params.TakeProfitPrice,
+			OrderPrice:   b.Params.TakeProfitLimit,
+		}).Do(ctx)
+		if err != nil {
+			return err
+		}
+		b.TakeProfitID = int64(tp.ID)
+	}
+	if b.StopID != 0 {
+		stop, err := m.c.NewModifyTriggerOrderService().OrderID(b.StopID).Params(ModifyTriggerOrderParams{
+			Size:         size,
+			TriggerPrice: &b.Params.StopPrice,
+			OrderPrice:   b.Params.StopLimit,
+		}).Do(ctx)
+		if err != nil {
+			return err
+		}
+		b.StopID = int64(stop.ID)
+	}
+	b.ProtectedSize = size
+	b.State = BracketStateActive
+	return nil
+}
+
+// attributeExits asks the protective trigger orders which orders they spawned
+// and keeps the pending reduce only orders that belong to this bracket.
+func (m *BracketManager) attributeExits(ctx context.Context, e *bracketEntry) error {
+	m.mu.Lock()
+	if len(e.pendingExits) == 0 {
+		m.mu.Unlock()
+		return nil
+	}
+	pending := make(map[int64]float64, len(e.pendingExits))
+	for id, filled := range e.pendingExits {
+		pending[id] = filled
+	}
+	triggers := map[TriggerType]int64{TriggerTypeTakeProfit: e.b.TakeProfitID, TriggerTypeStop: e.b.StopID}
+	m.mu.Unlock()
+
+	found := make(map[int64]TriggerType)
+	for t, id := range triggers {
+		if id == 0 {
+			continue
+		}
+		res, err := m.c.NewGetTriggerOrderTriggersService().ConditionalOrderID(id).Do(ctx)
+		if err != nil {
+			return err
+		}
+		for _, tr := range res {
+			if tr.OrderID != nil {
+				found[*tr.OrderID] = t
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id := range pending {
+		if t, ok := found[id]; ok {
+			e.b.ExitOrders[id] = t
+			if filled := e.pendingExits[id]; filled > e.b.ExitFills[id] {
+				e.b.ExitFills[id] = filled
+			}
+		}
+		// orders not spawned by our triggers belong to someone else
+		delete(e.pendingExits, id)
+	}
+	return nil
+}
+
+// refreshExits reloads the orders spawned by the protective triggers, used after a restart.
+func (m *BracketManager) refreshExits(ctx context.Context, e *bracketEntry) error {
+	m.mu.Lock()
+	triggers := map[TriggerType]int64{TriggerTypeTakeProfit: e.b.TakeProfitID, TriggerTypeStop: e.b.StopID}
+	m.mu.Unlock()
+	for t, id := range triggers {
+		if id == 0 {
+			continue
+		}
+		res, err := m.c.NewGetTriggerOrderTriggersService().ConditionalOrderID(id).Do(ctx)
+		if err != nil {
+			return err
+		}
+		for _, tr := range res {
+			if tr.OrderID == nil {
+				continue
+			}
+			var filled float64
+			if tr.FilledSize != nil {
+				filled = *tr.FilledSize
+			}
+			m.mu.Lock()
+			e.b.ExitOrders[*tr.OrderID] = t
+			if filled > e.b.ExitFills[*tr.OrderID] {
+				e.b.ExitFills[*tr.OrderID] = filled
+			}
+			m.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func (m *BracketManager) cancelTrigger(ctx context.Context, id int64) error {
+	if id == 0 {
+		return nil
+	}
+	err := m.c.NewCancelTriggerOrderService().OrderID(id).Do(ctx)
+	if err != nil && !isAlreadyClosed(err) {
+		return err
+	}
+	return nil
+}
+
+func (m *BracketManager) notify(b Bracket) {
+	m.mu.Lock()
+	handlers := m.handlers
+	m.mu.Unlock()
+	for _, h := range handlers {
+		h(b)
+	}
+}
+
+func (b Bracket) copy() Bracket {
+	res := b
+	res.ExitOrders = make(map[int64]TriggerType, len(b.ExitOrders))
+	for k, v := range b.ExitOrders {
+		res.ExitOrders[k] = v
+	}
+	res.ExitFills = make(map[int64]float64, len(b.ExitFills))
+	for k, v := range b.ExitFills {
+		res.ExitFills[k] = v
+	}
+	return res
+}
+
+func oppositeSide(s Side) Side {
+	if s == SideBuy {
+		return SideSell
+	}
+	return SideBuy
+}
+
+func isAlreadyClosed(err error) bool {
+	return errors.Is(err, OrderAlreadyClosed) || errors.Is(err, OrderAlreadyQueued)
+}
@@ -0,0 +1,163 @@
+package ftxapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testBracketServer answers the entry placement and the trigger order calls of a bracket.
+type testBracketServer struct {
+	srv      *testRestServer
+	mu       sync.Mutex
+	nextID   int
+	sizes    map[int]float64
+	triggers map[int]int64
+}
+
+func newTestBracketServer(srv *testRestServer) *testBracketServer {
+	s := &testBracketServer{srv: srv, nextID: 100, sizes: make(map[int]float64), triggers: make(map[int]int64)}
+	srv.handle("POST /conditional_orders", func(w http.ResponseWriter, r *http.Request) {
+		var params PlaceTriggerOrderParams
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		s.mu.Lock()
+		s.nextID++
+		id := s.nextID
+		s.sizes[id] = params.Size
+		s.mu.Unlock()
+		fmt.Fprintf(w, `{"success": true, "result": {"id": %d, "market": "BTC-PERP", "type": %q, "size": %v, "status": "open"}}`, id, params.Type, params.Size)
+		srv.handle(fmt.Sprintf("DELETE /conditional_orders/%d", id), func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"success": true, "result": "Order cancelled"}`)
+		})
+		srv.handle(fmt.Sprintf("GET /conditional_orders/%d/triggers", id), func(w http.ResponseWriter, r *http.Request) {
+			s.mu.Lock()
+			orderID, ok := s.triggers[id]
+			s.mu.Unlock()
+			if !ok {
+				fmt.Fprint(w, `{"success": true, "result": []}`)
+				return
+			}
+			fmt.Fprintf(w, `{"success": true, "result": [{"orderId": %d, "time": "2022-10-05T20:00:00+00:00"}]}`, orderID)
+		})
+	})
+	return s
+}
+
+// fire makes trigger order id report orderID as the order it spawned.
+func (s *testBracketServer) fire(id int64, orderID int64) {
+	s.mu.Lock()
+	s.triggers[int(id)] = orderID
+	s.mu.Unlock()
+}
+
+func testBracketOrder(id int64, clientID *string, side Side, reduceOnly bool, status OrderStatus, size, filled float64) WsReponse {
+	return WsReponse{Orders: &WsOrdersEvent{Data: WsOrders{
+		ID:            id,
+		ClientID:      clientID,
+		Market:        "BTC-PERP",
+		Side:          side,
+		Type:          OrderTypeMarket,
+		Size:          size,
+		FilledSize:    filled,
+		RemainingSize: size - filled,
+		ReduceOnly:    reduceOnly,
+		Status:        status,
+	}}}
+}
+
+func waitForBracket(t *testing.T, m *BracketManager, id, what string, cond func(b Bracket) bool) Bracket {
+	t.Helper()
+	var b Bracket
+	waitFor(t, what, func() bool {
+		b, _ = m.Bracket(id)
+		return cond(b)
+	})
+	return b
+}
+
+func TestBracketEntryEventBeforePlacementAnswer(t *testing.T) {
+	c, srv := newTestClient(t)
+	bs := newTestBracketServer(srv)
+	m := NewBracketManager(c, zap.NewNop().Sugar())
+
+	clientIDs := make(chan string, 1)
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	t.Cleanup(func() { releaseOnce.Do(func() { close(release) }) })
+	srv.handle("POST /orders", func(w http.ResponseWriter, r *http.Request) {
+		var params PlaceOrderParams
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		clientIDs <- *params.ClientID
+		<-release
+		testOrderResult(w, 1, *params.ClientID)
+	})
+	placed := make(chan Bracket, 1)
+	go func() {
+		b, err := m.Place(context.Background(), BracketParams{
+			Entry:           PlaceOrderParams{Market: "BTC-PERP", Side: SideBuy, Type: OrderTypeLimit, Price: 100, Size: 2},
+			TakeProfitPrice: 110,
+			StopPrice:       95,
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		placed <- b
+	}()
+	clientID := <-clientIDs
+	// the entry fills and its events arrive while the placement is still waiting for its answer
+	m.Handle(testBracketOrder(1, &clientID, SideBuy, false, OrderStatusClosed, 2, 2))
+	id := clientID[len(bracketClientIDPrefix):]
+	b := waitForBracket(t, m, id, "protection of the entry", func(b Bracket) bool {
+		return b.State == BracketStateActive && b.TakeProfitID != 0 && b.StopID != 0
+	})
+	releaseOnce.Do(func() { close(release) })
+	<-placed
+	bs.mu.Lock()
+	tpSize, stopSize := bs.sizes[int(b.TakeProfitID)], bs.sizes[int(b.StopID)]
+	bs.mu.Unlock()
+	if b.EntryOrderID != 1 || tpSize != 2 || stopSize != 2 {
+		t.Fatalf("bracket = %+v, legs %v/%v, want both legs sized to the filled entry", b, tpSize, stopSize)
+	}
+}
+
+func TestBracketPartialTakeProfitKeepsStop(t *testing.T) {
+	c, srv := newTestClient(t)
+	bs := newTestBracketServer(srv)
+	m := NewBracketManager(c, zap.NewNop().Sugar())
+	srv.handle("POST /orders", func(w http.ResponseWriter, r *http.Request) {
+		var params PlaceOrderParams
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		testOrderResult(w, 1, *params.ClientID)
+	})
+	b, err := m.Place(context.Background(), BracketParams{
+		Entry:           PlaceOrderParams{Market: "BTC-PERP", Side: SideBuy, Type: OrderTypeLimit, Price: 100, Size: 2},
+		TakeProfitPrice: 110,
+		StopPrice:       95,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Handle(testBracketOrder(1, b.Params.Entry.ClientID, SideBuy, false, OrderStatusClosed, 2, 2))
+	b = waitForBracket(t, m, b.ID, "active bracket", func(b Bracket) bool { return b.State == BracketStateActive })
+	stopCancel := fmt.Sprintf("DELETE /conditional_orders/%d", b.StopID)
+
+	bs.fire(b.TakeProfitID, 500)
+	m.Handle(testBracketOrder(500, nil, SideSell, true, OrderStatusOpen, 2, 1))
+	waitForBracket(t, m, b.ID, "attributed take profit fill", func(b Bracket) bool { return b.ExitFills[500] == 1 })
+	time.Sleep(50 * time.Millisecond)
+	if n := srv.count(stopCancel); n != 0 {
+		t.Fatal("stop cancelled on a partial take profit fill")
+	}
+
+	m.Handle(testBracketOrder(500, nil, SideSell, true, OrderStatusClosed, 2, 2))
+	waitForBracket(t, m, b.ID, "closed by the take profit", func(b Bracket) bool { return b.State == BracketStateTakeProfit })
+	if n := srv.count(stopCancel); n != 1 {
+		t.Fatalf("stop cancelled %d times, want once", n)
+	}
+}
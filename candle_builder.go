@@ -0,0 +1,256 @@
+package ftxapi
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type LateTradePolicy string
+
+const (
+	// LateTradePolicyDrop ignores trades of candles that are already closed
+	LateTradePolicyDrop LateTradePolicy = "drop"
+	// LateTradePolicyAmend folds late trades into the closed candle and emits it again
+	LateTradePolicyAmend LateTradePolicy = "amend"
+)
+
+const (
+	DefaultCandleAmendDepth = 3
+	candleCloseCheckPeriod  = 100 * time.Millisecond
+)
+
+// CandleHandler receives a candle each time one closes, resolution is in seconds like GetHistoricalPricesService.
+// It is called without the builder lock held, so it may call Current and LateDropped.
+type CandleHandler func(market string, resolution int64, candle HistoricalPrice)
+
+// CandleBuilder keeps live OHLCV candles built from the trades channel. Volume is in quote
+// currency, the same as the candles endpoint, so REST history and live candles line up.
+type CandleBuilder struct {
+	c           *Client
+	mu          sync.Mutex
+	emitMu      sync.Mutex
+	resolutions []int64
+	policy      LateTradePolicy
+	amendDepth  int
+	handler     CandleHandler
+	series      map[candleKey]*candleSeries
+	lateDropped uint64
+}
+
+type candleEvent struct {
+	key    candleKey
+	candle HistoricalPrice
+}
+
+type candleKey struct {
+	market     string
+	resolution int64
+}
+
+type candleSeries struct {
+	current   *liveCandle
+	closed    []*liveCandle
+	lastClose float64
+}
+
+type liveCandle struct {
+	HistoricalPrice
+	openTime  time.Time
+	closeTime time.Time
+}
+
+func NewCandleBuilder(c *Client, resolutions []int64, handler CandleHandler) *CandleBuilder {
+	return &CandleBuilder{
+		c:           c,
+		resolutions: resolutions,
+		policy:      LateTradePolicyDrop,
+		amendDepth:  DefaultCandleAmendDepth,
+		handler:     handler,
+		series:      make(map[candleKey]*candleSeries),
+	}
+}
+
+// LatePolicy sets how trades for closed candles are handled, depth is how many closed candles can be amended.
+func (b *CandleBuilder) LatePolicy(policy LateTradePolicy, depth int) *CandleBuilder {
+	b.policy = policy
+	if depth > 0 {
+		b.amendDepth = depth
+	}
+	return b
+}
+
+// Seed loads candles of market since start for every resolution and continues the last one live.
+// The returned history holds only closed candles, keyed by resolution.
+func (b *CandleBuilder) Seed(ctx context.Context, market string, start time.Time) (map[int64][]HistoricalPrice, error) {
+	history := make(map[int64][]HistoricalPrice, len(b.resolutions))
+	now := time.Now()
+	for _, res := range b.resolutions {
+		candles, err := b.c.NewGetHistoricalPricesService().MarketName(market).Resolution(res).
+			StartTime(start.Unix()).EndTime(now.Unix()).Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		b.mu.Lock()
+		s := b.seriesOf(market, res)
+		for _, c := range candles {
+			if c.StartTime.Add(time.Duration(res) * time.Second).After(now) {
+				// still open, continue it from trades
+				s.current = &liveCandle{HistoricalPrice: c, openTime: c.StartTime, closeTime: c.StartTime}
+				continue
+			}
+			history[res] = append(history[res], c)
+			s.lastClose = c.Close
+		}
+		b.mu.Unlock()
+	}
+	return history, nil
+}
+
+// Handle consumes the trades events of the websocket data handler, other events are ignored.
+func (b *CandleBuilder) Handle(res WsReponse) {
+	if res.Trades == nil {
+		return
+	}
+	b.emitMu.Lock()
+	defer b.emitMu.Unlock()
+	var events []candleEvent
+	b.mu.Lock()
+	for _, t := range res.Trades.Data {
+		for _, r := range b.resolutions {
+			b.addTrade(res.Trades.Market, r, t, &events)
+		}
+	}
+	b.mu.Unlock()
+	b.emit(events)
+}
+
+// Run closes candles on time even when no trades arrive until ctx is done.
+func (b *CandleBuilder) Run(ctx context.Context) {
+	t := time.NewTicker(candleCloseCheckPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			b.emitMu.Lock()
+			var events []candleEvent
+			b.mu.Lock()
+			for key, s := range b.series {
+				b.advance(key, s, now, &events)
+			}
+			b.mu.Unlock()
+			b.emit(events)
+			b.emitMu.Unlock()
+		}
+	}
+}
+
+// LateDropped returns how many trades were dropped because their candle was closed.
+func (b *CandleBuilder) LateDropped() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lateDropped
+}
+
+// Current returns the open candle of market at resolution.
+func (b *CandleBuilder) Current(market string, resolution int64) (HistoricalPrice, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.series[candleKey{market: market, resolution: resolution}]
+	if !ok || s.current == nil {
+		return HistoricalPrice{}, false
+	}
+	return s.current.HistoricalPrice, true
+}
+
+// emit calls the handler outside b.mu, emitMu keeps the candles of Handle and Run in order.
+func (b *CandleBuilder) emit(events []candleEvent) {
+	for _, e := range events {
+		b.handler(e.key.market, e.key.resolution, e.candle)
+	}
+}
+
+func (b *CandleBuilder) seriesOf(market string, resolution int64) *candleSeries {
+	key := candleKey{market: market, resolution: resolution}
+	s, ok := b.series[key]
+	if !ok {
+		s = &candleSeries{}
+		b.series[key] = s
+	}
+	return s
+}
+
+func (b *CandleBuilder) addTrade(market string, resolution int64, t WsTrade, events *[]candleEvent) {
+	key := candleKey{market: market, resolution: resolution}
+	s := b.seriesOf(market, resolution)
+	start := t.Time.Truncate(time.Duration(resolution) * time.Second)
+	b.advance(key, s, t.Time, events)
+	if s.current == nil {
+		s.current = &liveCandle{HistoricalPrice: HistoricalPrice{StartTime: start}}
+	}
+	c := s.current
+	if start.Before(c.StartTime) {
+		c = nil
+		for _, closed := range s.closed {
+			if closed.StartTime.Equal(start) {
+				c = closed
+				break
+			}
+		}
+		if b.policy != LateTradePolicyAmend || c == nil {
+			b.lateDropped++
+			return
+		}
+		c.add(t)
+		*events = append(*events, candleEvent{key: key, candle: c.HistoricalPrice})
+		return
+	}
+	c.add(t)
+}
+
+// advance closes the current candle once now is past its end and emits flat
+// candles for periods without trades, like the candles endpoint does.
+func (b *CandleBuilder) advance(key candleKey, s *candleSeries, now time.Time, events *[]candleEvent) {
+	res := time.Duration(key.resolution) * time.Second
+	for s.current != nil && !now.Before(s.current.StartTime.Add(res)) {
+		c := s.current
+		if c.openTime.IsZero() {
+			if s.lastClose == 0 {
+				// nothing traded yet, there is no price to carry forward
+				s.current = nil
+				return
+			}
+			// no trades in this period
+			c.Open, c.High, c.Low, c.Close = s.lastClose, s.lastClose, s.lastClose, s.lastClose
+		}
+		s.lastClose = c.Close
+		s.closed = append(s.closed, c)
+		if len(s.closed) > b.amendDepth {
+			s.closed = s.closed[len(s.closed)-b.amendDepth:]
+		}
+		*events = append(*events, candleEvent{key: key, candle: c.HistoricalPrice})
+		s.current = &liveCandle{HistoricalPrice: HistoricalPrice{StartTime: c.StartTime.Add(res)}}
+	}
+}
+
+func (c *liveCandle) add(t WsTrade) {
+	if c.openTime.IsZero() {
+		c.Open, c.High, c.Low, c.Close = t.Price, t.Price, t.Price, t.Price
+		c.openTime, c.closeTime = t.Time, t.Time
+	}
+	if t.Time.Before(c.openTime) {
+		c.Open, c.openTime = t.Price, t.Time
+	}
+	if !t.Time.Before(c.closeTime) {
+		c.Close, c.closeTime = t.Price, t.Time
+	}
+	if t.Price > c.High {
+		c.High = t.Price
+	}
+	if t.Price < c.Low {
+		c.Low = t.Price
+	}
+	c.Volume += t.Price * t.Size
+}
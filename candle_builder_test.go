@@ -0,0 +1,38 @@
+package ftxapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCandleBuilderHandlerMayCallBuilder(t *testing.T) {
+	var b *CandleBuilder
+	var closed []HistoricalPrice
+	b = NewCandleBuilder(nil, []int64{60}, func(market string, resolution int64, candle HistoricalPrice) {
+		closed = append(closed, candle)
+		// would deadlock if the handler ran under the builder lock
+		b.Current(market, resolution)
+	})
+	start := time.Date(2022, 10, 5, 20, 0, 0, 0, time.UTC)
+	trades := func(trades ...WsTrade) WsReponse {
+		return WsReponse{Trades: &WsTradesEvent{baseWsEvent: baseWsEvent{Market: "BTC-PERP"}, Data: trades}}
+	}
+	b.Handle(trades(WsTrade{Price: 100, Size: 1, Time: start.Add(10 * time.Second)}, WsTrade{Price: 105, Size: 1, Time: start.Add(20 * time.Second)}))
+
+	done := make(chan struct{})
+	go func() {
+		b.Handle(trades(WsTrade{Price: 103, Size: 2, Time: start.Add(70 * time.Second)}))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler calling Current deadlocked")
+	}
+	if len(closed) != 1 || closed[0].Open != 100 || closed[0].High != 105 || closed[0].Close != 105 || closed[0].Volume != 205 {
+		t.Fatalf("closed candles = %+v", closed)
+	}
+	if c, ok := b.Current("BTC-PERP", 60); !ok || c.Open != 103 || !c.StartTime.Equal(start.Add(time.Minute)) {
+		t.Fatalf("current = %+v, %v", c, ok)
+	}
+}
@@ -27,6 +27,8 @@ type Client struct {
 	baseURL    string
 	httpClient *http.Client
 	subAccount *string
+	risk       RiskGate
+	paper      *PaperExchange
 }
 type Config struct {
 	ApiKey          string
@@ -35,6 +37,10 @@ type Config struct {
 	Logger          *zap.SugaredLogger
 	HttpClient      *http.Client
 	SubAccount      *string
+	// RiskGate checks orders and withdrawals before they are sent, see RiskEngine
+	RiskGate RiskGate
+	// PaperExchange simulates order and wallet calls instead of sending them, see PaperExchange
+	PaperExchange *PaperExchange
 }
 
 //func NewClient(apiKey, apiSecret, baseURL string, l *zap.SugaredLogger) *Client {
@@ -46,6 +52,8 @@ func NewClient(cfg Config) *Client {
 		apiSecret:  cfg.ApiSecret,
 		baseURL:    DefaultRestAPIEndpoint,
 		subAccount: cfg.SubAccount,
+		risk:       cfg.RiskGate,
+		paper:      cfg.PaperExchange,
 	}
 	if cfg.HttpClient == nil {
 		t := http.DefaultTransport.(*http.Transport).Clone()
@@ -68,10 +76,45 @@ func (c *Client) SubAccount(subaccount *string) *Client {
 	return c
 }
 
+// withSubAccount returns a copy of the client acting for sa, an empty sa is the main account.
+func (c *Client) withSubAccount(sa string) *Client {
+	cc := *c
+	if sa == "" {
+		cc.subAccount = nil
+	} else {
+		cc.subAccount = &sa
+	}
+	return &cc
+}
+
+// subAccountName returns the subaccount of the client, empty for the main account.
+func (c *Client) subAccountName() string {
+	if c == nil || c.subAccount == nil {
+		return ""
+	}
+	return *c.subAccount
+}
+
 var ErrorRateLimit = errors.New("error_rate_limit")
 var OrderAlreadyClosed = errors.New("order_already_closed")
 var OrderAlreadyQueued = errors.New("order_already_queued_for_cancellation")
 
+// APIError is a request the API answered with an error status. Only a decoded error below 500
+// is known to have been rejected, see isAmbiguousError.
+type APIError struct {
+	StatusCode int
+	Message    string
+	// decoded is set when the body was an API error response and not e.g. a proxy page
+	decoded bool
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("unexpected status code = %d", e.StatusCode)
+	}
+	return fmt.Sprintf("unexpected status code = %d, error = %s", e.StatusCode, e.Message)
+}
+
 func (c *Client) callAPI(ctx context.Context, r *request) ([]byte, error) {
 	req, err := c.parsedequest(ctx, r)
 	if err != nil {
@@ -100,7 +143,7 @@ func (c *Client) callAPI(ctx context.Context, r *request) ([]byte, error) {
 		var respData basicResponse
 		err := json.Unmarshal(respBody, &respData)
 		if err != nil {
-			return nil, fmt.Errorf("unexpected status code = %d", resp.StatusCode)
+			return nil, &APIError{StatusCode: resp.StatusCode}
 		}
 		switch respData.Error {
 		case "Order already closed":
@@ -109,7 +152,7 @@ func (c *Client) callAPI(ctx context.Context, r *request) ([]byte, error) {
 			return nil, OrderAlreadyQueued
 
 		}
-		return nil, fmt.Errorf("unexpected status code = %d, error = %s", resp.StatusCode, respData.Error)
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: respData.Error, decoded: true}
 	}
 	return respBody, nil
 }
@@ -387,6 +430,12 @@ func (c *Client) NewPlaceTriggerOrderService() *PlaceTriggerOrderService {
 	}
 }
 
+func (c *Client) NewGetTriggerOrderTriggersService() *GetTriggerOrderTriggersService {
+	return &GetTriggerOrderTriggersService{
+		c: c,
+	}
+}
+
 func (c *Client) NewModifyOrderService() *ModifyOrderService {
 	return &ModifyOrderService{
 		c: c,
@@ -441,6 +490,12 @@ func (c *Client) NewCancelAllOrderService() *CancelAllOrderService {
 	}
 }
 
+func (c *Client) NewFillsService() *FillsService {
+	return &FillsService{
+		c: c,
+	}
+}
+
 func (c *Client) NewFundingPaymentsService() *FundingPaymentsService {
 	return &FundingPaymentsService{
 		c: c,
@@ -0,0 +1,190 @@
+package ftxapi
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultDeadMansSwitchGrace   = 30 * time.Second
+	DefaultDeadMansSwitchTimeout = 10 * time.Second
+)
+
+type DeadMansSwitchReason string
+
+const (
+	DeadMansSwitchDisconnected     DeadMansSwitchReason = "disconnected"
+	DeadMansSwitchHeartbeatMissing DeadMansSwitchReason = "heartbeat_missing"
+)
+
+// DeadMansSwitchConfig lists what is cancelled when the switch fires. No markets cancels
+// every market, no subaccounts means the subaccount the client is set to.
+type DeadMansSwitchConfig struct {
+	Markets       []string
+	SubAccounts   []string
+	Grace         time.Duration
+	CancelTimeout time.Duration
+}
+
+type DeadMansSwitchResult struct {
+	SubAccount *string
+	Market     *string
+	Err        error
+}
+
+type DeadMansSwitchReport struct {
+	Reason  DeadMansSwitchReason
+	Time    time.Time
+	Results []DeadMansSwitchResult
+}
+
+type DeadMansSwitchHandler func(report DeadMansSwitchReport)
+
+// DeadMansSwitch cancels resting orders with CancelAllOrderService when the private stream
+// drops or the application stops calling Heartbeat for longer than the grace period.
+type DeadMansSwitch struct {
+	l             *zap.SugaredLogger
+	c             *Client
+	mu            sync.Mutex
+	cfg           DeadMansSwitchConfig
+	handler       DeadMansSwitchHandler
+	armed         bool
+	tripped       bool
+	lastHeartbeat time.Time
+	stopC         chan struct{}
+}
+
+func NewDeadMansSwitch(c *Client, ws *WebsocketService, cfg DeadMansSwitchConfig, handler DeadMansSwitchHandler, l *zap.SugaredLogger) *DeadMansSwitch {
+	if cfg.Grace <= 0 {
+		cfg.Grace = DefaultDeadMansSwitchGrace
+	}
+	if cfg.CancelTimeout <= 0 {
+		cfg.CancelTimeout = DefaultDeadMansSwitchTimeout
+	}
+	d := &DeadMansSwitch{
+		l:       l,
+		c:       c,
+		cfg:     cfg,
+		handler: handler,
+	}
+	ws.OnStateChange(d.onStateChange)
+	return d
+}
+
+// Arm starts watching the connection and the heartbeat, it counts as a heartbeat.
+func (d *DeadMansSwitch) Arm() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.armed {
+		return
+	}
+	d.armed = true
+	d.tripped = false
+	d.lastHeartbeat = time.Now()
+	d.stopC = make(chan struct{})
+	go d.watchHeartbeat(d.stopC)
+}
+
+func (d *DeadMansSwitch) Disarm() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.armed {
+		return
+	}
+	d.armed = false
+	close(d.stopC)
+}
+
+// Heartbeat tells the switch the application is alive, it must be called more often than the grace period.
+func (d *DeadMansSwitch) Heartbeat() {
+	d.mu.Lock()
+	d.lastHeartbeat = time.Now()
+	d.tripped = false
+	d.mu.Unlock()
+}
+
+func (d *DeadMansSwitch) onStateChange(state WsConnState) {
+	if state != WsConnStateDisconnected {
+		return
+	}
+	d.mu.Lock()
+	armed := d.armed
+	d.mu.Unlock()
+	if armed {
+		go d.fire(DeadMansSwitchDisconnected)
+	}
+}
+
+func (d *DeadMansSwitch) watchHeartbeat(stopC chan struct{}) {
+	period := d.cfg.Grace / 4
+	if period < 100*time.Millisecond {
+		period = 100 * time.Millisecond
+	}
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-stopC:
+			return
+		case now := <-t.C:
+			d.mu.Lock()
+			missed := !d.tripped && now.Sub(d.lastHeartbeat) > d.cfg.Grace
+			if missed {
+				// fire once until the heartbeat comes back
+				d.tripped = true
+			}
+			d.mu.Unlock()
+			if missed {
+				d.fire(DeadMansSwitchHeartbeatMissing)
+			}
+		}
+	}
+}
+
+func (d *DeadMansSwitch) fire(reason DeadMansSwitchReason) {
+	l := d.l.With("func", "DeadMansSwitch.fire")
+	l.Warnw("dead man's switch fired, cancelling orders", "reason", reason)
+	ctx, cancel := context.WithTimeout(context.Background(), d.cfg.CancelTimeout)
+	defer cancel()
+
+	clients := []*Client{d.c}
+	if len(d.cfg.SubAccounts) > 0 {
+		clients = clients[:0]
+		for _, sa := range d.cfg.SubAccounts {
+			clients = append(clients, d.c.withSubAccount(sa))
+		}
+	}
+	markets := []*string{nil}
+	if len(d.cfg.Markets) > 0 {
+		markets = markets[:0]
+		for _, m := range d.cfg.Markets {
+			markets = append(markets, StringPointer(m))
+		}
+	}
+
+	report := DeadMansSwitchReport{Reason: reason, Time: time.Now()}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		for _, market := range markets {
+			wg.Add(1)
+			go func(c *Client, market *string) {
+				defer wg.Done()
+				err := c.NewCancelAllOrderService().Params(CancelAllOrderParams{Market: market}).Do(ctx)
+				if err != nil {
+					l.Errorw("cannot cancel orders", "subaccount", c.subAccount, "market", market, "err", err)
+				}
+				mu.Lock()
+				report.Results = append(report.Results, DeadMansSwitchResult{SubAccount: c.subAccount, Market: market, Err: err})
+				mu.Unlock()
+			}(c, market)
+		}
+	}
+	wg.Wait()
+	if d.handler != nil {
+		d.handler(report)
+	}
+}
@@ -0,0 +1,542 @@
+package ftxapi
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type ExecStrategy string
+
+const (
+	// ExecStrategyTWAP sends evenly sized slices
+	ExecStrategyTWAP ExecStrategy = "twap"
+	// ExecStrategyVWAP sizes slices after the historical volume of their time of day
+	ExecStrategyVWAP ExecStrategy = "vwap"
+)
+
+type ExecState string
+
+const (
+	ExecStateRunning   ExecState = "running"
+	ExecStatePaused    ExecState = "paused"
+	ExecStateCancelled ExecState = "cancelled"
+	ExecStateCompleted ExecState = "completed"
+	// ExecStateExpired is the end of a schedule that did not execute the whole size
+	ExecStateExpired ExecState = "expired"
+)
+
+const (
+	DefaultExecSlices         = 10
+	DefaultExecProfileDays    = 7
+	execProfileResolution     = 300
+	execProfileCandlesPerPage = 1500
+	execSettleTimeout         = 10 * time.Second
+	execSizeEpsilon           = 1e-9
+)
+
+var ErrExecNotRunning = errors.New("execution is not running")
+
+// ExecParams describes a parent order worked over Duration in Slices child orders. Children
+// are IOC limit orders at LimitPrice, or market orders without one. MaxParticipation caps the
+// executed size to a fraction of the market volume traded since the start, zero means no cap.
+type ExecParams struct {
+	Strategy         ExecStrategy
+	Market           string
+	Side             Side
+	Size             float64
+	Duration         time.Duration
+	Slices           int
+	LimitPrice       *float64
+	MaxParticipation float64
+	ProfileDays      int
+}
+
+// ExecReport rolls the children up to the parent. Shortfall is the execution cost against the
+// arrival price in basis points, positive when the average price is worse than the arrival.
+type ExecReport struct {
+	Strategy     ExecStrategy
+	Market       string
+	Side         Side
+	Size         float64
+	Filled       float64
+	AvgPrice     float64
+	ArrivalPrice float64
+	Shortfall    float64
+	SlicesSent   int
+	Slices       int
+	State        ExecState
+	Err          error
+}
+
+type ExecReportHandler func(report ExecReport)
+
+// ExecutionAlgo works a parent order with a TWAP or VWAP schedule. Slices falling behind,
+// because of a pause or the participation cap, are caught up by the following ones.
+// Feed it the trades, fills and orders channels with Handle.
+type ExecutionAlgo struct {
+	l       *zap.SugaredLogger
+	c       *Client
+	tickers *TickerCache
+	params  ExecParams
+	handler ExecReportHandler
+
+	mu            sync.Mutex
+	state         ExecState
+	started       bool
+	startTime     time.Time
+	schedule      []float64
+	sizeIncrement float64
+	arrivalPrice  float64
+	marketVolume  float64
+	children      map[int64]*execChild
+	fillIDs       map[int64]struct{}
+	early         map[int64][]WsReponse
+	slicesSent    int
+	lastErr       error
+	kickC         chan struct{}
+	cancelC       chan struct{}
+}
+
+type execChild struct {
+	size float64
+	// fills and order snapshots report the same executions, the larger of the two counts
+	fillSize     float64
+	fillNotional float64
+	filled       float64
+	notional     float64
+	closed       bool
+}
+
+func NewExecutionAlgo(c *Client, tickers *TickerCache, params ExecParams, handler ExecReportHandler, l *zap.SugaredLogger) *ExecutionAlgo {
+	if params.Slices <= 0 {
+		params.Slices = DefaultExecSlices
+	}
+	if params.ProfileDays <= 0 {
+		params.ProfileDays = DefaultExecProfileDays
+	}
+	return &ExecutionAlgo{
+		l:        l,
+		c:        c,
+		tickers:  tickers,
+		params:   params,
+		handler:  handler,
+		state:    ExecStateRunning,
+		children: make(map[int64]*execChild),
+		fillIDs:  make(map[int64]struct{}),
+		early:    make(map[int64][]WsReponse),
+		kickC:    make(chan struct{}, 1),
+		cancelC:  make(chan struct{}),
+	}
+}
+
+// Run builds the schedule, records the arrival price and sends the slices until the
+// schedule is over, Cancel is called or ctx is done.
+func (a *ExecutionAlgo) Run(ctx context.Context) error {
+	market, err := a.c.NewGetSingleMarketsService().MarketName(a.params.Market).Do(ctx)
+	if err != nil {
+		return err
+	}
+	schedule, err := a.buildSchedule(ctx)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.started = true
+	a.startTime = time.Now()
+	a.schedule = schedule
+	a.sizeIncrement = market.SizeIncrement
+	a.arrivalPrice = a.midPrice(market)
+	a.mu.Unlock()
+	a.report()
+
+	interval := a.params.Duration / time.Duration(a.params.Slices)
+	for k := 0; k < a.params.Slices; k++ {
+		if k > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-a.cancelC:
+			case <-time.After(time.Until(a.startTime.Add(time.Duration(k) * interval))):
+			}
+		}
+		if a.State() == ExecStateCancelled {
+			break
+		}
+		a.sendSlice(ctx, k)
+	}
+	a.settle(ctx)
+	a.mu.Lock()
+	if a.state != ExecStateCancelled {
+		a.state = ExecStateCompleted
+		// a rest below the size increment cannot be sent
+		filled, _ := a.filled()
+		if rest := a.params.Size - filled; rest > execSizeEpsilon && rest >= a.sizeIncrement-execSizeEpsilon {
+			a.state = ExecStateExpired
+		}
+	}
+	a.mu.Unlock()
+	a.report()
+	return nil
+}
+
+// Pause stops sending slices, the schedule keeps running and is caught up on Resume.
+func (a *ExecutionAlgo) Pause() error {
+	return a.setState(ExecStateRunning, ExecStatePaused)
+}
+
+func (a *ExecutionAlgo) Resume() error {
+	return a.setState(ExecStatePaused, ExecStateRunning)
+}
+
+// Cancel stops the execution, children are IOC so nothing is left on the book.
+func (a *ExecutionAlgo) Cancel() error {
+	a.mu.Lock()
+	if a.state == ExecStateCancelled || a.state == ExecStateCompleted || a.state == ExecStateExpired {
+		a.mu.Unlock()
+		return ErrExecNotRunning
+	}
+	a.state = ExecStateCancelled
+	close(a.cancelC)
+	a.mu.Unlock()
+	a.report()
+	return nil
+}
+
+func (a *ExecutionAlgo) State() ExecState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state
+}
+
+func (a *ExecutionAlgo) Report() ExecReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.buildReport()
+}
+
+// Handle consumes the trades, fills and orders events of the websocket data handler.
+func (a *ExecutionAlgo) Handle(res WsReponse) {
+	switch {
+	case res.Trades != nil:
+		a.mu.Lock()
+		if a.started && res.Trades.Market == a.params.Market {
+			for _, t := range res.Trades.Data {
+				if !t.Time.Before(a.startTime) {
+					a.marketVolume += t.Size
+				}
+			}
+		}
+		a.mu.Unlock()
+	case res.Fills != nil:
+		f := res.Fills.Data
+		a.mu.Lock()
+		child, ok := a.children[f.OrderID]
+		if !ok {
+			if f.Market == a.params.Market && f.Side == a.params.Side {
+				// the fill may come before the placement response
+				a.early[f.OrderID] = append(a.early[f.OrderID], res)
+			}
+			a.mu.Unlock()
+			return
+		}
+		a.applyFill(child, f)
+		a.mu.Unlock()
+		a.report()
+	case res.Orders != nil:
+		o := res.Orders.Data
+		a.mu.Lock()
+		child, ok := a.children[o.ID]
+		if !ok {
+			if o.Market == a.params.Market && o.Side == a.params.Side {
+				a.early[o.ID] = append(a.early[o.ID], res)
+			}
+			a.mu.Unlock()
+			return
+		}
+		child.update(o.Status, o.FilledSize, o.AvgFillPrice)
+		a.mu.Unlock()
+		a.kick()
+	}
+}
+
+func (a *ExecutionAlgo) sendSlice(ctx context.Context, k int) {
+	l := a.l.With("func", "ExecutionAlgo.sendSlice")
+	a.mu.Lock()
+	if a.state != ExecStateRunning {
+		a.mu.Unlock()
+		return
+	}
+	committed := a.committed()
+	size := a.params.Size*a.schedule[k] - committed
+	if a.params.MaxParticipation > 0 {
+		size = math.Min(size, a.params.MaxParticipation*a.marketVolume-committed)
+	}
+	if inc := a.sizeIncrement; inc > 0 {
+		size = math.Floor(size/inc+execSizeEpsilon) * inc
+	}
+	a.mu.Unlock()
+	if size <= execSizeEpsilon {
+		return
+	}
+
+	params := PlaceOrderParams{
+		Market: a.params.Market,
+		Side:   a.params.Side,
+		Type:   OrderTypeMarket,
+		Size:   size,
+	}
+	if a.params.LimitPrice != nil {
+		params.Type = OrderTypeLimit
+		params.Price = *a.params.LimitPrice
+		params.Ioc = BoolPointer(true)
+	}
+	order, err := a.c.NewPlaceOrderService().Params(params).Do(ctx)
+	a.mu.Lock()
+	a.lastErr = err
+	if err == nil {
+		child, ok := a.children[order.ID]
+		if !ok {
+			child = &execChild{}
+			a.children[order.ID] = child
+		}
+		child.size = size
+		child.update(order.Status, order.FilledSize, order.AvgFillPrice)
+		for _, early := range a.early[order.ID] {
+			if early.Fills != nil {
+				a.applyFill(child, early.Fills.Data)
+			} else {
+				child.update(early.Orders.Data.Status, early.Orders.Data.FilledSize, early.Orders.Data.AvgFillPrice)
+			}
+		}
+		// events of other orders of the market never get a child
+		a.early = make(map[int64][]WsReponse)
+		a.slicesSent++
+	}
+	a.mu.Unlock()
+	if err != nil {
+		l.Errorw("cannot place slice", "market", a.params.Market, "slice", k, "err", err)
+	}
+	a.report()
+}
+
+// applyFill must be called with a.mu held.
+func (a *ExecutionAlgo) applyFill(child *execChild, f WsFills) {
+	if _, ok := a.fillIDs[f.ID]; ok {
+		return
+	}
+	a.fillIDs[f.ID] = struct{}{}
+	child.fillSize += f.Size
+	child.fillNotional += f.Size * f.Price
+}
+
+// settle waits for the last children to close so the report has their fills.
+func (a *ExecutionAlgo) settle(ctx context.Context) {
+	timeout := time.NewTimer(execSettleTimeout)
+	defer timeout.Stop()
+	for {
+		a.mu.Lock()
+		open := false
+		for _, child := range a.children {
+			open = open || !child.closed
+		}
+		a.mu.Unlock()
+		if !open {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout.C:
+			return
+		case <-a.kickC:
+		}
+	}
+}
+
+// buildSchedule returns the cumulative fraction of the size due after each slice.
+func (a *ExecutionAlgo) buildSchedule(ctx context.Context) ([]float64, error) {
+	n := a.params.Slices
+	weights := make([]float64, n)
+	for k := range weights {
+		weights[k] = 1
+	}
+	if a.params.Strategy == ExecStrategyVWAP {
+		profile, err := a.volumeProfile(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// a slice covers the time of day of its interval, with no volume at all it falls back to TWAP
+		start := time.Now().UTC()
+		interval := a.params.Duration / time.Duration(n)
+		var total float64
+		vwap := make([]float64, n)
+		for k := range vwap {
+			from := start.Add(time.Duration(k) * interval)
+			for t := from; t.Before(from.Add(interval)); t = t.Add(execProfileResolution * time.Second) {
+				vwap[k] += profile[profileBucket(t)]
+			}
+			total += vwap[k]
+		}
+		if total > 0 {
+			weights = vwap
+		}
+	}
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	schedule := make([]float64, n)
+	var sum float64
+	for k, w := range weights {
+		sum += w
+		schedule[k] = sum / total
+	}
+	schedule[n-1] = 1
+	return schedule, nil
+}
+
+// volumeProfile averages the candle volume of each five minutes of the day over the profile days.
+func (a *ExecutionAlgo) volumeProfile(ctx context.Context) (map[int]float64, error) {
+	end := time.Now()
+	start := end.AddDate(0, 0, -a.params.ProfileDays)
+	page := time.Duration(execProfileCandlesPerPage*execProfileResolution) * time.Second
+	profile := make(map[int]float64)
+	for from := start; from.Before(end); from = from.Add(page) {
+		to := from.Add(page)
+		if to.After(end) {
+			to = end
+		}
+		candles, err := a.c.NewGetHistoricalPricesService().
+			MarketName(a.params.Market).
+			Resolution(execProfileResolution).
+			StartTime(from.Unix()).
+			EndTime(to.Unix()).
+			Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, candle := range candles {
+			profile[profileBucket(candle.StartTime.UTC())] += candle.Volume / float64(a.params.ProfileDays)
+		}
+	}
+	return profile, nil
+}
+
+func profileBucket(t time.Time) int {
+	return (t.Hour()*3600 + t.Minute()*60 + t.Second()) / execProfileResolution
+}
+
+func (a *ExecutionAlgo) midPrice(market *Market) float64 {
+	if a.tickers != nil {
+		if t, ok := a.tickers.Get(a.params.Market); ok && t.Bid > 0 && t.Ask > 0 {
+			return t.Mid()
+		}
+	}
+	if market.Bid > 0 && market.Ask > 0 {
+		return (market.Bid + market.Ask) / 2
+	}
+	return market.Last
+}
+
+func (a *ExecutionAlgo) setState(from, to ExecState) error {
+	a.mu.Lock()
+	if a.state != from {
+		a.mu.Unlock()
+		return ErrExecNotRunning
+	}
+	a.state = to
+	a.mu.Unlock()
+	a.report()
+	return nil
+}
+
+// committed is the size filled or still working, must be called with a.mu held.
+func (a *ExecutionAlgo) committed() float64 {
+	var res float64
+	for _, child := range a.children {
+		filled, _ := child.executed()
+		if child.closed {
+			res += filled
+		} else {
+			res += math.Max(child.size, filled)
+		}
+	}
+	return res
+}
+
+// filled sums the executions of the children, must be called with a.mu held.
+func (a *ExecutionAlgo) filled() (size, notional float64) {
+	for _, child := range a.children {
+		s, n := child.executed()
+		size += s
+		notional += n
+	}
+	return size, notional
+}
+
+func (a *ExecutionAlgo) buildReport() ExecReport {
+	r := ExecReport{
+		Strategy:     a.params.Strategy,
+		Market:       a.params.Market,
+		Side:         a.params.Side,
+		Size:         a.params.Size,
+		ArrivalPrice: a.arrivalPrice,
+		SlicesSent:   a.slicesSent,
+		Slices:       a.params.Slices,
+		State:        a.state,
+		Err:          a.lastErr,
+	}
+	var notional float64
+	r.Filled, notional = a.filled()
+	if r.Filled > 0 {
+		r.AvgPrice = notional / r.Filled
+	}
+	if r.Filled > 0 && r.ArrivalPrice > 0 {
+		r.Shortfall = (r.AvgPrice - r.ArrivalPrice) / r.ArrivalPrice * 1e4
+		if a.params.Side == SideSell {
+			r.Shortfall = -r.Shortfall
+		}
+	}
+	return r
+}
+
+func (a *ExecutionAlgo) report() {
+	if a.handler == nil {
+		return
+	}
+	a.mu.Lock()
+	r := a.buildReport()
+	a.mu.Unlock()
+	a.handler(r)
+}
+
+func (a *ExecutionAlgo) kick() {
+	select {
+	case a.kickC <- struct{}{}:
+	default:
+	}
+}
+
+// executed returns the filled size and notional of the child, must be called with the algo lock held.
+func (child *execChild) executed() (size, notional float64) {
+	if child.fillSize > child.filled {
+		return child.fillSize, child.fillNotional
+	}
+	return child.filled, child.notional
+}
+
+// update applies an order snapshot, must be called with the algo lock held.
+func (child *execChild) update(status OrderStatus, filled, avg float64) {
+	if filled > child.filled {
+		child.filled = filled
+		child.notional = filled * avg
+	}
+	if status == OrderStatusClosed || status == OrderStatusCancelled || status == OrderStatusFilled {
+		child.closed = true
+	}
+}
@@ -4,10 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
+	"sort"
 	"time"
 )
 
+const (
+	fillsPageSize    = 100
+	fillsMaxPageSize = 5000
+)
+
 type FillsService struct {
 	c         *Client
 	market    *string
@@ -15,6 +22,7 @@ type FillsService struct {
 	orderID   *int64
 	startTime *int64
 	endTime   *int64
+	limit     *int64
 }
 
 func (s *FillsService) Market(market string) *FillsService {
@@ -42,6 +50,11 @@ func (s *FillsService) EndTime(endTime int64) *FillsService {
 	return s
 }
 
+func (s *FillsService) Limit(limit int64) *FillsService {
+	s.limit = &limit
+	return s
+}
+
 type OrderBy string
 
 const (
@@ -63,9 +76,11 @@ type Fill struct {
 	TradeID       int         `json:"tradeId"`
 	Price         float64     `json:"price"`
 	Side          string      `json:"side"`
-	Size          int         `json:"size"`
+	Size          float64     `json:"size"`
 	Time          time.Time   `json:"time"`
 	Type          string      `json:"type"`
+	// subaccount of a paper trading fill
+	subAccount string
 }
 
 type FillResponse struct {
@@ -74,6 +89,9 @@ type FillResponse struct {
 }
 
 func (s *FillsService) Do(ctx context.Context) ([]Fill, error) {
+	if s.c.paper != nil {
+		return s.c.paper.listFills(s.c, s), nil
+	}
 	r := newRequest(http.MethodGet, endPointWithFormat("/fills"), true)
 	if s.market != nil {
 		r.setParam("market", *s.market)
@@ -91,6 +109,9 @@ func (s *FillsService) Do(ctx context.Context) ([]Fill, error) {
 	if s.endTime != nil {
 		r.setParam("end_time", Int64ToString(*s.endTime))
 	}
+	if s.limit != nil {
+		r.setParam("limit", Int64ToString(*s.limit))
+	}
 	byteData, err := s.c.callAPI(ctx, r)
 	if err != nil {
 		return nil, err
@@ -104,3 +125,80 @@ func (s *FillsService) Do(ctx context.Context) ([]Fill, error) {
 	}
 	return result.Result, nil
 }
+
+// fillsSince pages backwards through FillsService and returns the fills since start, oldest first.
+// Pages end on whole seconds, a second that fills a page is fetched again with a larger one. When
+// the largest page is full too the rest of that second is skipped and the fills are returned
+// with ErrPageOverflow.
+func fillsSince(ctx context.Context, c *Client, start time.Time) ([]Fill, error) {
+	var fills []Fill
+	var gap error
+	seen := make(map[int]struct{})
+	end := time.Now()
+	limit := int64(fillsPageSize)
+	for {
+		page, err := c.NewFillsService().StartTime(start.Unix()).EndTime(end.Unix()).Limit(limit).Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range page {
+			// pages overlap on the second they meet
+			if _, ok := seen[f.ID]; !ok {
+				seen[f.ID] = struct{}{}
+				fills = append(fills, f)
+			}
+		}
+		if int64(len(page)) < limit {
+			break
+		}
+		oldest := page[0].Time
+		for _, f := range page {
+			if f.Time.Before(oldest) {
+				oldest = f.Time
+			}
+		}
+		if !oldest.After(start) {
+			break
+		}
+		switch {
+		case oldest.Unix() < end.Unix():
+			end = oldest
+		case limit < fillsMaxPageSize:
+			limit = int64(math.Min(float64(limit*10), fillsMaxPageSize))
+		default:
+			gap = ErrPageOverflow
+			end = time.Unix(end.Unix()-1, 0)
+		}
+	}
+	sort.SliceStable(fills, func(i, j int) bool { return fills[i].Time.Before(fills[j].Time) })
+	return fills, gap
+}
+
+// wsFills converts a REST fill to the websocket form.
+func (f Fill) wsFills() WsFills {
+	res := WsFills{
+		Fee:         f.Fee,
+		FeeCurrency: f.FeeCurrency,
+		FeeRate:     f.FeeRate,
+		ID:          int64(f.ID),
+		Liquidity:   f.Liquidity,
+		Market:      f.Market,
+		OrderID:     int64(f.OrderID),
+		Price:       f.Price,
+		Side:        Side(f.Side),
+		Size:        f.Size,
+		Time:        f.Time,
+		TradeID:     int64(f.TradeID),
+		Type:        f.Type,
+	}
+	if f.Future != "" {
+		res.Future = StringPointer(f.Future)
+	}
+	if base, ok := f.BaseCurrency.(string); ok {
+		res.BaseCurrency = &base
+	}
+	if quote, ok := f.QuoteCurrency.(string); ok {
+		res.QuoteCurrency = quote
+	}
+	return res
+}
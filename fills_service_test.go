@@ -0,0 +1,118 @@
+package ftxapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// serveFills answers FillsService from fills like the exchange: whole second bounds, newest first,
+// at most limit fills. Fails the first failures requests with a server error.
+func serveFills(srv *testRestServer, fills []Fill, failures int) {
+	var mu sync.Mutex
+	srv.handle("GET /fills", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fail := failures > 0
+		failures--
+		mu.Unlock()
+		if fail {
+			testAPIError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		q := r.URL.Query()
+		start, _ := strconv.ParseInt(q.Get("start_time"), 10, 64)
+		end, _ := strconv.ParseInt(q.Get("end_time"), 10, 64)
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		var page []Fill
+		for _, f := range fills {
+			if sec := f.Time.Unix(); sec >= start && sec <= end {
+				page = append(page, f)
+			}
+		}
+		sort.Slice(page, func(i, j int) bool { return page[i].ID > page[j].ID })
+		if len(page) > limit {
+			page = page[:limit]
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": page})
+	})
+}
+
+func testFills(from time.Time, n, perSecond, firstID int) []Fill {
+	fills := make([]Fill, n)
+	for i := range fills {
+		fills[i] = Fill{ID: firstID + i, OrderID: 1, Market: "BTC-PERP", Side: "buy", Price: 100, Size: 0.1,
+			Time: from.Add(time.Duration(i/perSecond) * time.Second).Add(time.Duration(i%perSecond) * time.Microsecond)}
+	}
+	return fills
+}
+
+func TestFillsSincePagesThroughBusySecond(t *testing.T) {
+	c, srv := newTestClient(t)
+	since := time.Now().Add(-time.Minute).Truncate(time.Second)
+	serveFills(srv, append(testFills(since, 10, 1, 1), testFills(since.Add(20*time.Second), 250, 250, 100)...), 0)
+	fills, err := fillsSince(context.Background(), c, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fills) != 260 {
+		t.Fatalf("fetched %d fills, want 260", len(fills))
+	}
+}
+
+func TestFillsSinceReportsOverflowingSecond(t *testing.T) {
+	c, srv := newTestClient(t)
+	since := time.Now().Add(-time.Minute).Truncate(time.Second)
+	serveFills(srv, append(testFills(since, 10, 1, 1), testFills(since.Add(20*time.Second), fillsMaxPageSize+1, fillsMaxPageSize+1, 100)...), 0)
+	fills, err := fillsSince(context.Background(), c, since)
+	if !errors.Is(err, ErrPageOverflow) {
+		t.Fatalf("err = %v, want ErrPageOverflow", err)
+	}
+	if len(fills) < 10 || fills[0].ID != 1 {
+		t.Fatalf("fills before the busy second are missing")
+	}
+}
+
+func TestSubAccountBackfillRetries(t *testing.T) {
+	c, srv := newTestClient(t)
+	since := time.Now().Add(-time.Minute).Truncate(time.Second)
+	serveFills(srv, testFills(since, 3, 1, 1), 1)
+
+	var errs []error
+	var delivered []WsSubAccountReponse
+	m := NewSubAccountStreams("key", "secret", "", []string{""}, zap.NewNop().Sugar()).Backfill(c, since)
+	m.dataHandler = func(res WsSubAccountReponse) { delivered = append(delivered, res) }
+	m.errHandler = func(err error) { errs = append(errs, err) }
+	st := m.newStream("")
+	st.backfilling = true
+	m.runBackfill(st, since)
+
+	if len(errs) != 1 || len(delivered) != 3 || !delivered[0].Fills.Data.Backfilled {
+		t.Fatalf("errors %v, delivered %d fills, want one error and the fills after the retry", errs, len(delivered))
+	}
+}
+
+func TestSubAccountSeenFillsBounded(t *testing.T) {
+	st := &subAccountStream{seenFills: make(map[int64]struct{})}
+	for id := int64(1); id <= subAccountSeenFills+10; id++ {
+		if !st.seeFill(WsFills{ID: id, Time: time.Now()}) {
+			t.Fatalf("fill %d reported seen", id)
+		}
+	}
+	if len(st.seenFills) != subAccountSeenFills {
+		t.Fatalf("%d fills remembered, want %d", len(st.seenFills), subAccountSeenFills)
+	}
+	if st.seeFill(WsFills{ID: subAccountSeenFills + 10}) {
+		t.Fatal("recent fill delivered twice")
+	}
+	if !st.seeFill(WsFills{ID: 1}) {
+		t.Fatal("oldest fill still remembered")
+	}
+}
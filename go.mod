@@ -3,13 +3,11 @@ module github.com/aibotsoft/ftx-api
 go 1.18
 
 require (
-	github.com/bitly/go-simplejson v0.5.0
 	github.com/gorilla/websocket v1.5.0
 	go.uber.org/zap v1.21.0
 )
 
 require (
-	github.com/bmizerany/assert v0.0.0-20160611221934-b7ed37b82869 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.8.0 // indirect
 )
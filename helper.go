@@ -2,6 +2,7 @@ package ftxapi
 
 import (
 	"fmt"
+	"math"
 	"time"
 )
 
@@ -43,3 +44,9 @@ func BoolPointer(i bool) *bool {
 func endPointWithFormat(template string, params ...interface{}) string {
 	return fmt.Sprintf(template, params...)
 }
+
+// floatSecondsToTime converts the float unix seconds used by websocket payloads.
+func floatSecondsToTime(f float64) time.Time {
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
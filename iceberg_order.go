@@ -0,0 +1,540 @@
+package ftxapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultIcebergCheckInterval = time.Second
+	icebergClientIDPrefix       = "ice-"
+	icebergSizeEpsilon          = 1e-9
+)
+
+var ErrIcebergRunning = errors.New("iceberg already running")
+
+// IcebergParams describes an iceberg. Clips are drawn uniformly within ClipSize*(1±ClipVariance)
+// and rounded down to SizeIncrement. With FollowBook the clip is placed PriceOffset behind the
+// best bid or ask, never at a worse price than Price, and replaced when the book moves more than
+// RepriceTolerance away. ID names the iceberg in the clip client ids, set it to recover one.
+type IcebergParams struct {
+	ID               string
+	Market           string
+	Side             Side
+	Price            float64
+	Size             float64
+	ClipSize         float64
+	ClipVariance     float64
+	SizeIncrement    float64
+	PostOnly         bool
+	FollowBook       bool
+	PriceOffset      float64
+	RepriceTolerance float64
+}
+
+type IcebergProgress struct {
+	ID           string
+	Market       string
+	Side         Side
+	Size         float64
+	Filled       float64
+	AvgPrice     float64
+	Clips        int
+	ClipOrderID  int64
+	ClipClientID string
+	ClipSize     float64
+	ClipPrice    float64
+	Done         bool
+	Cancelled    bool
+	Err          error
+}
+
+type IcebergHandler func(progress IcebergProgress)
+
+// Iceberg shows only a clip of a large limit order and places the next clip when one is done.
+// Clips are placed with client ids "ice-<id>-<n>", Run finds them again after a restart. Feed it
+// the fills and orders channels with Handle.
+type Iceberg struct {
+	l        *zap.SugaredLogger
+	c        *Client
+	tickers  *TickerCache
+	params   IcebergParams
+	handler  IcebergHandler
+	interval time.Duration
+	rnd      *rand.Rand
+
+	mu        sync.Mutex
+	running   bool
+	clips     []*icebergClip
+	fillIDs   map[int64]struct{}
+	live      *icebergClip
+	cancelled bool
+	lastErr   error
+	kickC     chan struct{}
+}
+
+type icebergClip struct {
+	seq      int
+	clientID string
+	orderID  int64
+	size     float64
+	price    float64
+	// fills and order snapshots report the same executions, the larger of the two counts
+	fillSize     float64
+	fillNotional float64
+	filled       float64
+	notional     float64
+	closed       bool
+	repricing    bool
+	cancelSent   bool
+}
+
+// NewIceberg returns an iceberg for params, tickers is only needed with FollowBook.
+func NewIceberg(c *Client, tickers *TickerCache, params IcebergParams, handler IcebergHandler, l *zap.SugaredLogger) *Iceberg {
+	if params.ID == "" {
+		params.ID = NewClientID()[:16]
+	}
+	if params.ClipSize <= 0 || params.ClipSize > params.Size {
+		params.ClipSize = params.Size
+	}
+	return &Iceberg{
+		l:        l,
+		c:        c,
+		tickers:  tickers,
+		params:   params,
+		handler:  handler,
+		interval: DefaultIcebergCheckInterval,
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		fillIDs:  make(map[int64]struct{}),
+		kickC:    make(chan struct{}, 1),
+	}
+}
+
+// CheckInterval sets how often the book is checked and failed placements are retried.
+func (i *Iceberg) CheckInterval(interval time.Duration) *Iceberg {
+	i.interval = interval
+	return i
+}
+
+func (i *Iceberg) ID() string {
+	return i.params.ID
+}
+
+// Run recovers the clips already placed under the iceberg id, then works the order until
+// it is filled, cancelled or ctx is done. The live clip stays on the book when ctx is done.
+func (i *Iceberg) Run(ctx context.Context) error {
+	i.mu.Lock()
+	if i.running {
+		i.mu.Unlock()
+		return ErrIcebergRunning
+	}
+	i.running = true
+	i.mu.Unlock()
+	defer func() {
+		i.mu.Lock()
+		i.running = false
+		i.mu.Unlock()
+	}()
+
+	if err := i.recover(ctx); err != nil {
+		return err
+	}
+	t := time.NewTicker(i.interval)
+	defer t.Stop()
+	for {
+		if done := i.step(ctx); done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-i.kickC:
+		case <-t.C:
+		}
+	}
+}
+
+// Cancel stops the iceberg and cancels the live clip. A clip still being placed is cancelled
+// by Run once its order id is known.
+func (i *Iceberg) Cancel(ctx context.Context) error {
+	i.mu.Lock()
+	i.cancelled = true
+	live := i.live
+	send := live != nil && live.orderID != 0 && !live.cancelSent
+	if send {
+		live.cancelSent = true
+	}
+	i.mu.Unlock()
+	if send {
+		if err := i.c.NewCancelOrderService().OrderID(live.orderID).Do(ctx); err != nil && !isAlreadyClosed(err) {
+			i.mu.Lock()
+			live.cancelSent = false
+			i.mu.Unlock()
+			i.kick()
+			return err
+		}
+	}
+	i.kick()
+	i.report()
+	return nil
+}
+
+// Handle consumes the fills and orders events of the websocket data handler.
+func (i *Iceberg) Handle(res WsReponse) {
+	switch {
+	case res.Fills != nil:
+		f := res.Fills.Data
+		i.mu.Lock()
+		clip := i.clipByOrderID(f.OrderID)
+		if clip == nil {
+			i.mu.Unlock()
+			return
+		}
+		if _, ok := i.fillIDs[f.ID]; ok {
+			i.mu.Unlock()
+			return
+		}
+		i.fillIDs[f.ID] = struct{}{}
+		clip.fillSize += f.Size
+		clip.fillNotional += f.Size * f.Price
+		if filled, _ := clip.executed(); filled >= clip.size-icebergSizeEpsilon {
+			clip.closed = true
+		}
+		i.mu.Unlock()
+	case res.Orders != nil:
+		o := res.Orders.Data
+		i.mu.Lock()
+		clip := i.clipByOrderID(o.ID)
+		if clip == nil && o.ClientID != nil {
+			clip = i.clipByClientID(*o.ClientID)
+			if clip != nil {
+				clip.orderID = o.ID
+			}
+		}
+		if clip == nil {
+			i.mu.Unlock()
+			return
+		}
+		clip.update(o.Status, o.FilledSize, o.AvgFillPrice)
+		i.mu.Unlock()
+	default:
+		return
+	}
+	i.kick()
+	i.report()
+}
+
+func (i *Iceberg) Progress() IcebergProgress {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.progress()
+}
+
+// step does the next action and reports whether the iceberg is finished.
+func (i *Iceberg) step(ctx context.Context) bool {
+	l := i.l.With("func", "Iceberg.step")
+	i.mu.Lock()
+	if i.live != nil && i.live.closed {
+		i.live = nil
+	}
+	filled, _ := i.filled()
+	remaining := i.params.Size - filled
+	if i.live == nil && (i.cancelled || remaining <= icebergSizeEpsilon) {
+		i.mu.Unlock()
+		i.report()
+		return true
+	}
+	if i.live != nil && i.live.orderID == 0 {
+		live := i.live
+		i.mu.Unlock()
+		i.lookupClip(ctx, live)
+		return false
+	}
+	if i.cancelled {
+		live := i.live
+		if live.cancelSent {
+			// the cancel is on its way, its orders event closes the clip
+			i.mu.Unlock()
+			return true
+		}
+		live.cancelSent = true
+		i.mu.Unlock()
+		if err := i.c.NewCancelOrderService().OrderID(live.orderID).Do(ctx); err != nil && !isAlreadyClosed(err) {
+			l.Errorw("cannot cancel clip", "iceberg", i.params.ID, "order", live.orderID, "err", err)
+			i.mu.Lock()
+			live.cancelSent = false
+			i.mu.Unlock()
+			return false
+		}
+		i.report()
+		return true
+	}
+	if i.live != nil {
+		live := i.live
+		reprice := !live.repricing && live.orderID != 0 && i.params.FollowBook && i.moved(live.price)
+		if reprice {
+			live.repricing = true
+		}
+		i.mu.Unlock()
+		if reprice {
+			// the orders event of the cancel places the next clip at the new price
+			if err := i.c.NewCancelOrderService().OrderID(live.orderID).Do(ctx); err != nil && !isAlreadyClosed(err) {
+				l.Errorw("cannot cancel clip", "iceberg", i.params.ID, "order", live.orderID, "err", err)
+				i.mu.Lock()
+				live.repricing = false
+				i.mu.Unlock()
+			}
+		}
+		return false
+	}
+
+	price, ok := i.clipPrice()
+	if !ok {
+		i.mu.Unlock()
+		return false
+	}
+	clip := &icebergClip{
+		seq:   len(i.clips) + 1,
+		size:  i.clipSize(remaining),
+		price: price,
+	}
+	clip.clientID = icebergClientID(i.params.ID, clip.seq)
+	// the clip is known before the placement so websocket events racing the response find it
+	i.clips = append(i.clips, clip)
+	i.live = clip
+	i.mu.Unlock()
+
+	params := PlaceOrderParams{
+		Market:   i.params.Market,
+		Side:     i.params.Side,
+		Price:    clip.price,
+		Type:     OrderTypeLimit,
+		Size:     clip.size,
+		ClientID: StringPointer(clip.clientID),
+	}
+	if i.params.PostOnly {
+		params.PostOnly = BoolPointer(true)
+	}
+	res, err := i.c.PlaceOrderIdempotent(ctx, params, DefaultPlacementLookupWindow)
+	i.mu.Lock()
+	switch {
+	case err == nil:
+		clip.orderID = res.Order.ID
+		clip.update(res.Order.Status, res.Order.FilledSize, res.Order.AvgFillPrice)
+		i.lastErr = nil
+	case res.Status == PlacementStatusNotPlaced:
+		// drop the clip, its sequence is reused on the next attempt
+		i.clips = i.clips[:len(i.clips)-1]
+		i.live = nil
+		i.lastErr = err
+	default:
+		// unknown outcome, keep the clip so recovery looks it up again
+		i.lastErr = err
+	}
+	i.mu.Unlock()
+	if err != nil {
+		l.Errorw("cannot place clip", "iceberg", i.params.ID, "clip", clip.clientID, "err", err)
+	}
+	i.report()
+	return false
+}
+
+// lookupClip resolves a clip whose placement outcome was unknown.
+func (i *Iceberg) lookupClip(ctx context.Context, clip *icebergClip) {
+	order, err := i.c.NewGetOrderStatusByClientIDService().ClientID(clip.clientID).Do(ctx)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	switch {
+	case isOrderNotFound(err):
+		if i.live == clip {
+			i.clips = i.clips[:len(i.clips)-1]
+			i.live = nil
+		}
+	case err == nil:
+		clip.orderID = order.ID
+		clip.update(order.Status, order.FilledSize, order.AvgFillPrice)
+	}
+}
+
+// recover loads the clips placed under the iceberg id by looking up the client ids in order.
+func (i *Iceberg) recover(ctx context.Context) error {
+	i.mu.Lock()
+	seq := len(i.clips)
+	i.mu.Unlock()
+	for {
+		seq++
+		clientID := icebergClientID(i.params.ID, seq)
+		order, err := i.c.NewGetOrderStatusByClientIDService().ClientID(clientID).Do(ctx)
+		if isOrderNotFound(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		clip := &icebergClip{
+			seq:      seq,
+			clientID: clientID,
+			orderID:  order.ID,
+			size:     order.Size,
+			price:    order.Price,
+		}
+		clip.update(order.Status, order.FilledSize, order.AvgFillPrice)
+		i.mu.Lock()
+		i.clips = append(i.clips, clip)
+		if !clip.closed {
+			i.live = clip
+		}
+		i.mu.Unlock()
+	}
+	i.report()
+	return nil
+}
+
+// executed returns the filled size and notional of the clip, must be called with the iceberg lock held.
+func (clip *icebergClip) executed() (size, notional float64) {
+	if clip.fillSize > clip.filled {
+		return clip.fillSize, clip.fillNotional
+	}
+	return clip.filled, clip.notional
+}
+
+// update applies an order snapshot, must be called with the iceberg lock held.
+func (clip *icebergClip) update(status OrderStatus, filled, avg float64) {
+	if filled > clip.filled {
+		clip.filled = filled
+		clip.notional = filled * avg
+	}
+	if status == OrderStatusClosed || status == OrderStatusCancelled || status == OrderStatusFilled {
+		clip.closed = true
+	}
+}
+
+func (i *Iceberg) clipSize(remaining float64) float64 {
+	size := i.params.ClipSize
+	if v := i.params.ClipVariance; v > 0 {
+		size *= 1 + v*(2*i.rnd.Float64()-1)
+	}
+	if inc := i.params.SizeIncrement; inc > 0 {
+		size = math.Max(math.Floor(size/inc)*inc, inc)
+	}
+	// a small rest is not worth a clip of its own
+	if size > remaining || remaining-size < i.params.SizeIncrement {
+		size = remaining
+	}
+	return size
+}
+
+// clipPrice must be called with the iceberg lock held.
+func (i *Iceberg) clipPrice() (float64, bool) {
+	if !i.params.FollowBook {
+		return i.params.Price, true
+	}
+	return i.bookPrice()
+}
+
+func (i *Iceberg) bookPrice() (float64, bool) {
+	if i.tickers == nil {
+		return 0, false
+	}
+	t, ok := i.tickers.Get(i.params.Market)
+	if !ok {
+		return 0, false
+	}
+	if i.params.Side == SideBuy {
+		if t.Bid <= 0 {
+			return 0, false
+		}
+		return math.Min(t.Bid-i.params.PriceOffset, i.params.Price), true
+	}
+	if t.Ask <= 0 {
+		return 0, false
+	}
+	return math.Max(t.Ask+i.params.PriceOffset, i.params.Price), true
+}
+
+func (i *Iceberg) moved(price float64) bool {
+	target, ok := i.bookPrice()
+	return ok && math.Abs(target-price) > i.params.RepriceTolerance
+}
+
+func (i *Iceberg) clipByOrderID(id int64) *icebergClip {
+	for j := len(i.clips) - 1; j >= 0; j-- {
+		if i.clips[j].orderID == id {
+			return i.clips[j]
+		}
+	}
+	return nil
+}
+
+func (i *Iceberg) clipByClientID(clientID string) *icebergClip {
+	for j := len(i.clips) - 1; j >= 0; j-- {
+		if i.clips[j].clientID == clientID {
+			return i.clips[j]
+		}
+	}
+	return nil
+}
+
+func (i *Iceberg) filled() (size, avg float64) {
+	var notional float64
+	for _, clip := range i.clips {
+		s, n := clip.executed()
+		size += s
+		notional += n
+	}
+	if size > 0 {
+		avg = notional / size
+	}
+	return size, avg
+}
+
+func (i *Iceberg) progress() IcebergProgress {
+	filled, avg := i.filled()
+	p := IcebergProgress{
+		ID:        i.params.ID,
+		Market:    i.params.Market,
+		Side:      i.params.Side,
+		Size:      i.params.Size,
+		Filled:    filled,
+		AvgPrice:  avg,
+		Clips:     len(i.clips),
+		Cancelled: i.cancelled,
+		Err:       i.lastErr,
+	}
+	if i.live != nil {
+		p.ClipOrderID = i.live.orderID
+		p.ClipClientID = i.live.clientID
+		p.ClipSize = i.live.size
+		p.ClipPrice = i.live.price
+	}
+	p.Done = (i.live == nil || i.live.closed) && (i.cancelled || i.params.Size-filled <= icebergSizeEpsilon)
+	return p
+}
+
+func (i *Iceberg) report() {
+	if i.handler == nil {
+		return
+	}
+	i.mu.Lock()
+	p := i.progress()
+	i.mu.Unlock()
+	i.handler(p)
+}
+
+func (i *Iceberg) kick() {
+	select {
+	case i.kickC <- struct{}{}:
+	default:
+	}
+}
+
+func icebergClientID(id string, seq int) string {
+	return fmt.Sprintf("%s%s-%d", icebergClientIDPrefix, id, seq)
+}
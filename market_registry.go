@@ -0,0 +1,273 @@
+package ftxapi
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MarketChangeType string
+
+const (
+	MarketChangeListed     MarketChangeType = "listed"
+	MarketChangeDelisted   MarketChangeType = "delisted"
+	MarketChangePostOnly   MarketChangeType = "post_only"
+	MarketChangeRestricted MarketChangeType = "restricted"
+)
+
+// MarketInfo is the static description of a market, Future is nil for spot markets.
+type MarketInfo struct {
+	Name                  string
+	Type                  string
+	Underlying            string
+	BaseCurrency          *string
+	QuoteCurrency         *string
+	Enabled               bool
+	PostOnly              bool
+	Restricted            bool
+	HighLeverageFeeExempt bool
+	PriceIncrement        float64
+	SizeIncrement         float64
+	Future                *WsFuture
+}
+
+// MarketChange is sent when a market is listed, delisted or its post only or restricted flag flips.
+// Previous is nil for listings.
+type MarketChange struct {
+	Type     MarketChangeType
+	Market   MarketInfo
+	Previous *MarketInfo
+}
+
+type MarketChangeHandler func(change MarketChange)
+
+// MarketRegistry is a thread-safe map of markets seeded from REST and kept
+// current from the markets channel through Handle.
+type MarketRegistry struct {
+	mu       sync.RWMutex
+	c        *Client
+	markets  map[string]MarketInfo
+	handlers []MarketChangeHandler
+}
+
+func NewMarketRegistry(c *Client) *MarketRegistry {
+	return &MarketRegistry{
+		c:       c,
+		markets: make(map[string]MarketInfo),
+	}
+}
+
+// OnChange registers h for listing, delisting, post only and restricted changes.
+func (r *MarketRegistry) OnChange(h MarketChangeHandler) *MarketRegistry {
+	r.mu.Lock()
+	r.handlers = append(r.handlers, h)
+	r.mu.Unlock()
+	return r
+}
+
+// Seed loads every market and future over REST and replaces the registry content.
+func (r *MarketRegistry) Seed(ctx context.Context) error {
+	markets, err := r.c.NewGetMarketsService().Do(ctx)
+	if err != nil {
+		return err
+	}
+	futures, err := r.c.NewGetListFutureService().Do(ctx)
+	if err != nil {
+		return err
+	}
+	mapFutures := make(map[string]Future, len(futures))
+	for _, f := range futures {
+		mapFutures[f.Name] = f
+	}
+	next := make(map[string]MarketInfo, len(markets))
+	for _, m := range markets {
+		info := MarketInfo{
+			Name:                  m.Name,
+			Type:                  m.Type,
+			Underlying:            m.Underlying,
+			BaseCurrency:          m.BaseCurrency,
+			QuoteCurrency:         m.QuoteCurrency,
+			Enabled:               m.Enabled,
+			PostOnly:              m.PostOnly,
+			Restricted:            m.Restricted,
+			HighLeverageFeeExempt: m.HighLeverageFeeExempt,
+			PriceIncrement:        m.PriceIncrement,
+			SizeIncrement:         m.SizeIncrement,
+		}
+		if f, ok := mapFutures[m.Name]; ok {
+			info.Future = futureToWsFuture(f)
+			if info.Underlying == "" {
+				info.Underlying = f.Underlying
+			}
+		}
+		next[m.Name] = info
+	}
+	r.replace(next)
+	return nil
+}
+
+// Handle consumes the markets events of the websocket data handler, other events are ignored.
+func (r *MarketRegistry) Handle(res WsReponse) {
+	if res.Markets == nil {
+		return
+	}
+	data := res.Markets.Data
+	if data.Action == PartialWsDataAction || res.Markets.Type == PartialWsDataAction {
+		next := make(map[string]MarketInfo, len(data.Data))
+		for name, m := range data.Data {
+			next[name] = wsMarketToInfo(name, m)
+		}
+		r.replace(next)
+		return
+	}
+	var changes []MarketChange
+	r.mu.Lock()
+	for name, m := range data.Data {
+		info := wsMarketToInfo(name, m)
+		prev, ok := r.markets[name]
+		r.markets[name] = info
+		if ok {
+			changes = append(changes, diffMarket(prev, info)...)
+		} else {
+			changes = append(changes, MarketChange{Type: MarketChangeListed, Market: info})
+		}
+	}
+	handlers := r.handlers
+	r.mu.Unlock()
+	notifyMarketChanges(handlers, changes)
+}
+
+func (r *MarketRegistry) Get(name string) (MarketInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, ok := r.markets[name]
+	return m, ok
+}
+
+func (r *MarketRegistry) All() []MarketInfo {
+	return r.filter(func(MarketInfo) bool { return true })
+}
+
+func (r *MarketRegistry) ByUnderlying(underlying string) []MarketInfo {
+	return r.filter(func(m MarketInfo) bool { return m.Underlying == underlying })
+}
+
+// ByType filters on the market type, "spot" or "future".
+func (r *MarketRegistry) ByType(marketType string) []MarketInfo {
+	return r.filter(func(m MarketInfo) bool { return m.Type == marketType })
+}
+
+// ByExpiry returns the dated futures expiring at expiry.
+func (r *MarketRegistry) ByExpiry(expiry time.Time) []MarketInfo {
+	return r.filter(func(m MarketInfo) bool {
+		return m.Future != nil && m.Future.Expiry != nil && m.Future.Expiry.Equal(expiry)
+	})
+}
+
+func (r *MarketRegistry) filter(fn func(m MarketInfo) bool) []MarketInfo {
+	r.mu.RLock()
+	res := make([]MarketInfo, 0)
+	for _, m := range r.markets {
+		if fn(m) {
+			res = append(res, m)
+		}
+	}
+	r.mu.RUnlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+func (r *MarketRegistry) replace(next map[string]MarketInfo) {
+	var changes []MarketChange
+	r.mu.Lock()
+	for name, info := range next {
+		if prev, ok := r.markets[name]; ok {
+			changes = append(changes, diffMarket(prev, info)...)
+		} else if len(r.markets) > 0 {
+			changes = append(changes, MarketChange{Type: MarketChangeListed, Market: info})
+		}
+	}
+	for name, prev := range r.markets {
+		if _, ok := next[name]; !ok {
+			prev := prev
+			changes = append(changes, MarketChange{Type: MarketChangeDelisted, Market: prev, Previous: &prev})
+		}
+	}
+	r.markets = next
+	handlers := r.handlers
+	r.mu.Unlock()
+	notifyMarketChanges(handlers, changes)
+}
+
+func diffMarket(prev, next MarketInfo) []MarketChange {
+	var changes []MarketChange
+	p := &prev
+	if prev.Enabled != next.Enabled {
+		t := MarketChangeDelisted
+		if next.Enabled {
+			t = MarketChangeListed
+		}
+		changes = append(changes, MarketChange{Type: t, Market: next, Previous: p})
+	}
+	if prev.PostOnly != next.PostOnly {
+		changes = append(changes, MarketChange{Type: MarketChangePostOnly, Market: next, Previous: p})
+	}
+	if prev.Restricted != next.Restricted {
+		changes = append(changes, MarketChange{Type: MarketChangeRestricted, Market: next, Previous: p})
+	}
+	return changes
+}
+
+func notifyMarketChanges(handlers []MarketChangeHandler, changes []MarketChange) {
+	for _, change := range changes {
+		for _, h := range handlers {
+			h(change)
+		}
+	}
+}
+
+func wsMarketToInfo(name string, m WsMarket) MarketInfo {
+	info := MarketInfo{
+		Name:                  name,
+		Type:                  m.Type,
+		BaseCurrency:          m.BaseCurrency,
+		QuoteCurrency:         m.QuoteCurrency,
+		Enabled:               m.Enabled,
+		PostOnly:              m.PostOnly,
+		Restricted:            m.Restricted,
+		HighLeverageFeeExempt: m.HighLeverageFeeExempt,
+		PriceIncrement:        m.PriceIncrement,
+		SizeIncrement:         m.SizeIncrement,
+		Future:                m.Future,
+	}
+	if m.Name != "" {
+		info.Name = m.Name
+	}
+	if m.Underlying != nil {
+		info.Underlying = *m.Underlying
+	} else if m.Future != nil {
+		info.Underlying = m.Future.Underlying
+	}
+	return info
+}
+
+func futureToWsFuture(f Future) *WsFuture {
+	res := &WsFuture{
+		Description:         f.Description,
+		Enabled:             f.Enabled,
+		Expired:             f.Expired,
+		Name:                f.Name,
+		Perpetual:           f.Perpetual,
+		PositionLimitWeight: int(f.PositionLimitWeight),
+		PostOnly:            f.PostOnly,
+		ImfFactor:           f.ImfFactor,
+		Type:                f.Type,
+		Underlying:          f.Underlying,
+	}
+	if !f.Perpetual && !f.Expiry.IsZero() {
+		expiry := f.Expiry
+		res.Expiry = &expiry
+	}
+	return res
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
 )
 
@@ -29,16 +28,9 @@ type Feed struct {
 	Size  float64 `json:"size"`
 }
 
+// UnmarshalJSON decodes the [price, size] pair FTX sends for every level.
 func (f *Feed) UnmarshalJSON(buf []byte) error {
-	tmp := []interface{}{&f.Price, &f.Size}
-	wantLen := len(tmp)
-	if err := json.Unmarshal(buf, &tmp); err != nil {
-		return err
-	}
-	if g, e := len(tmp), wantLen; g != e {
-		return fmt.Errorf("wrong number of fields: %d != %d", g, e)
-	}
-	return nil
+	return parseFeed(buf, f)
 }
 
 type OrderBook struct {
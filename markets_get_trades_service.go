@@ -13,6 +13,7 @@ type GetTradesService struct {
 	marketName string
 	startTime  *int64
 	endTime    *int64
+	limit      *int64
 }
 
 func (s *GetTradesService) MarketName(marketName string) *GetTradesService {
@@ -30,6 +31,11 @@ func (s *GetTradesService) EndTime(endTime int64) *GetTradesService {
 	return s
 }
 
+func (s *GetTradesService) Limit(limit int64) *GetTradesService {
+	s.limit = &limit
+	return s
+}
+
 type Trade struct {
 	ID          int       `json:"id"`
 	Liquidation bool      `json:"liquidation"`
@@ -52,6 +58,9 @@ func (s *GetTradesService) Do(ctx context.Context) ([]Trade, error) {
 	if s.endTime != nil {
 		r.setParam("end_time", Int64ToString(*s.endTime))
 	}
+	if s.limit != nil {
+		r.setParam("limit", Int64ToString(*s.limit))
+	}
 	byteData, err := s.c.callAPI(ctx, r)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,491 @@
+package ftxapi
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultOrderRetention is how long filled and cancelled orders stay queryable after their last update.
+const DefaultOrderRetention = time.Hour
+
+type ManagedOrderState string
+
+const (
+	ManagedOrderStateNew             ManagedOrderState = "new"
+	ManagedOrderStateOpen            ManagedOrderState = "open"
+	ManagedOrderStatePartiallyFilled ManagedOrderState = "partially_filled"
+	ManagedOrderStateFilled          ManagedOrderState = "filled"
+	ManagedOrderStateCancelled       ManagedOrderState = "cancelled"
+)
+
+// Done reports whether the state is final.
+func (s ManagedOrderState) Done() bool {
+	return s == ManagedOrderStateFilled || s == ManagedOrderStateCancelled
+}
+
+// ManagedOrder is the local view of an order. Conditional orders keep the trigger order id
+// in ID and have Conditional set, they are looked up with TriggerOrder.
+type ManagedOrder struct {
+	ID            int64
+	ClientID      *string
+	Market        string
+	Side          Side
+	Type          OrderType
+	Price         float64
+	Size          float64
+	FilledSize    float64
+	RemainingSize float64
+	AvgFillPrice  float64
+	ReduceOnly    bool
+	PostOnly      bool
+	Ioc           bool
+	State         ManagedOrderState
+	Conditional   bool
+	TriggerType   TriggerType
+	TriggerPrice  float64
+	ReplacedBy    int64
+	Fills         []WsFills
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+type OrderObserver func(order ManagedOrder, prev ManagedOrderState)
+
+// OrderManager records orders placed through it, applies the orders and fills channels
+// to them and notifies observers on every change. Feed it with Handle.
+type OrderManager struct {
+	c             *Client
+	mu            sync.RWMutex
+	orders        map[int64]*ManagedOrder
+	triggerOrders map[int64]*ManagedOrder
+	byClientID    map[string]int64
+	fillIDs       map[int64]struct{}
+	observers     []OrderObserver
+	retention     time.Duration
+	prunedAt      time.Time
+}
+
+func NewOrderManager(c *Client) *OrderManager {
+	return &OrderManager{
+		c:             c,
+		orders:        make(map[int64]*ManagedOrder),
+		triggerOrders: make(map[int64]*ManagedOrder),
+		byClientID:    make(map[string]int64),
+		fillIDs:       make(map[int64]struct{}),
+		retention:     DefaultOrderRetention,
+	}
+}
+
+// Retention sets how long filled and cancelled orders are kept after their last update.
+func (m *OrderManager) Retention(d time.Duration) *OrderManager {
+	m.mu.Lock()
+	if d > 0 {
+		m.retention = d
+	}
+	m.mu.Unlock()
+	return m
+}
+
+// OnUpdate registers an observer called after each change of an order, outside of any lock.
+func (m *OrderManager) OnUpdate(o OrderObserver) *OrderManager {
+	m.mu.Lock()
+	m.observers = append(m.observers, o)
+	m.mu.Unlock()
+	return m
+}
+
+func (m *OrderManager) PlaceOrder(ctx context.Context, params PlaceOrderParams) (*Order, error) {
+	order, err := m.c.NewPlaceOrderService().Params(params).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.Track(*order)
+	return order, nil
+}
+
+// ModifyOrder replaces an order, the old one is cancelled locally and points to the new one.
+func (m *OrderManager) ModifyOrder(ctx context.Context, orderID int64, params ModifyOrderParams) (*Order, error) {
+	order, err := m.c.NewModifyOrderService().OrderID(orderID).Params(params).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.replaced(orderID, *order)
+	return order, nil
+}
+
+func (m *OrderManager) ModifyOrderByClientID(ctx context.Context, clientID string, params ModifyOrderByClientIDParams) (*Order, error) {
+	order, err := m.c.NewModifyOrderByClientIDService().ClientID(clientID).Params(params).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	oldID := m.byClientID[clientID]
+	m.mu.RUnlock()
+	m.replaced(oldID, *order)
+	return order, nil
+}
+
+func (m *OrderManager) PlaceTriggerOrder(ctx context.Context, params PlaceTriggerOrderParams) (*TriggerOrder, error) {
+	order, err := m.c.NewPlaceTriggerOrderService().Params(params).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.TrackTriggerOrder(*order)
+	return order, nil
+}
+
+// Track adds or refreshes an order known from REST.
+func (m *OrderManager) Track(order Order) {
+	m.apply(orderUpdate{
+		id:            order.ID,
+		clientID:      order.ClientID,
+		market:        order.Market,
+		side:          order.Side,
+		orderType:     order.Type,
+		price:         order.Price,
+		size:          order.Size,
+		filledSize:    order.FilledSize,
+		remainingSize: order.RemainingSize,
+		avgFillPrice:  order.AvgFillPrice,
+		reduceOnly:    order.ReduceOnly,
+		postOnly:      order.PostOnly,
+		ioc:           order.Ioc,
+		status:        order.Status,
+		createdAt:     order.CreatedAt,
+	})
+}
+
+func (m *OrderManager) TrackTriggerOrder(order TriggerOrder) {
+	var avg float64
+	if order.AvgFillPrice != nil {
+		avg = *order.AvgFillPrice
+	}
+	var price float64
+	if order.OrderPrice != nil {
+		price = *order.OrderPrice
+	}
+	m.mu.Lock()
+	o, ok := m.triggerOrders[int64(order.ID)]
+	if !ok {
+		o = &ManagedOrder{ID: int64(order.ID), Conditional: true, State: ManagedOrderStateNew, CreatedAt: order.CreatedAt}
+		m.triggerOrders[o.ID] = o
+	}
+	prev := o.State
+	o.Market = order.Market
+	o.Side = order.Side
+	o.Type = order.OrderType
+	o.Price = price
+	o.Size = order.Size
+	o.ReduceOnly = order.ReduceOnly
+	o.TriggerType = order.Type
+	o.TriggerPrice = order.TriggerPrice
+	if order.FilledSize > o.FilledSize {
+		o.FilledSize = order.FilledSize
+		o.AvgFillPrice = avg
+	}
+	o.RemainingSize = o.Size - o.FilledSize
+	m.setState(o, nextOrderState(order.Status, o.FilledSize, o.Size))
+	o.UpdatedAt = time.Now()
+	snapshot := o.copy()
+	observers := m.observers
+	m.prune(o.UpdatedAt)
+	m.mu.Unlock()
+	notifyOrderObservers(observers, snapshot, prev)
+}
+
+// Handle consumes the orders and fills events of the websocket data handler.
+func (m *OrderManager) Handle(res WsReponse) {
+	if res.Orders != nil {
+		o := res.Orders.Data
+		m.apply(orderUpdate{
+			id:            o.ID,
+			clientID:      o.ClientID,
+			market:        o.Market,
+			side:          o.Side,
+			orderType:     o.Type,
+			price:         o.Price,
+			size:          o.Size,
+			filledSize:    o.FilledSize,
+			remainingSize: o.RemainingSize,
+			avgFillPrice:  o.AvgFillPrice,
+			reduceOnly:    o.ReduceOnly,
+			postOnly:      o.PostOnly,
+			ioc:           o.Ioc,
+			status:        o.Status,
+			createdAt:     o.CreatedAt,
+		})
+	}
+	if res.Fills != nil {
+		m.applyFill(res.Fills.Data)
+	}
+}
+
+func (m *OrderManager) Order(id int64) (ManagedOrder, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	o, ok := m.orders[id]
+	if !ok {
+		return ManagedOrder{}, false
+	}
+	return o.copy(), true
+}
+
+func (m *OrderManager) OrderByClientID(clientID string) (ManagedOrder, bool) {
+	m.mu.RLock()
+	id, ok := m.byClientID[clientID]
+	m.mu.RUnlock()
+	if !ok {
+		return ManagedOrder{}, false
+	}
+	return m.Order(id)
+}
+
+func (m *OrderManager) TriggerOrder(id int64) (ManagedOrder, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	o, ok := m.triggerOrders[id]
+	if !ok {
+		return ManagedOrder{}, false
+	}
+	return o.copy(), true
+}
+
+// OpenOrders returns the orders not filled or cancelled yet, an empty market matches all.
+func (m *OrderManager) OpenOrders(market string) []ManagedOrder {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var res []ManagedOrder
+	for _, o := range m.orders {
+		if o.State.Done() || (market != "" && o.Market != market) {
+			continue
+		}
+		res = append(res, o.copy())
+	}
+	return res
+}
+
+// OpenTriggerOrders returns the trigger orders not filled or cancelled yet, an empty market matches all.
+func (m *OrderManager) OpenTriggerOrders(market string) []ManagedOrder {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var res []ManagedOrder
+	for _, o := range m.triggerOrders {
+		if o.State.Done() || (market != "" && o.Market != market) {
+			continue
+		}
+		res = append(res, o.copy())
+	}
+	return res
+}
+
+type orderUpdate struct {
+	id            int64
+	clientID      *string
+	market        string
+	side          Side
+	orderType     OrderType
+	price         float64
+	size          float64
+	filledSize    float64
+	remainingSize float64
+	avgFillPrice  float64
+	reduceOnly    bool
+	postOnly      bool
+	ioc           bool
+	status        OrderStatus
+	createdAt     time.Time
+}
+
+func (m *OrderManager) apply(u orderUpdate) {
+	m.mu.Lock()
+	o, ok := m.orders[u.id]
+	if !ok {
+		o = &ManagedOrder{ID: u.id, State: ManagedOrderStateNew, CreatedAt: u.createdAt}
+		m.orders[u.id] = o
+	}
+	prev := o.State
+	if u.clientID != nil {
+		o.ClientID = u.clientID
+		m.byClientID[*u.clientID] = u.id
+	}
+	o.Market = u.market
+	o.Side = u.side
+	o.Type = u.orderType
+	o.Price = u.price
+	o.Size = u.size
+	o.ReduceOnly = u.reduceOnly
+	o.PostOnly = u.postOnly
+	o.Ioc = u.ioc
+	// updates can arrive out of order between REST and the websocket, fills only grow
+	if u.filledSize >= o.FilledSize {
+		o.FilledSize = u.filledSize
+		o.RemainingSize = u.remainingSize
+		if u.avgFillPrice != 0 {
+			o.AvgFillPrice = u.avgFillPrice
+		}
+	}
+	m.setState(o, nextOrderState(u.status, o.FilledSize, o.Size))
+	o.UpdatedAt = time.Now()
+	snapshot := o.copy()
+	observers := m.observers
+	m.prune(o.UpdatedAt)
+	m.mu.Unlock()
+	notifyOrderObservers(observers, snapshot, prev)
+}
+
+func (m *OrderManager) applyFill(f WsFills) {
+	m.mu.Lock()
+	if _, ok := m.fillIDs[f.ID]; ok {
+		m.mu.Unlock()
+		return
+	}
+	o, ok := m.orders[f.OrderID]
+	if !ok {
+		o = &ManagedOrder{ID: f.OrderID, Market: f.Market, Side: f.Side, State: ManagedOrderStateOpen, CreatedAt: f.Time}
+		m.orders[f.OrderID] = o
+	}
+	if o.State.Done() && o.hasFill(f.ID) {
+		// the ids of done orders are pruned, their own fills tell duplicates apart
+		m.mu.Unlock()
+		return
+	}
+	prev := o.State
+	if !prev.Done() {
+		m.fillIDs[f.ID] = struct{}{}
+	}
+	o.Fills = append(o.Fills, f)
+	var filled, notional float64
+	for _, fill := range o.Fills {
+		filled += fill.Size
+		notional += fill.Size * fill.Price
+	}
+	if filled > o.FilledSize {
+		o.FilledSize = filled
+		o.AvgFillPrice = notional / filled
+		if o.Size > 0 {
+			o.RemainingSize = o.Size - filled
+		}
+	}
+	status := OrderStatusOpen
+	if o.Size > 0 && o.FilledSize >= o.Size {
+		status = OrderStatusClosed
+	}
+	m.setState(o, nextOrderState(status, o.FilledSize, o.Size))
+	o.UpdatedAt = time.Now()
+	snapshot := o.copy()
+	observers := m.observers
+	m.prune(o.UpdatedAt)
+	m.mu.Unlock()
+	notifyOrderObservers(observers, snapshot, prev)
+}
+
+// replaced marks oldID as cancelled by a modify and starts tracking its replacement.
+func (m *OrderManager) replaced(oldID int64, order Order) {
+	m.mu.Lock()
+	old, ok := m.orders[oldID]
+	var snapshot ManagedOrder
+	var prev ManagedOrderState
+	if ok {
+		prev = old.State
+		old.ReplacedBy = order.ID
+		m.setState(old, ManagedOrderStateCancelled)
+		old.UpdatedAt = time.Now()
+		snapshot = old.copy()
+	}
+	observers := m.observers
+	m.mu.Unlock()
+	if ok {
+		notifyOrderObservers(observers, snapshot, prev)
+	}
+	m.Track(order)
+}
+
+// setState moves o forward to next, late snapshots can't move an order back. The only move out of
+// a done state is a cancelled order whose fills racing the cancel complete it. Once the order is
+// done its fill ids are dropped from the shared set, must be called with m.mu held.
+func (m *OrderManager) setState(o *ManagedOrder, next ManagedOrderState) {
+	lateFill := o.State == ManagedOrderStateCancelled && next == ManagedOrderStateFilled
+	if !lateFill && (o.State.Done() || orderStateRank(next) < orderStateRank(o.State)) {
+		return
+	}
+	o.State = next
+	if next.Done() {
+		for _, f := range o.Fills {
+			delete(m.fillIDs, f.ID)
+		}
+	}
+}
+
+// prune drops the orders done for longer than the retention, at most a few times per retention
+// period. Must be called with m.mu held.
+func (m *OrderManager) prune(now time.Time) {
+	if now.Sub(m.prunedAt) < m.retention/4 {
+		return
+	}
+	m.prunedAt = now
+	for _, orders := range []map[int64]*ManagedOrder{m.orders, m.triggerOrders} {
+		for id, o := range orders {
+			if !o.State.Done() || now.Sub(o.UpdatedAt) < m.retention {
+				continue
+			}
+			delete(orders, id)
+			if o.ClientID != nil && m.byClientID[*o.ClientID] == id {
+				delete(m.byClientID, *o.ClientID)
+			}
+		}
+	}
+}
+
+func orderStateRank(s ManagedOrderState) int {
+	switch s {
+	case ManagedOrderStateNew:
+		return 0
+	case ManagedOrderStateOpen:
+		return 1
+	case ManagedOrderStatePartiallyFilled:
+		return 2
+	}
+	return 3
+}
+
+func nextOrderState(status OrderStatus, filled, size float64) ManagedOrderState {
+	switch status {
+	case OrderStatusNew:
+		return ManagedOrderStateNew
+	case OrderStatusOpen, OrderStatusTriggered:
+		if filled > 0 {
+			return ManagedOrderStatePartiallyFilled
+		}
+		return ManagedOrderStateOpen
+	case OrderStatusFilled:
+		return ManagedOrderStateFilled
+	case OrderStatusClosed, OrderStatusCancelled:
+		if size > 0 && filled >= size {
+			return ManagedOrderStateFilled
+		}
+		return ManagedOrderStateCancelled
+	}
+	return ManagedOrderStateNew
+}
+
+func notifyOrderObservers(observers []OrderObserver, o ManagedOrder, prev ManagedOrderState) {
+	for _, obs := range observers {
+		obs(o, prev)
+	}
+}
+
+func (o *ManagedOrder) hasFill(id int64) bool {
+	for _, f := range o.Fills {
+		if f.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *ManagedOrder) copy() ManagedOrder {
+	res := *o
+	res.Fills = append([]WsFills(nil), o.Fills...)
+	return res
+}
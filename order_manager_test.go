@@ -0,0 +1,131 @@
+package ftxapi
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func testOrdersEvent(id int64, status OrderStatus, size, filled float64) WsReponse {
+	return WsReponse{Orders: &WsOrdersEvent{Data: WsOrders{
+		ID:            id,
+		Market:        "BTC-PERP",
+		Side:          SideBuy,
+		Type:          OrderTypeLimit,
+		Price:         100,
+		Size:          size,
+		FilledSize:    filled,
+		RemainingSize: size - filled,
+		Status:        status,
+	}}}
+}
+
+func testFillsEvent(id, orderID int64, price, size float64) WsReponse {
+	return WsReponse{Fills: &WsFillsEvent{Data: WsFills{
+		ID:      id,
+		OrderID: orderID,
+		Market:  "BTC-PERP",
+		Side:    SideBuy,
+		Price:   price,
+		Size:    size,
+		Time:    time.Now(),
+	}}}
+}
+
+func TestOrderManagerStateOnlyMovesForward(t *testing.T) {
+	m := NewOrderManager(nil)
+	m.Handle(testOrdersEvent(1, OrderStatusOpen, 2, 0))
+	// a late REST answer of the placement
+	m.Track(Order{ID: 1, Market: "BTC-PERP", Side: SideBuy, Type: OrderTypeLimit, Price: 100, Size: 2, Status: OrderStatusNew})
+	if o, _ := m.Order(1); o.State != ManagedOrderStateOpen {
+		t.Fatalf("state = %s after stale new, want open", o.State)
+	}
+
+	m.Handle(testFillsEvent(10, 1, 100, 0.5))
+	m.Handle(testOrdersEvent(1, OrderStatusOpen, 2, 0))
+	o, _ := m.Order(1)
+	if o.State != ManagedOrderStatePartiallyFilled || o.FilledSize != 0.5 || o.RemainingSize != 1.5 {
+		t.Fatalf("order = %+v after stale open, want partially filled 0.5", o)
+	}
+
+	m.Handle(testOrdersEvent(1, OrderStatusClosed, 2, 2))
+	m.Handle(testOrdersEvent(1, OrderStatusOpen, 2, 0.5))
+	if o, _ := m.Order(1); o.State != ManagedOrderStateFilled || o.FilledSize != 2 {
+		t.Fatalf("order = %+v, want filled", o)
+	}
+}
+
+func TestOrderManagerTransitions(t *testing.T) {
+	m := NewOrderManager(nil)
+	var seen []ManagedOrderState
+	m.OnUpdate(func(o ManagedOrder, prev ManagedOrderState) {
+		if o.State != prev {
+			seen = append(seen, o.State)
+		}
+	})
+	m.Handle(testOrdersEvent(1, OrderStatusNew, 1, 0))
+	m.Handle(testOrdersEvent(1, OrderStatusOpen, 1, 0))
+	m.Handle(testOrdersEvent(1, OrderStatusOpen, 1, 0.4))
+	m.Handle(testOrdersEvent(1, OrderStatusClosed, 1, 0.4))
+	want := []ManagedOrderState{ManagedOrderStateOpen, ManagedOrderStatePartiallyFilled, ManagedOrderStateCancelled}
+	if len(seen) != len(want) {
+		t.Fatalf("transitions = %v, want %v", seen, want)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("transitions = %v, want %v", seen, want)
+		}
+	}
+}
+
+func TestOrderManagerFillsDeduplicatedAndPruned(t *testing.T) {
+	m := NewOrderManager(nil)
+	m.Handle(testOrdersEvent(1, OrderStatusOpen, 1, 0))
+	m.Handle(testFillsEvent(10, 1, 100, 0.4))
+	m.Handle(testFillsEvent(10, 1, 100, 0.4))
+	m.Handle(testFillsEvent(11, 1, 102, 0.6))
+	o, _ := m.Order(1)
+	if o.State != ManagedOrderStateFilled || len(o.Fills) != 2 || o.FilledSize != 1 || math.Abs(o.AvgFillPrice-101.2) > 1e-9 {
+		t.Fatalf("order = %+v, want filled by two fills at 101.2", o)
+	}
+	if len(m.fillIDs) != 0 {
+		t.Fatalf("fill ids of done orders kept: %v", m.fillIDs)
+	}
+	// a replayed fill of a done order is still recognised
+	m.Handle(testFillsEvent(11, 1, 102, 0.6))
+	if o, _ := m.Order(1); len(o.Fills) != 2 || o.FilledSize != 1 {
+		t.Fatalf("order = %+v after a replayed fill", o)
+	}
+}
+
+func TestOrderManagerFillAfterCancelCompletesOrder(t *testing.T) {
+	m := NewOrderManager(nil)
+	m.Handle(testOrdersEvent(1, OrderStatusOpen, 2, 0))
+	m.Handle(testFillsEvent(10, 1, 100, 1))
+	m.Handle(testOrdersEvent(1, OrderStatusClosed, 2, 1))
+	if o, _ := m.Order(1); o.State != ManagedOrderStateCancelled {
+		t.Fatalf("state = %v, want cancelled", o.State)
+	}
+	// the rest of the order traded before the cancel reached the matching engine
+	m.Handle(testFillsEvent(11, 1, 100, 1))
+	if o, _ := m.Order(1); o.State != ManagedOrderStateFilled || o.FilledSize != 2 {
+		t.Fatalf("order = %+v, want filled", o)
+	}
+}
+
+func TestOrderManagerPrunesDoneOrders(t *testing.T) {
+	m := NewOrderManager(nil).Retention(20 * time.Millisecond)
+	m.Track(Order{ID: 1, ClientID: StringPointer("done"), Market: "BTC-PERP", Size: 1, Status: OrderStatusClosed})
+	m.Track(Order{ID: 2, ClientID: StringPointer("open"), Market: "BTC-PERP", Size: 1, Status: OrderStatusOpen})
+	time.Sleep(30 * time.Millisecond)
+	m.Handle(testOrdersEvent(3, OrderStatusOpen, 1, 0))
+	if _, ok := m.Order(1); ok {
+		t.Fatal("done order kept past the retention")
+	}
+	if _, ok := m.OrderByClientID("done"); ok {
+		t.Fatal("client id of the pruned order kept")
+	}
+	if _, ok := m.OrderByClientID("open"); !ok {
+		t.Fatal("open order pruned")
+	}
+}
@@ -0,0 +1,293 @@
+package ftxapi
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+type DiscrepancyType string
+
+const (
+	// DiscrepancyMissingLocal is an order open on the exchange the order manager doesn't know
+	DiscrepancyMissingLocal DiscrepancyType = "missing_local"
+	// DiscrepancyMissingExchange is an order open locally that the exchange has closed
+	DiscrepancyMissingExchange DiscrepancyType = "missing_exchange"
+	// DiscrepancyFilledSize is an order whose local filled size differs from the exchange
+	DiscrepancyFilledSize DiscrepancyType = "filled_size"
+)
+
+const reconcileSizeEpsilon = 1e-9
+
+// OrderDiscrepancy is a difference found by the reconciler. Local is the order before the
+// repair, Repaired tells whether the order manager was brought in line with the exchange.
+type OrderDiscrepancy struct {
+	Type           DiscrepancyType
+	Market         string
+	OrderID        int64
+	Conditional    bool
+	LocalFilled    float64
+	ExchangeFilled float64
+	Local          *ManagedOrder
+	Repaired       bool
+	Err            error
+}
+
+type DiscrepancyHandler func(d OrderDiscrepancy)
+
+// OrderReconciler compares the open orders of the exchange with the order manager and repairs
+// what the websocket missed. Without markets it compares every market in one request.
+type OrderReconciler struct {
+	c       *Client
+	m       *OrderManager
+	markets []string
+	handler DiscrepancyHandler
+}
+
+func NewOrderReconciler(c *Client, m *OrderManager, markets []string, handler DiscrepancyHandler) *OrderReconciler {
+	return &OrderReconciler{c: c, m: m, markets: markets, handler: handler}
+}
+
+// Reconcile runs one comparison, discrepancies go to the handler and are returned.
+func (r *OrderReconciler) Reconcile(ctx context.Context) ([]OrderDiscrepancy, error) {
+	markets := r.markets
+	if len(markets) == 0 {
+		markets = []string{""}
+	}
+	var res []OrderDiscrepancy
+	for _, market := range markets {
+		d, err := r.reconcileMarket(ctx, market)
+		res = append(res, d...)
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// Run calls Reconcile every interval until ctx is done, errors go to errHandler.
+func (r *OrderReconciler) Run(ctx context.Context, interval time.Duration, errHandler func(err error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := r.Reconcile(ctx); err != nil && errHandler != nil {
+				errHandler(err)
+			}
+		}
+	}
+}
+
+func (r *OrderReconciler) reconcileMarket(ctx context.Context, market string) ([]OrderDiscrepancy, error) {
+	// local state is taken first, orders placed during the requests are left for the next run
+	local := r.m.OpenOrders(market)
+	localTriggers := r.m.OpenTriggerOrders(market)
+
+	openService := r.c.NewGetOpenOrdersService()
+	triggerService := r.c.NewGetOpenTriggerOrdersService()
+	if market != "" {
+		openService.Market(market)
+		triggerService.Market(market)
+	}
+	open, err := openService.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	openTriggers, err := triggerService.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []OrderDiscrepancy
+	emit := func(d OrderDiscrepancy) {
+		res = append(res, d)
+		if r.handler != nil {
+			r.handler(d)
+		}
+	}
+
+	exchange := make(map[int64]Order, len(open))
+	for _, o := range open {
+		exchange[o.ID] = o
+		l, ok := r.m.Order(o.ID)
+		switch {
+		case !ok:
+			r.m.Track(o)
+			emit(OrderDiscrepancy{Type: DiscrepancyMissingLocal, Market: o.Market, OrderID: o.ID, ExchangeFilled: o.FilledSize, Repaired: true})
+		case math.Abs(l.FilledSize-o.FilledSize) > reconcileSizeEpsilon:
+			d, found, err := r.confirm(ctx, l)
+			if err != nil {
+				return res, err
+			}
+			if found {
+				emit(d)
+			}
+		}
+	}
+	for _, l := range local {
+		if _, ok := exchange[l.ID]; ok {
+			continue
+		}
+		d, found, err := r.confirm(ctx, l)
+		if err != nil {
+			return res, err
+		}
+		if found {
+			emit(d)
+		}
+	}
+
+	exchangeTriggers := make(map[int64]Order, len(openTriggers))
+	for _, o := range openTriggers {
+		exchangeTriggers[o.ID] = o
+		l, ok := r.m.TriggerOrder(o.ID)
+		switch {
+		case !ok:
+			r.m.TrackTriggerOrder(openTriggerOrder(o))
+			emit(OrderDiscrepancy{Type: DiscrepancyMissingLocal, Market: o.Market, OrderID: o.ID, Conditional: true, ExchangeFilled: o.FilledSize, Repaired: true})
+		case o.FilledSize-l.FilledSize > reconcileSizeEpsilon:
+			// the list lacks the trigger details, keep the local ones
+			update := openTriggerOrder(o)
+			update.Type = l.TriggerType
+			update.OrderType = l.Type
+			update.TriggerPrice = l.TriggerPrice
+			update.OrderPrice = &l.Price
+			r.m.TrackTriggerOrder(update)
+			emit(OrderDiscrepancy{Type: DiscrepancyFilledSize, Market: o.Market, OrderID: o.ID, Conditional: true,
+				LocalFilled: l.FilledSize, ExchangeFilled: o.FilledSize, Local: &l, Repaired: true})
+		}
+	}
+	for _, l := range localTriggers {
+		if _, ok := exchangeTriggers[l.ID]; ok {
+			continue
+		}
+		d, err := r.repairTrigger(ctx, l)
+		if err != nil {
+			return res, err
+		}
+		emit(d)
+	}
+	return res, nil
+}
+
+// confirm reloads an order with GetOrderStatusService, the websocket may just have been
+// ahead of the list, and repairs the order manager when they really differ. An order the
+// exchange does not know is closed locally with its known fills, other errors are returned.
+func (r *OrderReconciler) confirm(ctx context.Context, l ManagedOrder) (OrderDiscrepancy, bool, error) {
+	d := OrderDiscrepancy{Market: l.Market, OrderID: l.ID, LocalFilled: l.FilledSize, Local: &l}
+	order, err := r.c.NewGetOrderStatusService().OrderID(l.ID).Do(ctx)
+	if isOrderNotFound(err) {
+		r.m.Track(Order{
+			ID:           l.ID,
+			ClientID:     l.ClientID,
+			Market:       l.Market,
+			Side:         l.Side,
+			Type:         l.Type,
+			Price:        l.Price,
+			Size:         l.Size,
+			FilledSize:   l.FilledSize,
+			AvgFillPrice: l.AvgFillPrice,
+			ReduceOnly:   l.ReduceOnly,
+			PostOnly:     l.PostOnly,
+			Ioc:          l.Ioc,
+			Status:       OrderStatusClosed,
+			CreatedAt:    l.CreatedAt,
+		})
+		d.Type = DiscrepancyMissingExchange
+		d.ExchangeFilled = l.FilledSize
+		d.Repaired = true
+		return d, true, nil
+	}
+	if err != nil {
+		return d, false, err
+	}
+	d.ExchangeFilled = order.FilledSize
+	closed := order.Status == OrderStatusClosed || order.Status == OrderStatusCancelled || order.Status == OrderStatusFilled
+	switch {
+	case closed:
+		d.Type = DiscrepancyMissingExchange
+	case math.Abs(order.FilledSize-l.FilledSize) > reconcileSizeEpsilon:
+		d.Type = DiscrepancyFilledSize
+	default:
+		return d, false, nil
+	}
+	r.m.Track(*order)
+	d.Repaired = true
+	return d, true, nil
+}
+
+// repairTrigger looks for a trigger order that left the open list in the trigger order history,
+// paging back until its creation. One found nowhere is cancelled locally.
+func (r *OrderReconciler) repairTrigger(ctx context.Context, l ManagedOrder) (OrderDiscrepancy, error) {
+	d := OrderDiscrepancy{Type: DiscrepancyMissingExchange, Market: l.Market, OrderID: l.ID, Conditional: true, LocalFilled: l.FilledSize, Local: &l}
+	start := l.CreatedAt.Add(-time.Second).Unix()
+	var end int64
+	for {
+		service := r.c.NewGetTriggerOrderHistoryService().Market(l.Market).StartTime(start)
+		if end != 0 {
+			service.EndTime(end)
+		}
+		history, more, err := service.Do(ctx)
+		if err != nil {
+			return d, err
+		}
+		for _, o := range history {
+			if int64(o.ID) == l.ID {
+				// it left the open list, a triggered order is done as well
+				o.Status = OrderStatusClosed
+				r.m.TrackTriggerOrder(o)
+				d.ExchangeFilled = o.FilledSize
+				d.Repaired = true
+				return d, nil
+			}
+		}
+		if !more || len(history) == 0 {
+			break
+		}
+		// newest first, the next page ends at the oldest order of this one
+		oldest := history[len(history)-1].CreatedAt.Unix()
+		if end != 0 && oldest >= end {
+			oldest = end - 1
+		}
+		if oldest < start {
+			break
+		}
+		end = oldest
+	}
+	r.m.TrackTriggerOrder(TriggerOrder{
+		CreatedAt:    l.CreatedAt,
+		ID:           int(l.ID),
+		Market:       l.Market,
+		OrderPrice:   &l.Price,
+		ReduceOnly:   l.ReduceOnly,
+		Side:         l.Side,
+		Size:         l.Size,
+		Status:       OrderStatusCancelled,
+		TriggerPrice: l.TriggerPrice,
+		Type:         l.TriggerType,
+		OrderType:    l.Type,
+		FilledSize:   l.FilledSize,
+	})
+	d.ExchangeFilled = l.FilledSize
+	d.Repaired = true
+	return d, nil
+}
+
+// openTriggerOrder converts an entry of the open trigger orders list, which keeps the trigger type in Type.
+func openTriggerOrder(o Order) TriggerOrder {
+	return TriggerOrder{
+		CreatedAt:  o.CreatedAt,
+		Future:     o.Future,
+		ID:         int(o.ID),
+		Market:     o.Market,
+		ReduceOnly: o.ReduceOnly,
+		Side:       o.Side,
+		Size:       o.Size,
+		Status:     o.Status,
+		Type:       TriggerType(o.Type),
+		FilledSize: o.FilledSize,
+	}
+}
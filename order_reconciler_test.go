@@ -0,0 +1,87 @@
+package ftxapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestReconciler(t *testing.T) (*OrderReconciler, *OrderManager, *testRestServer) {
+	c, srv := newTestClient(t)
+	srv.handle("GET /orders", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"success": true, "result": []}`)
+	})
+	srv.handle("GET /conditional_orders", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"success": true, "result": []}`)
+	})
+	m := NewOrderManager(c)
+	return NewOrderReconciler(c, m, nil, nil), m, srv
+}
+
+func TestReconcilerClosesOrderUnknownToExchange(t *testing.T) {
+	r, m, srv := newTestReconciler(t)
+	srv.handle("GET /orders/1", func(w http.ResponseWriter, req *http.Request) {
+		testAPIError(w, http.StatusNotFound, "Order not found")
+	})
+	m.Track(Order{ID: 1, Market: "BTC-PERP", Side: SideBuy, Type: OrderTypeLimit, Price: 100, Size: 2, FilledSize: 0.5, Status: OrderStatusOpen})
+
+	res, err := r.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Type != DiscrepancyMissingExchange || !res[0].Repaired {
+		t.Fatalf("discrepancies = %+v, want one repaired missing order", res)
+	}
+	if o, _ := m.Order(1); o.State != ManagedOrderStateCancelled || o.FilledSize != 0.5 {
+		t.Fatalf("order = %+v, want cancelled with its fills", o)
+	}
+	if res, err = r.Reconcile(context.Background()); err != nil || len(res) != 0 {
+		t.Fatalf("second pass found %+v, err = %v, want nothing", res, err)
+	}
+}
+
+func TestReconcilerPagesTriggerHistory(t *testing.T) {
+	r, m, srv := newTestReconciler(t)
+	created := time.Date(2022, 10, 5, 20, 0, 0, 0, time.UTC)
+	m.TrackTriggerOrder(TriggerOrder{ID: 5, Market: "BTC-PERP", Side: SideSell, Size: 1, Type: TriggerTypeStop, TriggerPrice: 90, Status: OrderStatusOpen, CreatedAt: created})
+	srv.handle("GET /conditional_orders/history", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("end_time") == "" {
+			// newer orders fill the first page
+			fmt.Fprint(w, `{"success": true, "hasMoreData": true, "result": [
+				{"id": 9, "market": "BTC-PERP", "status": "cancelled", "createdAt": "2022-10-05T20:10:00+00:00"},
+				{"id": 8, "market": "BTC-PERP", "status": "cancelled", "createdAt": "2022-10-05T20:05:00+00:00"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"success": true, "hasMoreData": false, "result": [
+			{"id": 5, "market": "BTC-PERP", "side": "sell", "type": "stop", "orderType": "market", "size": 1, "filledSize": 1, "avgFillPrice": 89.5,
+			 "triggerPrice": 90, "status": "triggered", "createdAt": "2022-10-05T20:00:00+00:00"}]}`)
+	})
+
+	res, err := r.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || !res[0].Repaired || res[0].ExchangeFilled != 1 {
+		t.Fatalf("discrepancies = %+v, want the trigger order repaired from the second page", res)
+	}
+	if o, _ := m.TriggerOrder(5); o.State != ManagedOrderStateFilled {
+		t.Fatalf("trigger order = %+v, want filled", o)
+	}
+}
+
+func TestReconcilerReturnsTriggerHistoryError(t *testing.T) {
+	r, m, srv := newTestReconciler(t)
+	m.TrackTriggerOrder(TriggerOrder{ID: 5, Market: "BTC-PERP", Size: 1, Type: TriggerTypeStop, Status: OrderStatusOpen, CreatedAt: time.Now()})
+	srv.handle("GET /conditional_orders/history", func(w http.ResponseWriter, req *http.Request) {
+		testAPIError(w, http.StatusInternalServerError, "Internal error")
+	})
+	res, err := r.Reconcile(context.Background())
+	if err == nil || len(res) != 0 {
+		t.Fatalf("discrepancies = %+v, err = %v, want the lookup error", res, err)
+	}
+	if o, _ := m.TriggerOrder(5); o.State.Done() {
+		t.Fatalf("trigger order = %+v closed on a lookup error", o)
+	}
+}
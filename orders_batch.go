@@ -0,0 +1,178 @@
+package ftxapi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBatchConcurrency      = 4
+	DefaultBatchRateLimitRetries = 3
+	DefaultBatchRateLimitBackoff = 500 * time.Millisecond
+)
+
+// ErrBatchAborted is the error of the items an all or nothing batch didn't send after a failure.
+var ErrBatchAborted = errors.New("batch aborted")
+
+// BatchOptions tunes PlaceOrders and CancelOrders, zero values are the defaults. A rate limit
+// answer pauses every worker of the batch for the backoff, doubled on each retry of the item.
+// With AllOrNothing the first failed placement stops the batch and the placed orders are cancelled,
+// orders whose placement got no answer are cancelled too when they have a client id.
+type BatchOptions struct {
+	Concurrency      int
+	RateLimitRetries int
+	RateLimitBackoff time.Duration
+	AllOrNothing     bool
+}
+
+type PlaceOrderResult struct {
+	Params      PlaceOrderParams
+	Order       *Order
+	Err         error
+	RolledBack  bool
+	RollbackErr error
+}
+
+type CancelOrderResult struct {
+	OrderID int64
+	Err     error
+}
+
+// PlaceOrders places the orders concurrently, results are in the order of params.
+func (c *Client) PlaceOrders(ctx context.Context, params []PlaceOrderParams, opts BatchOptions) []PlaceOrderResult {
+	b := newBatchRunner(opts)
+	results := make([]PlaceOrderResult, len(params))
+	var failed sync.Once
+	abortC := make(chan struct{})
+	b.run(ctx, len(params), func(ctx context.Context, i int) {
+		results[i].Params = params[i]
+		if opts.AllOrNothing {
+			select {
+			case <-abortC:
+				results[i].Err = ErrBatchAborted
+				return
+			default:
+			}
+		}
+		results[i].Err = b.call(ctx, func() error {
+			order, err := c.NewPlaceOrderService().Params(params[i]).Do(ctx)
+			results[i].Order = order
+			return err
+		})
+		if results[i].Err != nil && opts.AllOrNothing {
+			failed.Do(func() { close(abortC) })
+		}
+	})
+	select {
+	case <-abortC:
+	default:
+		return results
+	}
+
+	var ids []int64
+	var placed []int
+	for i, r := range results {
+		if r.Err == nil {
+			ids = append(ids, r.Order.ID)
+			placed = append(placed, i)
+		}
+	}
+	// the rollback must run even when ctx is what failed the batch
+	rollbackCtx, cancel := context.WithTimeout(context.Background(), DefaultPlacementLookupWindow)
+	defer cancel()
+	for j, res := range c.CancelOrders(rollbackCtx, ids, BatchOptions{Concurrency: opts.Concurrency}) {
+		r := &results[placed[j]]
+		r.RollbackErr = res.Err
+		r.RolledBack = res.Err == nil || isAlreadyClosed(res.Err)
+	}
+	// placements without an answer may have reached the book, only a client id can find them
+	for i := range results {
+		r := &results[i]
+		if r.Err == nil || r.Params.ClientID == nil || errors.Is(r.Err, ErrBatchAborted) || !isAmbiguousError(r.Err) {
+			continue
+		}
+		err := c.NewCancelOrderByClientIDService().ClientID(*r.Params.ClientID).Do(rollbackCtx)
+		r.RollbackErr = err
+		r.RolledBack = err == nil || isAlreadyClosed(err) || isOrderNotFound(err)
+	}
+	return results
+}
+
+// CancelOrders cancels the orders concurrently, results are in the order of ids.
+func (c *Client) CancelOrders(ctx context.Context, ids []int64, opts BatchOptions) []CancelOrderResult {
+	b := newBatchRunner(opts)
+	results := make([]CancelOrderResult, len(ids))
+	b.run(ctx, len(ids), func(ctx context.Context, i int) {
+		results[i].OrderID = ids[i]
+		results[i].Err = b.call(ctx, func() error {
+			return c.NewCancelOrderService().OrderID(ids[i]).Do(ctx)
+		})
+	})
+	return results
+}
+
+type batchRunner struct {
+	opts       BatchOptions
+	mu         sync.Mutex
+	pauseUntil time.Time
+}
+
+func newBatchRunner(opts BatchOptions) *batchRunner {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultBatchConcurrency
+	}
+	if opts.RateLimitRetries <= 0 {
+		opts.RateLimitRetries = DefaultBatchRateLimitRetries
+	}
+	if opts.RateLimitBackoff <= 0 {
+		opts.RateLimitBackoff = DefaultBatchRateLimitBackoff
+	}
+	return &batchRunner{opts: opts}
+}
+
+// run calls f for every index with at most Concurrency calls at a time.
+func (b *batchRunner) run(ctx context.Context, n int, f func(ctx context.Context, i int)) {
+	sem := make(chan struct{}, b.opts.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			f(ctx, i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// call runs the request, waiting out and retrying rate limit answers.
+func (b *batchRunner) call(ctx context.Context, request func() error) error {
+	backoff := b.opts.RateLimitBackoff
+	for attempt := 0; ; attempt++ {
+		b.mu.Lock()
+		wait := time.Until(b.pauseUntil)
+		b.mu.Unlock()
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		err := request()
+		if !errors.Is(err, ErrorRateLimit) || attempt >= b.opts.RateLimitRetries {
+			return err
+		}
+		b.mu.Lock()
+		if until := time.Now().Add(backoff); until.After(b.pauseUntil) {
+			b.pauseUntil = until
+		}
+		b.mu.Unlock()
+		backoff *= 2
+	}
+}
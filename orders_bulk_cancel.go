@@ -0,0 +1,143 @@
+package ftxapi
+
+import (
+	"context"
+	"errors"
+)
+
+type BulkCancelStatus string
+
+const (
+	BulkCancelStatusCancelled     BulkCancelStatus = "cancelled"
+	BulkCancelStatusAlreadyClosed BulkCancelStatus = "already_closed"
+	BulkCancelStatusAlreadyQueued BulkCancelStatus = "already_queued"
+	BulkCancelStatusFailed        BulkCancelStatus = "failed"
+)
+
+// BulkCancelParams selects the open orders to cancel. Market, Side, ConditionalOrdersOnly and
+// LimitOrdersOnly work like CancelAllOrderParams. ClientIDs and Filter narrow the selection
+// further, trigger orders have no client id so ClientIDs only selects regular orders.
+type BulkCancelParams struct {
+	Market                *string
+	Side                  *Side
+	ConditionalOrdersOnly bool
+	LimitOrdersOnly       bool
+	ClientIDs             []string
+	Filter                func(o Order) bool
+}
+
+type BulkCancelResult struct {
+	Order       Order
+	Conditional bool
+	Status      BulkCancelStatus
+	Err         error
+}
+
+type BulkCancelReport struct {
+	Results []BulkCancelResult
+}
+
+// Count returns how many orders ended with status.
+func (r BulkCancelReport) Count(status BulkCancelStatus) int {
+	var n int
+	for _, res := range r.Results {
+		if res.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the orders that could not be cancelled.
+func (r BulkCancelReport) Failed() []BulkCancelResult {
+	var res []BulkCancelResult
+	for _, result := range r.Results {
+		if result.Status == BulkCancelStatusFailed {
+			res = append(res, result)
+		}
+	}
+	return res
+}
+
+// BulkCancel lists the open orders matching params and cancels them one by one, unlike
+// CancelAllOrderService the report tells what happened to each order.
+func (c *Client) BulkCancel(ctx context.Context, params BulkCancelParams, opts BatchOptions) (BulkCancelReport, error) {
+	var targets []BulkCancelResult
+	if !params.ConditionalOrdersOnly {
+		s := c.NewGetOpenOrdersService()
+		if params.Market != nil {
+			s.Market(*params.Market)
+		}
+		orders, err := s.Do(ctx)
+		if err != nil {
+			return BulkCancelReport{}, err
+		}
+		for _, o := range orders {
+			if params.match(o, false) {
+				targets = append(targets, BulkCancelResult{Order: o})
+			}
+		}
+	}
+	if !params.LimitOrdersOnly && len(params.ClientIDs) == 0 {
+		s := c.NewGetOpenTriggerOrdersService()
+		if params.Market != nil {
+			s.Market(*params.Market)
+		}
+		orders, err := s.Do(ctx)
+		if err != nil {
+			return BulkCancelReport{}, err
+		}
+		for _, o := range orders {
+			if params.match(o, true) {
+				targets = append(targets, BulkCancelResult{Order: o, Conditional: true})
+			}
+		}
+	}
+
+	b := newBatchRunner(opts)
+	b.run(ctx, len(targets), func(ctx context.Context, i int) {
+		t := &targets[i]
+		t.Err = b.call(ctx, func() error {
+			if t.Conditional {
+				return c.NewCancelTriggerOrderService().OrderID(t.Order.ID).Do(ctx)
+			}
+			return c.NewCancelOrderService().OrderID(t.Order.ID).Do(ctx)
+		})
+		switch {
+		case t.Err == nil:
+			t.Status = BulkCancelStatusCancelled
+		case errors.Is(t.Err, OrderAlreadyClosed):
+			t.Status = BulkCancelStatusAlreadyClosed
+		case errors.Is(t.Err, OrderAlreadyQueued):
+			t.Status = BulkCancelStatusAlreadyQueued
+		default:
+			t.Status = BulkCancelStatusFailed
+		}
+	})
+	return BulkCancelReport{Results: targets}, nil
+}
+
+func (p BulkCancelParams) match(o Order, conditional bool) bool {
+	if p.Market != nil && o.Market != *p.Market {
+		return false
+	}
+	if p.Side != nil && o.Side != *p.Side {
+		return false
+	}
+	if len(p.ClientIDs) > 0 {
+		if conditional || o.ClientID == nil {
+			return false
+		}
+		found := false
+		for _, id := range p.ClientIDs {
+			if id == *o.ClientID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return p.Filter == nil || p.Filter(o)
+}
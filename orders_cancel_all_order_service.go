@@ -29,7 +29,15 @@ type CancelAllOrderResponse struct {
 }
 
 func (s *CancelAllOrderService) Do(ctx context.Context) error {
+	if s.c.paper != nil {
+		return s.c.paper.cancelAll(s.c, s.params)
+	}
 	r := newRequest(http.MethodDelete, endPointWithFormat("/orders"), true)
+	body, err := json.Marshal(s.params)
+	if err != nil {
+		return err
+	}
+	r.setBody(body)
 	byteData, err := s.c.callAPI(ctx, r)
 	if err != nil {
 		return err
@@ -0,0 +1,37 @@
+package ftxapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestCancelAllOrderSendsFilters(t *testing.T) {
+	c, srv := newTestClient(t)
+	var body map[string]interface{}
+	srv.handle("DELETE /orders", func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(buf, &body); err != nil {
+			testAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fmt.Fprint(w, `{"success": true, "result": "Orders queued for cancelation"}`)
+	})
+	side := SideSell
+	err := c.NewCancelAllOrderService().Params(CancelAllOrderParams{
+		Market:          StringPointer("BTC-PERP"),
+		Side:            &side,
+		LimitOrdersOnly: BoolPointer(true),
+	}).Do(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"market": "BTC-PERP", "side": "sell", "limitOrdersOnly": true}
+	if !reflect.DeepEqual(body, want) {
+		t.Fatalf("body = %v, want %v", body, want)
+	}
+}
@@ -22,6 +22,9 @@ type CancelOrderByClientIDResponse struct {
 }
 
 func (s *CancelOrderByClientIDService) Do(ctx context.Context) error {
+	if s.c.paper != nil {
+		return s.c.paper.cancelOrderByClientID(s.c, s.clientID)
+	}
 	r := newRequest(http.MethodDelete, endPointWithFormat("/orders/by_client_id/%s", s.clientID), true)
 	byteData, err := s.c.callAPI(ctx, r)
 	if err != nil {
@@ -22,6 +22,9 @@ type CancelOrderResponse struct {
 }
 
 func (s *CancelOrderService) Do(ctx context.Context) error {
+	if s.c.paper != nil {
+		return s.c.paper.cancelOrder(s.c, s.orderID)
+	}
 	r := newRequest(http.MethodDelete, endPointWithFormat("/orders/%d", s.orderID), true)
 	byteData, err := s.c.callAPI(ctx, r)
 	if err != nil {
@@ -22,6 +22,9 @@ type CancelTriggerOrderResponse struct {
 }
 
 func (s *CancelTriggerOrderService) Do(ctx context.Context) error {
+	if s.c.paper != nil {
+		return s.c.paper.cancelTriggerOrder(s.c, s.orderID)
+	}
 	r := newRequest(http.MethodDelete, endPointWithFormat("/conditional_orders/%d", s.orderID), true)
 	byteData, err := s.c.callAPI(ctx, r)
 	if err != nil {
@@ -23,6 +23,9 @@ type OrdersResponse struct {
 }
 
 func (s *GetOpenOrdersService) Do(ctx context.Context) ([]Order, error) {
+	if s.c.paper != nil {
+		return s.c.paper.listOpenOrders(s.c, s.market), nil
+	}
 	r := newRequest(http.MethodGet, "/orders", true)
 	if s.market != nil {
 		r.setParam("market", *s.market)
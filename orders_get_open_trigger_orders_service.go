@@ -30,6 +30,9 @@ type OpenTriggerOrdersResponse struct {
 }
 
 func (s *GetOpenTriggerOrdersService) Do(ctx context.Context) ([]Order, error) {
+	if s.c.paper != nil {
+		return s.c.paper.listOpenTriggerOrders(s.c, s.market, s.triggerType), nil
+	}
 	r := newRequest(http.MethodGet, "/conditional_orders", true)
 	if s.market != nil {
 		r.setParam("market", *s.market)
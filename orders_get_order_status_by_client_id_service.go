@@ -24,7 +24,10 @@ type GetOrderStatusByClientIDResponse struct {
 }
 
 func (s *GetOrderStatusByClientIDService) Do(ctx context.Context) (*Order, error) {
-	r := newRequest(http.MethodPost, endPointWithFormat("/orders/by_client_id/%s", s.clientID), true)
+	if s.c.paper != nil {
+		return s.c.paper.orderStatusByClientID(s.c, s.clientID)
+	}
+	r := newRequest(http.MethodGet, endPointWithFormat("/orders/by_client_id/%s", s.clientID), true)
 	byteData, err := s.c.callAPI(ctx, r)
 	if err != nil {
 		return nil, err
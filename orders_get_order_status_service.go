@@ -24,7 +24,10 @@ type GetOrderStatusResponse struct {
 }
 
 func (s *GetOrderStatusService) Do(ctx context.Context) (*Order, error) {
-	r := newRequest(http.MethodPost, endPointWithFormat("/orders/%d", s.orderID), true)
+	if s.c.paper != nil {
+		return s.c.paper.orderStatus(s.c, s.orderID)
+	}
+	r := newRequest(http.MethodGet, endPointWithFormat("/orders/%d", s.orderID), true)
 	byteData, err := s.c.callAPI(ctx, r)
 	if err != nil {
 		return nil, err
@@ -14,8 +14,13 @@ type GetTriggerOrderTriggersService struct {
 	conditionalOrderID int64
 }
 
+func (s *GetTriggerOrderTriggersService) ConditionalOrderID(conditionalOrderID int64) *GetTriggerOrderTriggersService {
+	s.conditionalOrderID = conditionalOrderID
+	return s
+}
+
 type OrderTrigger struct {
-	Error      error     `json:"error"`
+	Error      *string   `json:"error"`
 	FilledSize *float64  `json:"filledSize"`
 	OrderSize  *float64  `json:"orderSize"`
 	OrderID    *int64    `json:"orderId"`
@@ -28,6 +33,9 @@ type TriggerOrderTriggersResponse struct {
 }
 
 func (s *GetTriggerOrderTriggersService) Do(ctx context.Context) ([]OrderTrigger, error) {
+	if s.c.paper != nil {
+		return s.c.paper.triggerOrderTriggers(s.c, s.conditionalOrderID)
+	}
 	r := newRequest(http.MethodGet, endPointWithFormat("/conditional_orders/%d/triggers", s.conditionalOrderID), true)
 	byteData, err := s.c.callAPI(ctx, r)
 	if err != nil {
@@ -16,6 +16,8 @@ type ModifyOrderByClientIDService struct {
 type ModifyOrderByClientIDParams struct {
 	Price *float64 `json:"price,omitempty"`
 	Size  *float64 `json:"size,omitempty"`
+	//client id of the modified order
+	ClientID *string `json:"clientId,omitempty"`
 }
 
 func (s *ModifyOrderByClientIDService) ClientID(clientID string) *ModifyOrderByClientIDService {
@@ -34,6 +36,18 @@ type ModifyOrderByClientIDResponse struct {
 }
 
 func (s *ModifyOrderByClientIDService) Do(ctx context.Context) (*Order, error) {
+	if s.c.risk != nil {
+		o := RiskOrder{Price: s.params.Price, ModifyClientID: &s.clientID}
+		if s.params.Size != nil {
+			o.Size = *s.params.Size
+		}
+		if err := s.c.risk.CheckOrder(ctx, s.c, o); err != nil {
+			return nil, err
+		}
+	}
+	if s.c.paper != nil {
+		return s.c.paper.modifyOrderByClientID(s.c, s.clientID, s.params)
+	}
 	r := newRequest(http.MethodPost, endPointWithFormat("/orders/by_client_id/%s/modify", s.clientID), true)
 	body, err := json.Marshal(s.params)
 	if err != nil {
@@ -16,7 +16,7 @@ type ModifyOrderService struct {
 type ModifyOrderParams struct {
 	Price    *float64 `json:"price,omitempty"`
 	Size     *float64 `json:"size,omitempty"`
-	ClientID *string  `json:"clientID,omitempty"`
+	ClientID *string  `json:"clientId,omitempty"`
 }
 
 func (s *ModifyOrderService) OrderID(orderID int64) *ModifyOrderService {
@@ -35,6 +35,18 @@ type ModifyOrderResponse struct {
 }
 
 func (s *ModifyOrderService) Do(ctx context.Context) (*Order, error) {
+	if s.c.risk != nil {
+		o := RiskOrder{Price: s.params.Price, ModifyOrderID: &s.orderID}
+		if s.params.Size != nil {
+			o.Size = *s.params.Size
+		}
+		if err := s.c.risk.CheckOrder(ctx, s.c, o); err != nil {
+			return nil, err
+		}
+	}
+	if s.c.paper != nil {
+		return s.c.paper.modifyOrderByID(s.c, s.orderID, s.params)
+	}
 	r := newRequest(http.MethodPost, endPointWithFormat("/orders/%d/modify", s.orderID), true)
 	body, err := json.Marshal(s.params)
 	if err != nil {
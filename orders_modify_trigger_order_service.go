@@ -36,6 +36,9 @@ type ModifyTriggerOrderResponse struct {
 }
 
 func (s *ModifyTriggerOrderService) Do(ctx context.Context) (*TriggerOrder, error) {
+	if s.c.paper != nil {
+		return s.c.paper.modifyTriggerOrder(s.c, s.orderID, s.params)
+	}
 	r := newRequest(http.MethodPost, endPointWithFormat("/conditional_orders/%d/modify", s.orderID), true)
 	body, err := json.Marshal(s.params)
 	if err != nil {
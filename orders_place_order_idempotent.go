@@ -0,0 +1,125 @@
+package ftxapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultPlacementLookupWindow = 10 * time.Second
+	placementLookupInterval      = 500 * time.Millisecond
+	placementMaxLookupInterval   = 2 * time.Second
+)
+
+type PlacementStatus string
+
+const (
+	PlacementStatusPlaced    PlacementStatus = "placed"
+	PlacementStatusNotPlaced PlacementStatus = "not_placed"
+	PlacementStatusUnknown   PlacementStatus = "unknown"
+)
+
+type PlacementResult struct {
+	Status   PlacementStatus
+	ClientID string
+	Order    *Order
+}
+
+// OrderOutcomeUnknownError is returned when neither the placement nor the lookups
+// could tell whether the order reached the book.
+type OrderOutcomeUnknownError struct {
+	ClientID  string
+	PlaceErr  error
+	LookupErr error
+}
+
+func (e *OrderOutcomeUnknownError) Error() string {
+	return fmt.Sprintf("order %s outcome unknown: place: %s, lookup: %v", e.ClientID, e.PlaceErr, e.LookupErr)
+}
+
+func (e *OrderOutcomeUnknownError) Unwrap() error {
+	return e.PlaceErr
+}
+
+// NewClientID returns a random client order id.
+func NewClientID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// PlaceOrderIdempotent places an order under a client id, generated if params has none.
+// When the placement fails without an answer from the API, the order is looked up by
+// client id until lookupWindow passes and the result says whether it was placed.
+// Errors are the API rejection for not placed orders and *OrderOutcomeUnknownError for unknown ones.
+func (c *Client) PlaceOrderIdempotent(ctx context.Context, params PlaceOrderParams, lookupWindow time.Duration) (PlacementResult, error) {
+	if params.ClientID == nil {
+		params.ClientID = StringPointer(NewClientID())
+	}
+	if lookupWindow <= 0 {
+		lookupWindow = DefaultPlacementLookupWindow
+	}
+	res := PlacementResult{ClientID: *params.ClientID}
+	order, err := c.NewPlaceOrderService().Params(params).Do(ctx)
+	if err == nil {
+		res.Status = PlacementStatusPlaced
+		res.Order = order
+		return res, nil
+	}
+	if !isAmbiguousError(err) {
+		res.Status = PlacementStatusNotPlaced
+		return res, err
+	}
+
+	// the caller's context may be what expired, so the lookup gets its own deadline
+	lookupCtx, cancel := context.WithTimeout(context.Background(), lookupWindow)
+	defer cancel()
+	interval := placementLookupInterval
+	var lookupErr error
+	notFound := false
+	for {
+		order, lookupErr = c.NewGetOrderStatusByClientIDService().ClientID(res.ClientID).Do(lookupCtx)
+		if lookupErr == nil {
+			res.Status = PlacementStatusPlaced
+			res.Order = order
+			return res, nil
+		}
+		notFound = isOrderNotFound(lookupErr)
+		select {
+		case <-lookupCtx.Done():
+			if notFound {
+				res.Status = PlacementStatusNotPlaced
+				return res, err
+			}
+			res.Status = PlacementStatusUnknown
+			return res, &OrderOutcomeUnknownError{ClientID: res.ClientID, PlaceErr: err, LookupErr: lookupErr}
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > placementMaxLookupInterval {
+			interval = placementMaxLookupInterval
+		}
+	}
+}
+
+// isAmbiguousError reports whether the request may have been executed despite err. Server errors
+// and error bodies that could not be decoded may come from a gateway in front of a matching engine
+// that got the order.
+func isAmbiguousError(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return !apiErr.decoded || apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	return !errors.Is(err, ErrorRateLimit) && !errors.Is(err, ErrRiskRejected)
+}
+
+func isOrderNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.decoded && apiErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,145 @@
+package ftxapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testRestServer answers REST calls by method and cleaned path, unknown routes are 404 API errors.
+type testRestServer struct {
+	mu     sync.Mutex
+	routes map[string]func(w http.ResponseWriter, r *http.Request)
+	calls  map[string]int
+}
+
+func newTestClient(t *testing.T) (*Client, *testRestServer) {
+	s := &testRestServer{routes: make(map[string]func(http.ResponseWriter, *http.Request)), calls: make(map[string]int)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.Method + " " + path.Clean(r.URL.Path)
+		s.mu.Lock()
+		s.calls[route]++
+		h, ok := s.routes[route]
+		s.mu.Unlock()
+		if !ok {
+			testAPIError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	c := NewClient(Config{Logger: zap.NewNop().Sugar(), RestAPIEndpoint: srv.URL, ApiKey: "key", ApiSecret: "secret"})
+	return c, s
+}
+
+func (s *testRestServer) handle(route string, h func(w http.ResponseWriter, r *http.Request)) {
+	s.mu.Lock()
+	s.routes[route] = h
+	s.mu.Unlock()
+}
+
+func (s *testRestServer) count(route string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[route]
+}
+
+func testAPIError(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"success": false, "error": %q}`, msg)
+}
+
+func testOrderResult(w http.ResponseWriter, id int64, clientID string) {
+	fmt.Fprintf(w, `{"success": true, "result": {"id": %d, "clientId": %q, "market": "BTC-PERP", "side": "buy", "type": "limit", "price": 100, "size": 1, "status": "open", "createdAt": "2022-10-05T20:00:00+00:00"}}`, id, clientID)
+}
+
+var testPlaceParams = PlaceOrderParams{Market: "BTC-PERP", Side: SideBuy, Type: OrderTypeLimit, Price: 100, Size: 1, ClientID: StringPointer("cid-1")}
+
+func TestPlaceOrderIdempotentRejected(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.handle("POST /orders", func(w http.ResponseWriter, r *http.Request) {
+		testAPIError(w, http.StatusBadRequest, "Not enough balances")
+	})
+	res, err := c.PlaceOrderIdempotent(context.Background(), testPlaceParams, time.Second)
+	if res.Status != PlacementStatusNotPlaced || err == nil {
+		t.Fatalf("status = %s, err = %v, want not placed", res.Status, err)
+	}
+	if n := srv.count("GET /orders/by_client_id/cid-1"); n != 0 {
+		t.Fatalf("rejected order looked up %d times", n)
+	}
+}
+
+func TestPlaceOrderIdempotentGatewayErrorFoundByLookup(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.handle("POST /orders", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, "<html>502 Bad Gateway</html>")
+	})
+	srv.handle("GET /orders/by_client_id/cid-1", func(w http.ResponseWriter, r *http.Request) {
+		testOrderResult(w, 42, "cid-1")
+	})
+	res, err := c.PlaceOrderIdempotent(context.Background(), testPlaceParams, time.Second)
+	if err != nil || res.Status != PlacementStatusPlaced || res.Order == nil || res.Order.ID != 42 {
+		t.Fatalf("result = %+v, err = %v, want placed order 42", res, err)
+	}
+}
+
+func TestPlaceOrderIdempotentServerErrorNotFound(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.handle("POST /orders", func(w http.ResponseWriter, r *http.Request) {
+		testAPIError(w, http.StatusInternalServerError, "Internal error")
+	})
+	srv.handle("GET /orders/by_client_id/cid-1", func(w http.ResponseWriter, r *http.Request) {
+		testAPIError(w, http.StatusNotFound, "Order not found")
+	})
+	res, err := c.PlaceOrderIdempotent(context.Background(), testPlaceParams, 300*time.Millisecond)
+	if res.Status != PlacementStatusNotPlaced || err == nil {
+		t.Fatalf("status = %s, err = %v, want not placed", res.Status, err)
+	}
+	if srv.count("GET /orders/by_client_id/cid-1") == 0 {
+		t.Fatal("server error was not looked up")
+	}
+}
+
+func TestPlaceOrderIdempotentUnknown(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.handle("POST /orders", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	srv.handle("GET /orders/by_client_id/cid-1", func(w http.ResponseWriter, r *http.Request) {
+		// a 404 page of a proxy says nothing about the order
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "not found")
+	})
+	res, err := c.PlaceOrderIdempotent(context.Background(), testPlaceParams, 300*time.Millisecond)
+	var unknown *OrderOutcomeUnknownError
+	if res.Status != PlacementStatusUnknown || !errors.As(err, &unknown) {
+		t.Fatalf("status = %s, err = %v, want unknown", res.Status, err)
+	}
+}
+
+func TestIsAmbiguousError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&APIError{StatusCode: http.StatusBadRequest, Message: "Invalid price", decoded: true}, false},
+		{&APIError{StatusCode: http.StatusBadRequest}, true},
+		{&APIError{StatusCode: http.StatusInternalServerError, Message: "Internal error", decoded: true}, true},
+		{ErrorRateLimit, false},
+		{&RiskError{Check: RiskCheckOrderRate}, false},
+		{context.DeadlineExceeded, true},
+	} {
+		if got := isAmbiguousError(tc.err); got != tc.want {
+			t.Errorf("isAmbiguousError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
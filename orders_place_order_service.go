@@ -43,6 +43,18 @@ type PlaceOrderResponse struct {
 }
 
 func (s *PlaceOrderService) Do(ctx context.Context) (*Order, error) {
+	if s.c.risk != nil {
+		o := RiskOrder{Market: s.params.Market, Side: s.params.Side, Size: s.params.Size, ReduceOnly: s.params.ReduceOnly != nil && *s.params.ReduceOnly}
+		if s.params.Type == OrderTypeLimit {
+			o.Price = &s.params.Price
+		}
+		if err := s.c.risk.CheckOrder(ctx, s.c, o); err != nil {
+			return nil, err
+		}
+	}
+	if s.c.paper != nil {
+		return s.c.paper.placeOrder(s.c, s.params)
+	}
 	r := newRequest(http.MethodPost, endPointWithFormat("/orders"), true)
 	body, err := json.Marshal(s.params)
 	if err != nil {
@@ -35,6 +35,19 @@ type PlaceTriggerOrderResponse struct {
 }
 
 func (s *PlaceTriggerOrderService) Do(ctx context.Context) (*TriggerOrder, error) {
+	if s.c.risk != nil {
+		o := RiskOrder{Market: s.params.Market, Side: s.params.Side, Size: s.params.Size, Trigger: true, ReduceOnly: s.params.ReduceOnly != nil && *s.params.ReduceOnly}
+		o.Price = s.params.OrderPrice
+		if o.Price == nil {
+			o.Price = s.params.TriggerPrice
+		}
+		if err := s.c.risk.CheckOrder(ctx, s.c, o); err != nil {
+			return nil, err
+		}
+	}
+	if s.c.paper != nil {
+		return s.c.paper.placeTriggerOrder(s.c, s.params)
+	}
 	r := newRequest(http.MethodPost, endPointWithFormat("/conditional_orders"), true)
 	body, err := json.Marshal(s.params)
 	if err != nil {
@@ -0,0 +1,1144 @@
+package ftxapi
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const paperSizeEpsilon = 1e-9
+
+// PaperExchange is a simulated account for Config.PaperExchange. Orders, trigger orders,
+// withdrawals and subaccount transfers of the client go to it instead of the API, together with
+// the order status, open orders, fills, positions, balances and account queries, everything
+// else still hits the real API.
+// Orders fill against the order books fed with Handle from a real websocket subscribed to the
+// orderbook channel, and their orders and fills events go to the handlers set with OnEvent and
+// OnSubAccountEvent in the same form as the private channels. Orders, fills, balances and
+// positions are kept per subaccount, a client only sees those of its own. Futures settle their
+// realized PnL and fees in USD, spot fees are charged in the coin received, and the account is
+// valued at 1x leverage.
+type PaperExchange struct {
+	l         *zap.SugaredLogger
+	mu        sync.Mutex
+	makerFee  float64
+	takerFee  float64
+	books     map[string]*paperBook
+	orders    map[int64]*paperOrder
+	clientIDs map[paperClientID]int64
+	triggers  map[int64]*paperTrigger
+	fills     []Fill
+	positions map[string]map[string]*paperPosition
+	balances  map[string]map[string]float64
+	nextID    int64
+	events    *paperEvents
+}
+
+type paperBook struct {
+	bids map[float64]float64
+	asks map[float64]float64
+}
+
+// paperClientID keys client order ids, they are unique per subaccount.
+type paperClientID struct {
+	subAccount string
+	clientID   string
+}
+
+type paperOrder struct {
+	Order
+	subAccount string
+	triggerID  int64
+}
+
+type paperPosition struct {
+	size       float64
+	entryPrice float64
+	realized   float64
+}
+
+type paperTrigger struct {
+	TriggerOrder
+	subAccount string
+	triggers   []OrderTrigger
+}
+
+// NewPaperExchange returns a simulated account without fees or balances, see LoadFees and Deposit.
+func NewPaperExchange(l *zap.SugaredLogger) *PaperExchange {
+	p := &PaperExchange{
+		l:         l,
+		books:     make(map[string]*paperBook),
+		orders:    make(map[int64]*paperOrder),
+		clientIDs: make(map[paperClientID]int64),
+		triggers:  make(map[int64]*paperTrigger),
+		positions: make(map[string]map[string]*paperPosition),
+		balances:  make(map[string]map[string]float64),
+		nextID:    time.Now().Unix(),
+		events:    newPaperEvents(),
+	}
+	go p.events.run()
+	return p
+}
+
+// Fees sets the maker and taker fee rates.
+func (p *PaperExchange) Fees(maker, taker float64) *PaperExchange {
+	p.mu.Lock()
+	p.makerFee, p.takerFee = maker, taker
+	p.mu.Unlock()
+	return p
+}
+
+// LoadFees takes the fee rates of the real account from GetAccountService, c must not be a
+// paper trading client.
+func (p *PaperExchange) LoadFees(ctx context.Context, c *Client) error {
+	account, err := c.NewGetAccountService().Do(ctx)
+	if err != nil {
+		return err
+	}
+	p.Fees(account.MakerFee, account.TakerFee)
+	return nil
+}
+
+// Deposit credits a balance of a subaccount, an empty subaccount is the main account.
+func (p *PaperExchange) Deposit(subAccount, coin string, size float64) *PaperExchange {
+	p.mu.Lock()
+	p.balance(subAccount)[coin] += size
+	p.mu.Unlock()
+	return p
+}
+
+// OnEvent sets the handler receiving the orders and fills events of the main account.
+func (p *PaperExchange) OnEvent(h WsDataHandler) *PaperExchange {
+	p.events.setHandler(h)
+	return p
+}
+
+// OnSubAccountEvent sets the handler receiving the orders and fills events of every subaccount,
+// tagged with the subaccount like SubAccountStreams does.
+func (p *PaperExchange) OnSubAccountEvent(h WsSubAccountDataHandler) *PaperExchange {
+	p.events.setSubAccountHandler(h)
+	return p
+}
+
+// Balances returns the simulated balances of a subaccount.
+func (p *PaperExchange) Balances(subAccount string) map[string]float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make(map[string]float64)
+	for coin, size := range p.balances[subAccount] {
+		res[coin] = size
+	}
+	return res
+}
+
+// Position returns the simulated net size of a market in a subaccount.
+func (p *PaperExchange) Position(subAccount, market string) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pos, ok := p.positions[subAccount][market]; ok {
+		return pos.size
+	}
+	return 0
+}
+
+// Close stops delivering events.
+func (p *PaperExchange) Close() {
+	p.events.close()
+}
+
+// Handle consumes the orderbook events of the websocket data handler and fills the resting
+// orders and trigger orders the book reaches.
+func (p *PaperExchange) Handle(res WsReponse) {
+	if res.OrderBookEvent == nil {
+		return
+	}
+	market := res.OrderBookEvent.Market
+	data := res.OrderBookEvent.Data
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.books[market]
+	if !ok || data.Action == PartialWsDataAction || res.OrderBookEvent.Type == PartialWsDataAction {
+		b = &paperBook{bids: make(map[float64]float64), asks: make(map[float64]float64)}
+		p.books[market] = b
+	}
+	b.apply(b.bids, data.Bids)
+	b.apply(b.asks, data.Asks)
+	p.matchResting(market)
+	p.checkTriggers(market)
+}
+
+func (p *PaperExchange) placeOrder(c *Client, params PlaceOrderParams) (*Order, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sa := c.subAccountName()
+	if err := p.validate(sa, params); err != nil {
+		return nil, err
+	}
+	o := p.newOrder(sa, params)
+	// the answer is the order as accepted, what happens next comes as events
+	res := o.Order
+	p.execute(o, params.Ioc != nil && *params.Ioc, params.PostOnly != nil && *params.PostOnly)
+	return &res, nil
+}
+
+// validate checks an order before anything changes, must be called with p.mu held.
+func (p *PaperExchange) validate(subAccount string, params PlaceOrderParams) error {
+	if params.Size <= 0 {
+		return paperError("Size must be positive")
+	}
+	if params.Type == OrderTypeLimit && params.Price <= 0 {
+		return paperError("Price must be positive")
+	}
+	if _, ok := p.books[params.Market]; !ok {
+		return paperError("No order book for market " + params.Market)
+	}
+	if params.ClientID != nil {
+		if o, ok := p.order(subAccount, *params.ClientID); ok && !o.closed() {
+			return paperError("Duplicate client order ID")
+		}
+	}
+	return nil
+}
+
+// newOrder registers a validated order, must be called with p.mu held.
+func (p *PaperExchange) newOrder(subAccount string, params PlaceOrderParams) *paperOrder {
+	o := &paperOrder{
+		Order: Order{
+			CreatedAt:     time.Now().UTC(),
+			ID:            p.next(),
+			Market:        params.Market,
+			Price:         params.Price,
+			RemainingSize: params.Size,
+			Side:          params.Side,
+			Size:          params.Size,
+			Status:        OrderStatusNew,
+			Type:          params.Type,
+			ReduceOnly:    params.ReduceOnly != nil && *params.ReduceOnly,
+			Ioc:           params.Ioc != nil && *params.Ioc,
+			PostOnly:      params.PostOnly != nil && *params.PostOnly,
+			ClientID:      params.ClientID,
+		},
+		subAccount: subAccount,
+	}
+	if _, ok := spotBaseCurrency(o.Market); !ok {
+		o.Future = o.Market
+	}
+	if o.Type == OrderTypeMarket {
+		o.Price = 0
+	}
+	p.orders[o.ID] = o
+	if o.ClientID != nil {
+		p.clientIDs[paperClientID{subAccount, *o.ClientID}] = o.ID
+	}
+	return o
+}
+
+// execute takes the liquidity an order crosses and rests or closes the rest, must be called with p.mu held.
+func (p *PaperExchange) execute(o *paperOrder, ioc, postOnly bool) {
+	if o.ReduceOnly && p.reducible(o) <= paperSizeEpsilon {
+		p.close(o)
+		return
+	}
+	b := p.books[o.Market]
+	if postOnly && b.crosses(o) {
+		// a post only order that would take is cancelled
+		p.close(o)
+		return
+	}
+	o.Status = OrderStatusOpen
+	for _, level := range b.levels(o.Side) {
+		remaining := o.Size - o.FilledSize
+		if o.ReduceOnly {
+			remaining = math.Min(remaining, p.reducible(o))
+		}
+		if remaining <= paperSizeEpsilon || (o.Type == OrderTypeLimit && !crossesPrice(o.Side, o.Price, level.Price)) {
+			break
+		}
+		size := math.Min(remaining, level.Size)
+		p.fill(o, level.Price, size, "taker")
+		b.take(o.Side, level.Price, size)
+	}
+	if o.Type == OrderTypeMarket || ioc || o.Size-o.FilledSize <= paperSizeEpsilon {
+		p.close(o)
+		return
+	}
+	p.emitOrder(o)
+}
+
+// matchResting fills the resting orders the book moved through at their limit price.
+func (p *PaperExchange) matchResting(market string) {
+	b := p.books[market]
+	for _, o := range p.resting(market) {
+		for _, level := range b.levels(o.Side) {
+			remaining := o.Size - o.FilledSize
+			if o.ReduceOnly {
+				remaining = math.Min(remaining, p.reducible(o))
+			}
+			if remaining <= paperSizeEpsilon || !crossesPrice(o.Side, o.Price, level.Price) {
+				break
+			}
+			size := math.Min(remaining, level.Size)
+			p.fill(o, o.Price, size, "maker")
+			b.take(o.Side, level.Price, size)
+		}
+		if o.Size-o.FilledSize <= paperSizeEpsilon || (o.ReduceOnly && p.reducible(o) <= paperSizeEpsilon) {
+			p.close(o)
+		}
+	}
+}
+
+func (p *PaperExchange) checkTriggers(market string) {
+	b := p.books[market]
+	price, ok := b.mid()
+	if !ok {
+		return
+	}
+	var fired []*paperTrigger
+	for _, t := range p.triggers {
+		if t.Market != market || t.Status != OrderStatusOpen {
+			continue
+		}
+		up := price >= t.TriggerPrice
+		down := price <= t.TriggerPrice
+		stop := (t.Side == SideBuy && up) || (t.Side == SideSell && down)
+		takeProfit := (t.Side == SideBuy && down) || (t.Side == SideSell && up)
+		if (t.Type == TriggerTypeStop && stop) || (t.Type == TriggerTypeTakeProfit && takeProfit) {
+			fired = append(fired, t)
+		}
+	}
+	sort.Slice(fired, func(i, j int) bool { return fired[i].ID < fired[j].ID })
+	for _, t := range fired {
+		p.fire(t)
+	}
+}
+
+func (p *PaperExchange) fire(t *paperTrigger) {
+	now := time.Now().UTC()
+	t.Status = OrderStatusTriggered
+	triggeredAt := now.Format(time.RFC3339Nano)
+	t.TriggeredAt = &triggeredAt
+	params := PlaceOrderParams{
+		Market:     t.Market,
+		Side:       t.Side,
+		Type:       OrderTypeMarket,
+		Size:       t.Size,
+		ReduceOnly: BoolPointer(t.ReduceOnly),
+	}
+	if t.OrderPrice != nil {
+		params.Type = OrderTypeLimit
+		params.Price = *t.OrderPrice
+	}
+	if err := p.validate(t.subAccount, params); err != nil {
+		msg := err.Error()
+		t.triggers = append(t.triggers, OrderTrigger{Error: &msg, Time: now})
+		return
+	}
+	o := p.newOrder(t.subAccount, params)
+	o.triggerID = int64(t.ID)
+	t.triggers = append(t.triggers, OrderTrigger{
+		OrderID:    &o.ID,
+		OrderSize:  &o.Size,
+		FilledSize: new(float64),
+		Time:       now,
+	})
+	p.execute(o, false, false)
+}
+
+// fill books a fill of o, must be called with p.mu held.
+func (p *PaperExchange) fill(o *paperOrder, price, size float64, liquidity string) {
+	rate := p.takerFee
+	if liquidity == "maker" {
+		rate = p.makerFee
+	}
+	o.AvgFillPrice = (o.AvgFillPrice*o.FilledSize + price*size) / (o.FilledSize + size)
+	o.FilledSize += size
+	o.RemainingSize = o.Size - o.FilledSize
+
+	signed := size
+	if o.Side == SideSell {
+		signed = -size
+	}
+	pnl := p.position(o.subAccount, o.Market).apply(signed, price)
+	balance := p.balance(o.subAccount)
+	f := Fill{
+		FeeCurrency: "USD",
+		FeeRate:     rate,
+		Future:      o.Future,
+		ID:          int(p.next()),
+		Liquidity:   liquidity,
+		Market:      o.Market,
+		OrderID:     int(o.ID),
+		TradeID:     int(p.next()),
+		Price:       price,
+		Side:        string(o.Side),
+		Size:        size,
+		Time:        time.Now().UTC(),
+		Type:        "order",
+		subAccount:  o.subAccount,
+	}
+	if base, ok := spotBaseCurrency(o.Market); ok {
+		// the fee is taken from the coin received
+		quote := o.Market[len(base)+1:]
+		f.BaseCurrency, f.QuoteCurrency = base, quote
+		balance[base] += signed
+		balance[quote] -= signed * price
+		if o.Side == SideBuy {
+			f.Fee, f.FeeCurrency = size*rate, base
+		} else {
+			f.Fee, f.FeeCurrency = price*size*rate, quote
+		}
+		balance[f.FeeCurrency] -= f.Fee
+	} else {
+		f.Fee = price * size * rate
+		balance["USD"] += pnl - f.Fee
+	}
+	p.fills = append(p.fills, f)
+	if t, ok := p.triggers[o.triggerID]; ok {
+		t.FilledSize += size
+		avg := o.AvgFillPrice
+		t.AvgFillPrice = &avg
+		for _, tr := range t.triggers {
+			if tr.OrderID != nil && *tr.OrderID == o.ID {
+				*tr.FilledSize = o.FilledSize
+			}
+		}
+	}
+	p.events.push(o.subAccount, WsReponse{Fills: &WsFillsEvent{
+		baseWsEvent: baseWsEvent{Type: UpdateWsDataAction, Channel: WsChannelFills},
+		Data:        f.wsFills(),
+	}})
+}
+
+func (p *PaperExchange) close(o *paperOrder) {
+	o.Status = OrderStatusClosed
+	o.RemainingSize = 0
+	p.emitOrder(o)
+}
+
+func (p *PaperExchange) emitOrder(o *paperOrder) {
+	p.events.push(o.subAccount, WsReponse{Orders: &WsOrdersEvent{
+		baseWsEvent: baseWsEvent{Type: UpdateWsDataAction, Channel: WsChannelOrders},
+		Data: WsOrders{
+			AvgFillPrice:  o.AvgFillPrice,
+			ClientID:      o.ClientID,
+			CreatedAt:     o.CreatedAt,
+			FilledSize:    o.FilledSize,
+			ID:            o.ID,
+			Ioc:           o.Ioc,
+			Market:        o.Market,
+			PostOnly:      o.PostOnly,
+			Price:         o.Price,
+			ReduceOnly:    o.ReduceOnly,
+			RemainingSize: o.RemainingSize,
+			Side:          o.Side,
+			Size:          o.Size,
+			Status:        o.Status,
+			Type:          o.Type,
+		},
+	}})
+}
+
+// modifyOrder replaces o in its own subaccount, the replacement is validated before o is closed.
+func (p *PaperExchange) modifyOrder(o *paperOrder, price, size *float64, clientID *string) (*Order, error) {
+	if o.closed() {
+		return nil, OrderAlreadyClosed
+	}
+	params := PlaceOrderParams{
+		Market:     o.Market,
+		Side:       o.Side,
+		Price:      o.Price,
+		Type:       o.Type,
+		Size:       o.Size - o.FilledSize,
+		ReduceOnly: BoolPointer(o.ReduceOnly),
+		Ioc:        BoolPointer(o.Ioc),
+		PostOnly:   BoolPointer(o.PostOnly),
+		ClientID:   clientID,
+	}
+	if price != nil {
+		params.Price = *price
+	}
+	if size != nil {
+		params.Size = *size
+	}
+	check := params
+	if clientID == nil || (o.ClientID != nil && *o.ClientID == *clientID) {
+		// the client id of o is free once o is closed
+		check.ClientID = nil
+		params.ClientID = o.ClientID
+	}
+	if err := p.validate(o.subAccount, check); err != nil {
+		return nil, err
+	}
+	p.close(o)
+	n := p.newOrder(o.subAccount, params)
+	res := n.Order
+	p.execute(n, o.Ioc, o.PostOnly)
+	return &res, nil
+}
+
+func (p *PaperExchange) modifyOrderByID(c *Client, id int64, params ModifyOrderParams) (*Order, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o, ok := p.orderByID(c.subAccountName(), id)
+	if !ok {
+		return nil, paperNotFound()
+	}
+	return p.modifyOrder(o, params.Price, params.Size, params.ClientID)
+}
+
+func (p *PaperExchange) modifyOrderByClientID(c *Client, clientID string, params ModifyOrderByClientIDParams) (*Order, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o, ok := p.order(c.subAccountName(), clientID)
+	if !ok {
+		return nil, paperNotFound()
+	}
+	return p.modifyOrder(o, params.Price, params.Size, params.ClientID)
+}
+
+func (p *PaperExchange) cancelOrder(c *Client, id int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o, ok := p.orderByID(c.subAccountName(), id)
+	if !ok {
+		return paperNotFound()
+	}
+	return p.cancel(o)
+}
+
+func (p *PaperExchange) cancelOrderByClientID(c *Client, clientID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o, ok := p.order(c.subAccountName(), clientID)
+	if !ok {
+		return paperNotFound()
+	}
+	return p.cancel(o)
+}
+
+func (p *PaperExchange) cancel(o *paperOrder) error {
+	if o.closed() {
+		return OrderAlreadyClosed
+	}
+	p.close(o)
+	return nil
+}
+
+func (p *PaperExchange) cancelAll(c *Client, params CancelAllOrderParams) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sa := c.subAccountName()
+	match := func(market string, side Side) bool {
+		return (params.Market == nil || *params.Market == market) && (params.Side == nil || *params.Side == side)
+	}
+	if params.ConditionalOrdersOnly == nil || !*params.ConditionalOrdersOnly {
+		for _, o := range p.openOrders(sa, "") {
+			if match(o.Market, o.Side) {
+				p.close(o)
+			}
+		}
+	}
+	if params.LimitOrdersOnly == nil || !*params.LimitOrdersOnly {
+		for _, t := range p.triggers {
+			if t.subAccount == sa && t.Status == OrderStatusOpen && match(t.Market, t.Side) {
+				t.Status = OrderStatusCancelled
+			}
+		}
+	}
+	return nil
+}
+
+func (p *PaperExchange) orderStatus(c *Client, id int64) (*Order, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o, ok := p.orderByID(c.subAccountName(), id)
+	if !ok {
+		return nil, paperNotFound()
+	}
+	res := o.Order
+	return &res, nil
+}
+
+func (p *PaperExchange) orderStatusByClientID(c *Client, clientID string) (*Order, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o, ok := p.order(c.subAccountName(), clientID)
+	if !ok {
+		return nil, paperNotFound()
+	}
+	res := o.Order
+	return &res, nil
+}
+
+// orderByID returns an order of the subaccount, must be called with p.mu held.
+func (p *PaperExchange) orderByID(subAccount string, id int64) (*paperOrder, bool) {
+	o, ok := p.orders[id]
+	if !ok || o.subAccount != subAccount {
+		return nil, false
+	}
+	return o, true
+}
+
+// order returns the last order of the subaccount placed with clientID, must be called with p.mu held.
+func (p *PaperExchange) order(subAccount, clientID string) (*paperOrder, bool) {
+	id, ok := p.clientIDs[paperClientID{subAccount, clientID}]
+	if !ok {
+		return nil, false
+	}
+	return p.orders[id], true
+}
+
+func (p *PaperExchange) listOpenOrders(c *Client, market *string) []Order {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var m string
+	if market != nil {
+		m = *market
+	}
+	res := []Order{}
+	for _, o := range p.openOrders(c.subAccountName(), m) {
+		res = append(res, o.Order)
+	}
+	return res
+}
+
+// openOrders returns the resting orders of a subaccount by id, an empty market matches all.
+func (p *PaperExchange) openOrders(subAccount, market string) []*paperOrder {
+	var res []*paperOrder
+	for _, o := range p.resting(market) {
+		if o.subAccount == subAccount {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+// resting returns the resting orders of every subaccount by id, an empty market matches all.
+func (p *PaperExchange) resting(market string) []*paperOrder {
+	var res []*paperOrder
+	for _, o := range p.orders {
+		if !o.closed() && (market == "" || o.Market == market) {
+			res = append(res, o)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func (p *PaperExchange) listFills(c *Client, s *FillsService) []Fill {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sa := c.subAccountName()
+	limit := fillsPageSize
+	if s.limit != nil {
+		limit = int(*s.limit)
+	}
+	res := []Fill{}
+	for i := len(p.fills) - 1; i >= 0 && len(res) < limit; i-- {
+		f := p.fills[i]
+		switch {
+		case f.subAccount != sa,
+			s.market != nil && f.Market != *s.market,
+			s.orderID != nil && int64(f.OrderID) != *s.orderID,
+			s.startTime != nil && f.Time.Unix() < *s.startTime,
+			s.endTime != nil && f.Time.Unix() > *s.endTime:
+			continue
+		}
+		res = append(res, f)
+	}
+	if s.order != nil && *s.order == OrderByASC {
+		for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+			res[i], res[j] = res[j], res[i]
+		}
+	}
+	return res
+}
+
+func (p *PaperExchange) placeTriggerOrder(c *Client, params PlaceTriggerOrderParams) (*TriggerOrder, error) {
+	if params.Type == TriggerTypeTrailingStop {
+		return nil, paperError("Trailing stops are not supported in paper trading")
+	}
+	if params.TriggerPrice == nil || params.Size <= 0 {
+		return nil, paperError("Trigger price and size are required")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := &paperTrigger{
+		TriggerOrder: TriggerOrder{
+			CreatedAt:    time.Now().UTC(),
+			ID:           int(p.next()),
+			Market:       params.Market,
+			OrderPrice:   params.OrderPrice,
+			ReduceOnly:   params.ReduceOnly != nil && *params.ReduceOnly,
+			Side:         params.Side,
+			Size:         params.Size,
+			Status:       OrderStatusOpen,
+			TriggerPrice: *params.TriggerPrice,
+			Type:         params.Type,
+			OrderType:    OrderTypeMarket,
+		},
+		subAccount: c.subAccountName(),
+	}
+	if params.OrderPrice != nil {
+		t.OrderType = OrderTypeLimit
+	}
+	if _, ok := spotBaseCurrency(t.Market); !ok {
+		t.Future = t.Market
+	}
+	p.triggers[int64(t.ID)] = t
+	res := t.TriggerOrder
+	if _, ok := p.books[t.Market]; ok {
+		p.checkTriggers(t.Market)
+	}
+	return &res, nil
+}
+
+func (p *PaperExchange) modifyTriggerOrder(c *Client, id int64, params ModifyTriggerOrderParams) (*TriggerOrder, error) {
+	p.mu.Lock()
+	t, ok := p.triggers[id]
+	if !ok || t.subAccount != c.subAccountName() {
+		p.mu.Unlock()
+		return nil, paperNotFound()
+	}
+	if t.Status != OrderStatusOpen {
+		p.mu.Unlock()
+		return nil, OrderAlreadyClosed
+	}
+	t.Status = OrderStatusCancelled
+	next := PlaceTriggerOrderParams{
+		Market:       t.Market,
+		Side:         t.Side,
+		Size:         params.Size,
+		Type:         t.Type,
+		TriggerPrice: &t.TriggerPrice,
+		OrderPrice:   t.OrderPrice,
+		ReduceOnly:   BoolPointer(t.ReduceOnly),
+	}
+	p.mu.Unlock()
+	if next.Size <= 0 {
+		next.Size = t.Size
+	}
+	if params.TriggerPrice != nil {
+		next.TriggerPrice = params.TriggerPrice
+	}
+	if params.OrderPrice != nil {
+		next.OrderPrice = params.OrderPrice
+	}
+	return p.placeTriggerOrder(c, next)
+}
+
+func (p *PaperExchange) cancelTriggerOrder(c *Client, id int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.triggers[id]
+	if !ok || t.subAccount != c.subAccountName() {
+		return paperNotFound()
+	}
+	if t.Status != OrderStatusOpen {
+		return OrderAlreadyClosed
+	}
+	t.Status = OrderStatusCancelled
+	return nil
+}
+
+func (p *PaperExchange) listOpenTriggerOrders(c *Client, market *string, triggerType *TriggerType) []Order {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sa := c.subAccountName()
+	res := []Order{}
+	for _, t := range p.triggers {
+		if t.subAccount != sa || t.Status != OrderStatusOpen || (market != nil && t.Market != *market) || (triggerType != nil && t.Type != *triggerType) {
+			continue
+		}
+		// the open list keeps the trigger type in type like the API
+		o := Order{
+			CreatedAt:  t.CreatedAt,
+			FilledSize: t.FilledSize,
+			Future:     t.Future,
+			ID:         int64(t.ID),
+			Market:     t.Market,
+			Side:       t.Side,
+			Size:       t.Size,
+			Status:     t.Status,
+			Type:       OrderType(t.Type),
+			ReduceOnly: t.ReduceOnly,
+		}
+		if t.OrderPrice != nil {
+			o.Price = *t.OrderPrice
+		}
+		res = append(res, o)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func (p *PaperExchange) triggerOrderTriggers(c *Client, id int64) ([]OrderTrigger, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.triggers[id]
+	if !ok || t.subAccount != c.subAccountName() {
+		return nil, paperNotFound()
+	}
+	res := make([]OrderTrigger, 0, len(t.triggers))
+	for _, tr := range t.triggers {
+		if tr.FilledSize != nil {
+			filled := *tr.FilledSize
+			tr.FilledSize = &filled
+		}
+		res = append(res, tr)
+	}
+	return res, nil
+}
+
+func (p *PaperExchange) withdraw(c *Client, params WithdrawParams) (*Withdraw, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	balance := p.balance(c.subAccountName())
+	if params.Size <= 0 || balance[params.Coin] < params.Size {
+		return nil, paperError("Not enough balances")
+	}
+	balance[params.Coin] -= params.Size
+	return &Withdraw{
+		Coin:    params.Coin,
+		Address: params.Address,
+		Tag:     params.Tag,
+		ID:      p.next(),
+		Size:    params.Size,
+		Status:  "requested",
+		Time:    time.Now().UTC(),
+	}, nil
+}
+
+func (p *PaperExchange) transfer(params TransferBetweenSubAccountsParams) (*TransferBetweenSubAccounts, error) {
+	name := func(sa *string) string {
+		if sa == nil || *sa == "main" {
+			return ""
+		}
+		return *sa
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	source := p.balance(name(params.Source))
+	if params.Size <= 0 || source[params.Coin] < params.Size {
+		return nil, paperError("Not enough balances")
+	}
+	source[params.Coin] -= params.Size
+	p.balance(name(params.Destination))[params.Coin] += params.Size
+	return &TransferBetweenSubAccounts{
+		ID:     p.next(),
+		Coin:   params.Coin,
+		Size:   params.Size,
+		Time:   time.Now().UTC(),
+		Status: "complete",
+	}, nil
+}
+
+// reducible is how much of o can trade without growing the position, must be called with p.mu held.
+func (p *PaperExchange) reducible(o *paperOrder) float64 {
+	position := p.position(o.subAccount, o.Market).size
+	if o.Side == SideBuy {
+		return math.Max(-position, 0)
+	}
+	return math.Max(position, 0)
+}
+
+// position must be called with p.mu held.
+func (p *PaperExchange) position(subAccount, market string) *paperPosition {
+	positions, ok := p.positions[subAccount]
+	if !ok {
+		positions = make(map[string]*paperPosition)
+		p.positions[subAccount] = positions
+	}
+	pos, ok := positions[market]
+	if !ok {
+		pos = &paperPosition{}
+		positions[market] = pos
+	}
+	return pos
+}
+
+func (p *PaperExchange) balance(subAccount string) map[string]float64 {
+	b, ok := p.balances[subAccount]
+	if !ok {
+		b = make(map[string]float64)
+		p.balances[subAccount] = b
+	}
+	return b
+}
+
+func (p *PaperExchange) listPositions(c *Client) []Position {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.futuresPositions(c.subAccountName())
+}
+
+// futuresPositions must be called with p.mu held.
+func (p *PaperExchange) futuresPositions(subAccount string) []Position {
+	res := []Position{}
+	for market, pos := range p.positions[subAccount] {
+		if _, ok := spotBaseCurrency(market); ok {
+			continue
+		}
+		mark := p.markPrice(market, pos.entryPrice)
+		position := Position{
+			Future:      market,
+			NetSize:     pos.size,
+			Size:        math.Abs(pos.size),
+			Side:        string(SideBuy),
+			EntryPrice:  pos.entryPrice,
+			Cost:        pos.size * pos.entryPrice,
+			RealizedPnl: pos.realized,
+		}
+		if pos.size < 0 {
+			position.Side = string(SideSell)
+		}
+		if pos.size != 0 {
+			position.RecentAverageOpenPrice = pos.entryPrice
+			position.RecentBreakEvenPrice = pos.entryPrice
+			position.UnrealizedPnl = pos.size * (mark - pos.entryPrice)
+			position.CollateralUsed = math.Abs(pos.size) * mark
+			position.InitialMarginRequirement = 1
+			position.MaintenanceMarginRequirement = 1
+		}
+		res = append(res, position)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Future < res[j].Future })
+	return res
+}
+
+func (p *PaperExchange) listBalances(c *Client) []Balance {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.coinBalances(c.subAccountName())
+}
+
+// coinBalances must be called with p.mu held.
+func (p *PaperExchange) coinBalances(subAccount string) []Balance {
+	res := []Balance{}
+	for coin, size := range p.balances[subAccount] {
+		b := Balance{Coin: coin, Free: size, Total: size, AvailableWithoutBorrow: math.Max(size, 0)}
+		if coin == "USD" {
+			b.UsdValue = size
+		} else {
+			b.UsdValue = size * p.markPrice(coin+"/USD", 0)
+		}
+		res = append(res, b)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Coin < res[j].Coin })
+	return res
+}
+
+func (p *PaperExchange) account(c *Client) *Account {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sa := c.subAccountName()
+	a := &Account{
+		MakerFee:  p.makerFee,
+		TakerFee:  p.takerFee,
+		Leverage:  1,
+		Username:  sa,
+		Positions: p.futuresPositions(sa),
+	}
+	for _, b := range p.coinBalances(sa) {
+		a.Collateral += b.UsdValue
+	}
+	a.TotalAccountValue = a.Collateral
+	for _, pos := range a.Positions {
+		a.TotalAccountValue += pos.UnrealizedPnl
+		a.TotalPositionSize += pos.CollateralUsed
+	}
+	a.FreeCollateral = math.Max(a.TotalAccountValue-a.TotalPositionSize, 0)
+	if a.TotalPositionSize > 0 {
+		a.MarginFraction = a.TotalAccountValue / a.TotalPositionSize
+		a.OpenMarginFraction = a.MarginFraction
+		a.InitialMarginRequirement = 1
+		a.MaintenanceMarginRequirement = 1
+	}
+	return a
+}
+
+// markPrice is the book mid of a market or def without a book, must be called with p.mu held.
+func (p *PaperExchange) markPrice(market string, def float64) float64 {
+	if b, ok := p.books[market]; ok {
+		if mid, ok := b.mid(); ok {
+			return mid
+		}
+	}
+	return def
+}
+
+func (p *PaperExchange) next() int64 {
+	p.nextID++
+	return p.nextID
+}
+
+// apply books a signed trade into the position and returns the PnL it realizes.
+func (pos *paperPosition) apply(signed, price float64) float64 {
+	var pnl float64
+	if pos.size != 0 && (pos.size > 0) != (signed > 0) {
+		closed := math.Min(math.Abs(signed), math.Abs(pos.size))
+		pnl = closed * (price - pos.entryPrice)
+		if pos.size < 0 {
+			pnl = -pnl
+		}
+	}
+	next := pos.size + signed
+	switch {
+	case math.Abs(next) <= paperSizeEpsilon:
+		next = 0
+		pos.entryPrice = 0
+	case pos.size == 0 || (pos.size > 0) != (next > 0):
+		// opened or flipped, the rest is entered at price
+		pos.entryPrice = price
+	case (pos.size > 0) == (signed > 0):
+		pos.entryPrice = (pos.entryPrice*math.Abs(pos.size) + price*math.Abs(signed)) / math.Abs(next)
+	}
+	pos.size = next
+	pos.realized += pnl
+	return pnl
+}
+
+func (o *paperOrder) closed() bool {
+	return o.Status == OrderStatusClosed
+}
+
+func (b *paperBook) apply(side map[float64]float64, levels []Feed) {
+	for _, l := range levels {
+		if l.Size == 0 {
+			delete(side, l.Price)
+		} else {
+			side[l.Price] = l.Size
+		}
+	}
+}
+
+// levels returns the levels an order of side takes from, best first.
+func (b *paperBook) levels(side Side) []Feed {
+	book := b.asks
+	if side == SideSell {
+		book = b.bids
+	}
+	res := make([]Feed, 0, len(book))
+	for price, size := range book {
+		res = append(res, Feed{Price: price, Size: size})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if side == SideBuy {
+			return res[i].Price < res[j].Price
+		}
+		return res[i].Price > res[j].Price
+	})
+	return res
+}
+
+// take removes liquidity until the next book update replaces the level.
+func (b *paperBook) take(side Side, price, size float64) {
+	book := b.asks
+	if side == SideSell {
+		book = b.bids
+	}
+	if book[price] -= size; book[price] <= paperSizeEpsilon {
+		delete(book, price)
+	}
+}
+
+func (b *paperBook) crosses(o *paperOrder) bool {
+	levels := b.levels(o.Side)
+	return len(levels) > 0 && (o.Type == OrderTypeMarket || crossesPrice(o.Side, o.Price, levels[0].Price))
+}
+
+func (b *paperBook) mid() (float64, bool) {
+	bids, asks := b.levels(SideSell), b.levels(SideBuy)
+	if len(bids) == 0 || len(asks) == 0 {
+		return 0, false
+	}
+	return (bids[0].Price + asks[0].Price) / 2, true
+}
+
+func crossesPrice(side Side, limit, level float64) bool {
+	if side == SideBuy {
+		return level <= limit
+	}
+	return level >= limit
+}
+
+func paperError(msg string) error {
+	return &APIError{StatusCode: http.StatusBadRequest, Message: msg, decoded: true}
+}
+
+func paperNotFound() error {
+	return &APIError{StatusCode: http.StatusNotFound, Message: "Order not found", decoded: true}
+}
+
+// paperEvents delivers events in order from one goroutine, so handlers may call back into the exchange.
+type paperEvents struct {
+	mu         sync.Mutex
+	queue      []WsSubAccountReponse
+	handler    WsDataHandler
+	subHandler WsSubAccountDataHandler
+	signal     chan struct{}
+	stopC      chan struct{}
+	once       sync.Once
+}
+
+func newPaperEvents() *paperEvents {
+	return &paperEvents{signal: make(chan struct{}, 1), stopC: make(chan struct{})}
+}
+
+func (e *paperEvents) setHandler(h WsDataHandler) {
+	e.mu.Lock()
+	e.handler = h
+	e.mu.Unlock()
+}
+
+func (e *paperEvents) setSubAccountHandler(h WsSubAccountDataHandler) {
+	e.mu.Lock()
+	e.subHandler = h
+	e.mu.Unlock()
+}
+
+func (e *paperEvents) push(subAccount string, res WsReponse) {
+	e.mu.Lock()
+	e.queue = append(e.queue, WsSubAccountReponse{SubAccount: subAccount, WsReponse: res})
+	e.mu.Unlock()
+	select {
+	case e.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (e *paperEvents) run() {
+	for {
+		select {
+		case <-e.stopC:
+			return
+		case <-e.signal:
+		}
+		for {
+			e.mu.Lock()
+			if len(e.queue) == 0 {
+				e.mu.Unlock()
+				break
+			}
+			res := e.queue[0]
+			e.queue = e.queue[1:]
+			h, sh := e.handler, e.subHandler
+			e.mu.Unlock()
+			if h != nil && res.SubAccount == "" {
+				h(res.WsReponse)
+			}
+			if sh != nil {
+				sh(res)
+			}
+		}
+	}
+}
+
+func (e *paperEvents) close() {
+	e.once.Do(func() { close(e.stopC) })
+}
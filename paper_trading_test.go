@@ -0,0 +1,243 @@
+package ftxapi
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func newTestPaperClient(t *testing.T) (*Client, *PaperExchange) {
+	p := NewPaperExchange(zap.NewNop().Sugar())
+	t.Cleanup(p.Close)
+	c := NewClient(Config{Logger: zap.NewNop().Sugar(), PaperExchange: p})
+	return c, p
+}
+
+// testPaperBook replaces the book of market with one level on each side.
+func testPaperBook(p *PaperExchange, market string, bid, ask, size float64) {
+	p.Handle(WsReponse{OrderBookEvent: &WsOrderBookEvent{
+		baseWsEvent: baseWsEvent{Type: PartialWsDataAction, Channel: WsChannelOrderBook, Market: market},
+		Data: WsOrderBook{
+			Action: PartialWsDataAction,
+			Bids:   []Feed{{Price: bid, Size: size}},
+			Asks:   []Feed{{Price: ask, Size: size}},
+		},
+	}})
+}
+
+func assertClose(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Fatalf("%s = %v, want %v", name, got, want)
+	}
+}
+
+func TestPaperFuturesRealizedPnl(t *testing.T) {
+	c, p := newTestPaperClient(t)
+	p.Fees(0.0002, 0.0007).Deposit("", "USD", 1000)
+	ctx := context.Background()
+
+	testPaperBook(p, "BTC-PERP", 99, 100, 10)
+	if _, err := c.NewPlaceOrderService().Params(PlaceOrderParams{Market: "BTC-PERP", Side: SideBuy, Type: OrderTypeMarket, Size: 2}).Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	testPaperBook(p, "BTC-PERP", 110, 111, 10)
+	if _, err := c.NewPlaceOrderService().Params(PlaceOrderParams{Market: "BTC-PERP", Side: SideSell, Type: OrderTypeMarket, Size: 1}).Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	usd := 1000 - 200*0.0007 + 10 - 110*0.0007
+	assertClose(t, "USD", p.Balances("")["USD"], usd)
+	positions, err := c.NewGetPositionsService().Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) != 1 {
+		t.Fatalf("positions = %+v", positions)
+	}
+	pos := positions[0]
+	assertClose(t, "net size", pos.NetSize, 1)
+	assertClose(t, "entry price", pos.EntryPrice, 100)
+	assertClose(t, "realized", pos.RealizedPnl, 10)
+	assertClose(t, "unrealized", pos.UnrealizedPnl, 10.5)
+
+	balances, err := c.NewGetBalancesService().Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 || balances[0].Coin != "USD" {
+		t.Fatalf("balances = %+v", balances)
+	}
+	assertClose(t, "balance", balances[0].Total, usd)
+	account, err := c.NewGetAccountService().Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertClose(t, "account value", account.TotalAccountValue, usd+10.5)
+	assertClose(t, "free collateral", account.FreeCollateral, usd+10.5-110.5)
+}
+
+func TestPaperRestingSpotOrderFillsAsMaker(t *testing.T) {
+	c, p := newTestPaperClient(t)
+	p.Fees(0.001, 0.002).Deposit("", "USD", 1000)
+	ctx := context.Background()
+
+	testPaperBook(p, "ETH/USD", 99, 101, 5)
+	order, err := c.NewPlaceOrderService().Params(PlaceOrderParams{Market: "ETH/USD", Side: SideBuy, Type: OrderTypeLimit, Price: 100, Size: 2}).Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := c.NewGetOrderStatusService().OrderID(order.ID).Do(ctx); status.Status != OrderStatusOpen || status.FilledSize != 0 {
+		t.Fatalf("order = %+v, want open and unfilled", status)
+	}
+	testPaperBook(p, "ETH/USD", 98, 99.5, 5)
+	status, err := c.NewGetOrderStatusService().OrderID(order.ID).Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != OrderStatusClosed || status.FilledSize != 2 || status.AvgFillPrice != 100 {
+		t.Fatalf("order = %+v, want filled at the limit price", status)
+	}
+	fills, err := c.NewFillsService().Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a buy pays its fee in the coin it receives
+	if len(fills) != 1 || fills[0].Liquidity != "maker" || fills[0].FeeCurrency != "ETH" {
+		t.Fatalf("fills = %+v", fills)
+	}
+	assertClose(t, "fee", fills[0].Fee, 2*0.001)
+	balances := p.Balances("")
+	assertClose(t, "ETH", balances["ETH"], 2-2*0.001)
+	assertClose(t, "USD", balances["USD"], 1000-200)
+}
+
+func TestPaperPositionsPerSubAccount(t *testing.T) {
+	c, p := newTestPaperClient(t)
+	sub := c.withSubAccount("sub")
+	ctx := context.Background()
+
+	testPaperBook(p, "BTC-PERP", 99, 100, 10)
+	if _, err := c.NewPlaceOrderService().Params(PlaceOrderParams{Market: "BTC-PERP", Side: SideBuy, Type: OrderTypeMarket, Size: 1}).Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the long of the main account can't be reduced from the subaccount
+	order, err := sub.NewPlaceOrderService().Params(PlaceOrderParams{Market: "BTC-PERP", Side: SideSell, Type: OrderTypeMarket, Size: 1, ReduceOnly: BoolPointer(true)}).Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := sub.NewGetOrderStatusService().OrderID(order.ID).Do(ctx); status.FilledSize != 0 {
+		t.Fatalf("reduce only order of the subaccount filled %v", status.FilledSize)
+	}
+	assertClose(t, "main position", p.Position("", "BTC-PERP"), 1)
+	assertClose(t, "sub position", p.Position("sub", "BTC-PERP"), 0)
+	positions, err := sub.NewGetPositionsService().Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pos := range positions {
+		if pos.NetSize != 0 {
+			t.Fatalf("subaccount positions = %+v", positions)
+		}
+	}
+}
+
+func TestPaperOrdersPerSubAccount(t *testing.T) {
+	c, p := newTestPaperClient(t)
+	sub := c.withSubAccount("sub")
+	ctx := context.Background()
+	var mu sync.Mutex
+	var events []WsSubAccountReponse
+	p.OnSubAccountEvent(func(res WsSubAccountReponse) {
+		mu.Lock()
+		events = append(events, res)
+		mu.Unlock()
+	})
+
+	testPaperBook(p, "BTC-PERP", 99, 100, 10)
+	params := PlaceOrderParams{Market: "BTC-PERP", Side: SideBuy, Type: OrderTypeLimit, Price: 90, Size: 1, ClientID: StringPointer("cid")}
+	order, err := c.NewPlaceOrderService().Params(params).Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// client ids are unique per subaccount
+	subOrder, err := sub.NewPlaceOrderService().Params(params).Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sub.NewGetOrderStatusService().OrderID(order.ID).Do(ctx); !isOrderNotFound(err) {
+		t.Fatalf("order of the main account seen from the subaccount, err = %v", err)
+	}
+	if err := sub.NewCancelOrderService().OrderID(order.ID).Do(ctx); !isOrderNotFound(err) {
+		t.Fatalf("order of the main account cancelled from the subaccount, err = %v", err)
+	}
+	if status, _ := sub.NewGetOrderStatusByClientIDService().ClientID("cid").Do(ctx); status == nil || status.ID != subOrder.ID {
+		t.Fatalf("client id lookup of the subaccount = %+v", status)
+	}
+	if err := sub.NewCancelAllOrderService().Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	open, err := c.NewGetOpenOrdersService().Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(open) != 1 || open[0].ID != order.ID {
+		t.Fatalf("open orders of the main account = %+v after the subaccount cancelled all", open)
+	}
+
+	testPaperBook(p, "BTC-PERP", 80, 89, 10)
+	if fills, _ := sub.NewFillsService().Do(ctx); len(fills) != 0 {
+		t.Fatalf("fills of the main account seen from the subaccount: %+v", fills)
+	}
+	if fills, _ := c.NewFillsService().Do(ctx); len(fills) != 1 {
+		t.Fatalf("fills = %+v, want the main account fill", fills)
+	}
+	waitFor(t, "events", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events) >= 5
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	for _, e := range events {
+		var id int64
+		if e.Orders != nil {
+			id = e.Orders.Data.ID
+		} else {
+			id = e.Fills.Data.OrderID
+		}
+		if want := map[int64]string{order.ID: "", subOrder.ID: "sub"}[id]; e.SubAccount != want {
+			t.Fatalf("event of order %d tagged %q, want %q", id, e.SubAccount, want)
+		}
+	}
+}
+
+func TestPaperModifyValidatesBeforeClosing(t *testing.T) {
+	c, p := newTestPaperClient(t)
+	sub := c.withSubAccount("sub")
+	ctx := context.Background()
+	testPaperBook(p, "BTC-PERP", 99, 100, 10)
+	order, err := sub.NewPlaceOrderService().Params(PlaceOrderParams{Market: "BTC-PERP", Side: SideBuy, Type: OrderTypeLimit, Price: 90, Size: 1}).Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *APIError
+	if _, err := sub.NewModifyOrderService().OrderID(order.ID).Params(ModifyOrderParams{Size: new(float64)}).Do(ctx); !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want the size rejected", err)
+	}
+	if status, _ := sub.NewGetOrderStatusService().OrderID(order.ID).Do(ctx); status.Status != OrderStatusOpen {
+		t.Fatalf("order = %+v, want it open after a rejected modification", status)
+	}
+	price := 91.0
+	replacement, err := sub.NewModifyOrderService().OrderID(order.ID).Params(ModifyOrderParams{Price: &price}).Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sub.NewGetOrderStatusService().OrderID(replacement.ID).Do(ctx); err != nil {
+		t.Fatalf("replacement not in the subaccount of the order: %v", err)
+	}
+}
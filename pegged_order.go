@@ -0,0 +1,542 @@
+package ftxapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type PegState string
+
+const (
+	PegStateWorking PegState = "working"
+	PegStateFilled  PegState = "filled"
+	// PegStateCancelled was cancelled with Cancel or by someone else
+	PegStateCancelled PegState = "cancelled"
+	// PegStateRejected had too many post only rejections
+	PegStateRejected PegState = "rejected"
+)
+
+func (s PegState) Done() bool {
+	return s != PegStateWorking
+}
+
+const (
+	DefaultPegMinRequoteInterval = 500 * time.Millisecond
+	DefaultPegMaxRejections      = 5
+	pegCheckInterval             = time.Second
+	pegSizeEpsilon               = 1e-9
+)
+
+var ErrPegRunning = errors.New("pegged order already running")
+
+// PegParams describes a post only order kept Offset behind the best bid or ask. It is re-priced
+// when the target moves more than Tolerance away, at most every MinRequoteInterval and at most
+// MaxChases times, zero means no limit. LimitPrice is the most aggressive price the peg follows to.
+type PegParams struct {
+	Market             string
+	Side               Side
+	Size               float64
+	Offset             float64
+	Tolerance          float64
+	LimitPrice         *float64
+	MinRequoteInterval time.Duration
+	MaxChases          int
+	MaxRejections      int
+	ClientID           string
+}
+
+// PegStatus is the state of a pegged order. OrderIDs is the chain of orders, the last one is
+// the live order, the others were replaced by modifications or rejected.
+type PegStatus struct {
+	ClientID   string
+	OrderIDs   []int64
+	Price      float64
+	Size       float64
+	Filled     float64
+	AvgPrice   float64
+	Chases     int
+	Rejections int
+	Exhausted  bool
+	State      PegState
+	Err        error
+}
+
+type PegHandler func(status PegStatus)
+
+// PeggedOrder keeps a post only limit order at the top of the book. Feed it the ticker of the
+// market and the orders and fills channels with Handle, Run does the quoting.
+type PeggedOrder struct {
+	l       *zap.SugaredLogger
+	c       *Client
+	tickers *TickerCache
+	params  PegParams
+	handler PegHandler
+
+	mu             sync.Mutex
+	running        bool
+	priceIncrement float64
+	legs           []*pegLeg
+	legSeq         int
+	fillIDs        map[int64]struct{}
+	lastQuote      time.Time
+	chases         int
+	rejections     int
+	cancelled      bool
+	state          PegState
+	lastErr        error
+	kickC          chan struct{}
+}
+
+type pegLeg struct {
+	clientID string
+	orderID  int64
+	price    float64
+	size     float64
+	// fills and order snapshots report the same executions, the larger of the two counts
+	fillSize     float64
+	fillNotional float64
+	filled       float64
+	notional     float64
+	closed       bool
+	// the placement or modification failed without a clear answer, looked up by client id
+	unknown bool
+}
+
+// NewPeggedOrder returns a pegged order for params, the ticker cache gives the book.
+func NewPeggedOrder(c *Client, tickers *TickerCache, params PegParams, handler PegHandler, l *zap.SugaredLogger) *PeggedOrder {
+	if params.ClientID == "" {
+		params.ClientID = "peg-" + NewClientID()[:16]
+	}
+	if params.MinRequoteInterval <= 0 {
+		params.MinRequoteInterval = DefaultPegMinRequoteInterval
+	}
+	if params.MaxRejections <= 0 {
+		params.MaxRejections = DefaultPegMaxRejections
+	}
+	return &PeggedOrder{
+		l:       l,
+		c:       c,
+		tickers: tickers,
+		params:  params,
+		handler: handler,
+		fillIDs: make(map[int64]struct{}),
+		state:   PegStateWorking,
+		kickC:   make(chan struct{}, 1),
+	}
+}
+
+// Run places the order and keeps it pegged until it is filled, cancelled or rejected, or ctx
+// is done. The order stays on the book when ctx is done.
+func (p *PeggedOrder) Run(ctx context.Context) error {
+	p.mu.Lock()
+	if p.running {
+		p.mu.Unlock()
+		return ErrPegRunning
+	}
+	p.running = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.running = false
+		p.mu.Unlock()
+	}()
+
+	market, err := p.c.NewGetSingleMarketsService().MarketName(p.params.Market).Do(ctx)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.priceIncrement = market.PriceIncrement
+	p.mu.Unlock()
+
+	t := time.NewTicker(pegCheckInterval)
+	defer t.Stop()
+	for {
+		if done := p.step(ctx); done {
+			p.report()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.kickC:
+		case <-t.C:
+		}
+	}
+}
+
+// Cancel cancels the live order and stops the peg.
+func (p *PeggedOrder) Cancel(ctx context.Context) error {
+	p.mu.Lock()
+	p.cancelled = true
+	leg := p.live()
+	p.mu.Unlock()
+	if leg != nil && !leg.closed {
+		var err error
+		if leg.orderID != 0 {
+			err = p.c.NewCancelOrderService().OrderID(leg.orderID).Do(ctx)
+		} else {
+			err = p.c.NewCancelOrderByClientIDService().ClientID(leg.clientID).Do(ctx)
+		}
+		if err != nil && !isAlreadyClosed(err) {
+			return err
+		}
+	}
+	p.kick()
+	return nil
+}
+
+func (p *PeggedOrder) Status() PegStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status()
+}
+
+// Handle consumes the ticker, orders and fills events of the websocket data handler.
+func (p *PeggedOrder) Handle(res WsReponse) {
+	switch {
+	case res.Ticker != nil:
+		if res.Ticker.Market != p.params.Market {
+			return
+		}
+	case res.Orders != nil:
+		o := res.Orders.Data
+		p.mu.Lock()
+		leg := p.leg(o.ID, o.ClientID)
+		if leg == nil {
+			p.mu.Unlock()
+			return
+		}
+		leg.orderID = o.ID
+		leg.unknown = false
+		leg.update(o.Status, o.FilledSize, o.AvgFillPrice)
+		p.mu.Unlock()
+		p.report()
+	case res.Fills != nil:
+		f := res.Fills.Data
+		p.mu.Lock()
+		leg := p.leg(f.OrderID, nil)
+		if _, seen := p.fillIDs[f.ID]; leg == nil || seen {
+			p.mu.Unlock()
+			return
+		}
+		p.fillIDs[f.ID] = struct{}{}
+		leg.fillSize += f.Size
+		leg.fillNotional += f.Size * f.Price
+		p.mu.Unlock()
+		p.report()
+	default:
+		return
+	}
+	p.kick()
+}
+
+// step does the next quoting action and reports whether the peg is finished.
+func (p *PeggedOrder) step(ctx context.Context) bool {
+	p.mu.Lock()
+	if p.state.Done() {
+		p.mu.Unlock()
+		return true
+	}
+	filled, _ := p.filled()
+	leg := p.live()
+	switch {
+	case p.params.Size-filled <= pegSizeEpsilon:
+		p.state = PegStateFilled
+	case leg != nil && leg.closed && p.cancelled:
+		p.state = PegStateCancelled
+	}
+	if p.state.Done() || p.cancelled {
+		done := p.state.Done()
+		p.mu.Unlock()
+		return done
+	}
+	if leg != nil && leg.unknown {
+		p.mu.Unlock()
+		p.lookup(ctx, leg)
+		p.report()
+		return false
+	}
+	target, ok := p.target()
+	if !ok {
+		p.mu.Unlock()
+		return false
+	}
+	remaining := p.params.Size - filled
+
+	switch {
+	case leg == nil || leg.closed:
+		if leg != nil {
+			if legFilled, _ := leg.executed(); legFilled > pegSizeEpsilon {
+				// a partially filled order was closed by someone else
+				p.state = PegStateCancelled
+				p.mu.Unlock()
+				return true
+			}
+			// closed without a fill and not by us, the post only order would have crossed
+			if p.rejections++; p.rejections >= p.params.MaxRejections {
+				p.state = PegStateRejected
+				p.mu.Unlock()
+				return true
+			}
+			target = p.backOff(target, p.rejections)
+		}
+		next := p.newLeg(target, remaining)
+		p.mu.Unlock()
+		p.place(ctx, next)
+	case math.Abs(target-leg.price) > p.params.Tolerance:
+		if time.Since(p.lastQuote) < p.params.MinRequoteInterval {
+			p.mu.Unlock()
+			return false
+		}
+		if p.params.MaxChases > 0 && p.chases >= p.params.MaxChases {
+			p.mu.Unlock()
+			return false
+		}
+		p.chases++
+		next := p.newLeg(target, remaining)
+		p.mu.Unlock()
+		p.modify(ctx, leg, next)
+	default:
+		p.mu.Unlock()
+	}
+	p.report()
+	return false
+}
+
+// newLeg must be called with p.mu held. Client ids are never reused, a dropped leg may still
+// show up on the exchange.
+func (p *PeggedOrder) newLeg(price, size float64) *pegLeg {
+	p.legSeq++
+	leg := &pegLeg{
+		clientID: fmt.Sprintf("%s-%d", p.params.ClientID, p.legSeq),
+		price:    price,
+		size:     size,
+	}
+	// known before the request so websocket events racing the response find it
+	p.legs = append(p.legs, leg)
+	p.lastQuote = time.Now()
+	return leg
+}
+
+func (p *PeggedOrder) place(ctx context.Context, leg *pegLeg) {
+	res, err := p.c.PlaceOrderIdempotent(ctx, PlaceOrderParams{
+		Market:   p.params.Market,
+		Side:     p.params.Side,
+		Price:    leg.price,
+		Type:     OrderTypeLimit,
+		Size:     leg.size,
+		PostOnly: BoolPointer(true),
+		ClientID: StringPointer(leg.clientID),
+	}, DefaultPlacementLookupWindow)
+	p.apply(leg, res.Order, err, res.Status == PlacementStatusNotPlaced)
+}
+
+// modify replaces the live order, FTX gives the replacement a new order id.
+func (p *PeggedOrder) modify(ctx context.Context, old, leg *pegLeg) {
+	var order *Order
+	var err error
+	if old.orderID != 0 {
+		order, err = p.c.NewModifyOrderService().OrderID(old.orderID).Params(ModifyOrderParams{
+			Price:    &leg.price,
+			Size:     &leg.size,
+			ClientID: StringPointer(leg.clientID),
+		}).Do(ctx)
+	} else {
+		order, err = p.c.NewModifyOrderByClientIDService().ClientID(old.clientID).Params(ModifyOrderByClientIDParams{
+			Price:    &leg.price,
+			Size:     &leg.size,
+			ClientID: StringPointer(leg.clientID),
+		}).Do(ctx)
+	}
+	p.mu.Lock()
+	if err == nil {
+		old.closed = true
+	}
+	p.mu.Unlock()
+	p.apply(leg, order, err, err != nil && !isAmbiguousError(err))
+}
+
+// apply records the answer of a placement or modification. A rejected leg is dropped, one with
+// an unknown outcome is kept and looked up on the next step.
+func (p *PeggedOrder) apply(leg *pegLeg, order *Order, err error, rejected bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastErr = err
+	switch {
+	case err == nil:
+		leg.orderID = order.ID
+		leg.unknown = false
+		leg.update(order.Status, order.FilledSize, order.AvgFillPrice)
+	case rejected:
+		p.l.With("func", "PeggedOrder.apply").Errorw("cannot quote", "client_id", leg.clientID, "err", err)
+		// the leg never made it, the live order stays what it was
+		p.dropLeg(leg)
+	default:
+		p.l.With("func", "PeggedOrder.apply").Warnw("quote outcome unknown", "client_id", leg.clientID, "err", err)
+		leg.unknown = true
+	}
+}
+
+// lookup resolves a leg whose placement or modification outcome was unknown.
+func (p *PeggedOrder) lookup(ctx context.Context, leg *pegLeg) {
+	order, err := p.c.NewGetOrderStatusByClientIDService().ClientID(leg.clientID).Do(ctx)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case isOrderNotFound(err):
+		if leg.unknown {
+			p.dropLeg(leg)
+		}
+	case err == nil:
+		leg.orderID = order.ID
+		leg.unknown = false
+		leg.update(order.Status, order.FilledSize, order.AvgFillPrice)
+	}
+}
+
+// dropLeg must be called with p.mu held.
+func (p *PeggedOrder) dropLeg(leg *pegLeg) {
+	for i, l := range p.legs {
+		if l == leg {
+			p.legs = append(p.legs[:i], p.legs[i+1:]...)
+			return
+		}
+	}
+}
+
+// target must be called with p.mu held.
+func (p *PeggedOrder) target() (float64, bool) {
+	if p.tickers == nil {
+		return 0, false
+	}
+	t, ok := p.tickers.Get(p.params.Market)
+	if !ok || t.Bid <= 0 || t.Ask <= 0 {
+		return 0, false
+	}
+	if p.params.Side == SideBuy {
+		price := t.Bid - p.params.Offset
+		if p.params.LimitPrice != nil {
+			price = math.Min(price, *p.params.LimitPrice)
+		}
+		return p.round(price, math.Floor), true
+	}
+	price := t.Ask + p.params.Offset
+	if p.params.LimitPrice != nil {
+		price = math.Max(price, *p.params.LimitPrice)
+	}
+	return p.round(price, math.Ceil), true
+}
+
+// backOff moves a rejected price away from the book by one tick per rejection.
+func (p *PeggedOrder) backOff(price float64, rejections int) float64 {
+	tick := p.priceIncrement * float64(rejections)
+	if p.params.Side == SideBuy {
+		return price - tick
+	}
+	return price + tick
+}
+
+func (p *PeggedOrder) round(price float64, f func(float64) float64) float64 {
+	if p.priceIncrement <= 0 {
+		return price
+	}
+	return f(price/p.priceIncrement+pegSizeEpsilon) * p.priceIncrement
+}
+
+// live returns the last leg, must be called with p.mu held.
+func (p *PeggedOrder) live() *pegLeg {
+	if len(p.legs) == 0 {
+		return nil
+	}
+	return p.legs[len(p.legs)-1]
+}
+
+func (p *PeggedOrder) leg(orderID int64, clientID *string) *pegLeg {
+	for i := len(p.legs) - 1; i >= 0; i-- {
+		leg := p.legs[i]
+		if (leg.orderID != 0 && leg.orderID == orderID) || (clientID != nil && leg.clientID == *clientID) {
+			return leg
+		}
+	}
+	return nil
+}
+
+func (p *PeggedOrder) filled() (size, avg float64) {
+	var notional float64
+	for _, leg := range p.legs {
+		s, n := leg.executed()
+		size += s
+		notional += n
+	}
+	if size > 0 {
+		avg = notional / size
+	}
+	return size, avg
+}
+
+func (p *PeggedOrder) status() PegStatus {
+	filled, avg := p.filled()
+	s := PegStatus{
+		ClientID:   p.params.ClientID,
+		Size:       p.params.Size,
+		Filled:     filled,
+		AvgPrice:   avg,
+		Chases:     p.chases,
+		Rejections: p.rejections,
+		Exhausted:  p.params.MaxChases > 0 && p.chases >= p.params.MaxChases,
+		State:      p.state,
+		Err:        p.lastErr,
+	}
+	for _, leg := range p.legs {
+		if leg.orderID != 0 {
+			s.OrderIDs = append(s.OrderIDs, leg.orderID)
+		}
+	}
+	if leg := p.live(); leg != nil {
+		s.Price = leg.price
+	}
+	return s
+}
+
+func (p *PeggedOrder) report() {
+	if p.handler == nil {
+		return
+	}
+	p.mu.Lock()
+	s := p.status()
+	p.mu.Unlock()
+	p.handler(s)
+}
+
+func (p *PeggedOrder) kick() {
+	select {
+	case p.kickC <- struct{}{}:
+	default:
+	}
+}
+
+// executed returns the filled size and notional of the leg, must be called with the peg lock held.
+func (leg *pegLeg) executed() (size, notional float64) {
+	if leg.fillSize > leg.filled {
+		return leg.fillSize, leg.fillNotional
+	}
+	return leg.filled, leg.notional
+}
+
+// update applies an order snapshot, must be called with the peg lock held.
+func (leg *pegLeg) update(status OrderStatus, filled, avg float64) {
+	if filled > leg.filled {
+		leg.filled = filled
+		leg.notional = filled * avg
+	}
+	if status == OrderStatusClosed || status == OrderStatusCancelled || status == OrderStatusFilled {
+		leg.closed = true
+	}
+}
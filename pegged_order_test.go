@@ -0,0 +1,222 @@
+package ftxapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testPegServer answers the market, placement and modification calls of a pegged order.
+// Every accepted order gets the next id, prices records the price per id.
+type testPegServer struct {
+	srv    *testRestServer
+	mu     sync.Mutex
+	nextID int64
+	prices map[int64]float64
+	// modifyErr, when set, answers the next modifications
+	modifyErr func(w http.ResponseWriter)
+}
+
+func newTestPegServer(srv *testRestServer) *testPegServer {
+	s := &testPegServer{srv: srv, prices: make(map[int64]float64)}
+	srv.handle("GET /markets/BTC-PERP", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"success": true, "result": {"name": "BTC-PERP", "priceIncrement": 0.5, "sizeIncrement": 0.001}}`)
+	})
+	srv.handle("POST /orders", func(w http.ResponseWriter, r *http.Request) {
+		var params PlaceOrderParams
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		s.accept(w, params.Price, *params.ClientID)
+	})
+	return s
+}
+
+func (s *testPegServer) accept(w http.ResponseWriter, price float64, clientID string) int64 {
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	s.prices[id] = price
+	s.mu.Unlock()
+	fmt.Fprintf(w, `{"success": true, "result": {"id": %d, "clientId": %q, "market": "BTC-PERP", "side": "buy", "type": "limit", "price": %v, "size": 1, "status": "open", "postOnly": true}}`, id, clientID, price)
+	s.srv.handle(fmt.Sprintf("POST /orders/%d/modify", id), func(w http.ResponseWriter, r *http.Request) {
+		var params ModifyOrderParams
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		s.mu.Lock()
+		fail := s.modifyErr
+		s.mu.Unlock()
+		if fail != nil {
+			fail(w)
+			return
+		}
+		s.accept(w, *params.Price, *params.ClientID)
+	})
+	s.srv.handle("GET /orders/by_client_id/"+clientID, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"success": true, "result": {"id": %d, "clientId": %q, "market": "BTC-PERP", "side": "buy", "type": "limit", "price": %v, "size": 1, "status": "open"}}`, id, clientID, price)
+	})
+	return id
+}
+
+func (s *testPegServer) price(id int64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prices[id]
+}
+
+func (s *testPegServer) orders() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextID
+}
+
+type testPeg struct {
+	*PeggedOrder
+	t       *testing.T
+	tickers *TickerCache
+	done    chan error
+}
+
+func newTestPeg(t *testing.T, c *Client, params PegParams) *testPeg {
+	params.Market, params.Side, params.Size, params.ClientID = "BTC-PERP", SideBuy, 1, "peg"
+	if params.MinRequoteInterval == 0 {
+		params.MinRequoteInterval = time.Millisecond
+	}
+	tickers := NewTickerCache()
+	p := &testPeg{PeggedOrder: NewPeggedOrder(c, tickers, params, nil, zap.NewNop().Sugar()), t: t, tickers: tickers, done: make(chan error, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	testTicker(tickers, "BTC-PERP", 100, 101)
+	go func() { p.done <- p.Run(ctx) }()
+	return p
+}
+
+// book moves the best bid and ask and kicks the peg.
+func (p *testPeg) book(bid, ask float64) {
+	testTicker(p.tickers, "BTC-PERP", bid, ask)
+	p.Handle(WsReponse{Ticker: &WsTickerEvent{baseWsEvent: baseWsEvent{Market: "BTC-PERP"}}})
+}
+
+func (p *testPeg) waitOrders(what string, ids ...int64) PegStatus {
+	p.t.Helper()
+	var s PegStatus
+	waitFor(p.t, what, func() bool {
+		s = p.Status()
+		return fmt.Sprint(s.OrderIDs) == fmt.Sprint(ids)
+	})
+	return s
+}
+
+func TestPeggedOrderChasesTheBid(t *testing.T) {
+	c, srv := newTestClient(t)
+	ps := newTestPegServer(srv)
+	p := newTestPeg(t, c, PegParams{})
+	p.waitOrders("first quote", 1)
+	if got := ps.price(1); got != 100 {
+		t.Fatalf("quoted at %v, want the bid 100", got)
+	}
+	p.book(102, 103)
+	s := p.waitOrders("chase", 1, 2)
+	if got := ps.price(2); got != 102 || s.Price != 102 || s.Chases != 1 {
+		t.Fatalf("replacement at %v, status %+v, want 102 after one chase", got, s)
+	}
+	p.book(103, 104)
+	p.waitOrders("replaced id chain", 1, 2, 3)
+}
+
+func TestPeggedOrderMaxChases(t *testing.T) {
+	c, srv := newTestClient(t)
+	ps := newTestPegServer(srv)
+	p := newTestPeg(t, c, PegParams{MaxChases: 1})
+	p.waitOrders("first quote", 1)
+	p.book(102, 103)
+	p.waitOrders("chase", 1, 2)
+	p.book(105, 106)
+	time.Sleep(50 * time.Millisecond)
+	if s := p.Status(); !s.Exhausted || s.Chases != 1 || ps.orders() != 2 {
+		t.Fatalf("status = %+v after %d orders, want exhausted after one chase", s, ps.orders())
+	}
+}
+
+func TestPeggedOrderPostOnlyRejections(t *testing.T) {
+	c, srv := newTestClient(t)
+	ps := newTestPegServer(srv)
+	p := newTestPeg(t, c, PegParams{MaxRejections: 2})
+	p.waitOrders("first quote", 1)
+	// the post only order would have crossed and was closed without a fill
+	p.Handle(WsReponse{Orders: &WsOrdersEvent{Data: WsOrders{ID: 1, Market: "BTC-PERP", Side: SideBuy, Size: 1, RemainingSize: 1, Status: OrderStatusClosed}}})
+	p.waitOrders("requote", 1, 2)
+	if got := ps.price(2); got != 99.5 {
+		t.Fatalf("requoted at %v, want one tick away from the bid", got)
+	}
+	p.Handle(WsReponse{Orders: &WsOrdersEvent{Data: WsOrders{ID: 2, Market: "BTC-PERP", Side: SideBuy, Size: 1, RemainingSize: 1, Status: OrderStatusClosed}}})
+	select {
+	case err := <-p.done:
+		if s := p.Status(); err != nil || s.State != PegStateRejected || s.Rejections != 2 {
+			t.Fatalf("status = %+v, err = %v, want rejected", s, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("peg still running after the rejections")
+	}
+}
+
+func TestPeggedOrderAmbiguousModifyKeepsLeg(t *testing.T) {
+	c, srv := newTestClient(t)
+	ps := newTestPegServer(srv)
+	p := newTestPeg(t, c, PegParams{})
+	p.waitOrders("first quote", 1)
+
+	// the modification went through but its answer was lost
+	ps.mu.Lock()
+	ps.modifyErr = func(w http.ResponseWriter) {
+		ps.mu.Lock()
+		ps.modifyErr = nil
+		ps.nextID++
+		id := ps.nextID
+		ps.prices[id] = 102
+		ps.mu.Unlock()
+		w.WriteHeader(http.StatusBadGateway)
+		srv.handle("GET /orders/by_client_id/peg-2", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"success": true, "result": {"id": %d, "clientId": "peg-2", "market": "BTC-PERP", "side": "buy", "type": "limit", "price": 102, "size": 1, "status": "open"}}`, id)
+		})
+	}
+	ps.mu.Unlock()
+	p.book(102, 103)
+	s := p.waitOrders("looked up replacement", 1, 2)
+	if s.Price != 102 {
+		t.Fatalf("status = %+v, want the replacement live", s)
+	}
+}
+
+func TestPeggedOrderNeverReusesClientIDs(t *testing.T) {
+	c, srv := newTestClient(t)
+	newTestPegServer(srv)
+	p := newTestPeg(t, c, PegParams{})
+	p.waitOrders("first quote", 1)
+
+	var clientIDs []string
+	var mu sync.Mutex
+	srv.handle("POST /orders/1/modify", func(w http.ResponseWriter, r *http.Request) {
+		var params ModifyOrderParams
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		mu.Lock()
+		clientIDs = append(clientIDs, *params.ClientID)
+		mu.Unlock()
+		testAPIError(w, http.StatusBadRequest, "Invalid price")
+	})
+	p.book(102, 103)
+	waitFor(t, "retried modification", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(clientIDs) >= 2
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(clientIDs[:2], ",") != "peg-2,peg-3" {
+		t.Fatalf("modifications sent client ids %v, want a new one per attempt", clientIDs)
+	}
+}
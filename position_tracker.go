@@ -0,0 +1,290 @@
+package ftxapi
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPositionDriftTolerance = 1e-8
+)
+
+// TrackedPosition is a position rebuilt from fills. RealizedPnl doesn't include Fees,
+// UnrealizedPnl is marked against the ticker cache and is zero without a ticker.
+type TrackedPosition struct {
+	Market        string
+	NetSize       float64
+	AvgEntryPrice float64
+	RealizedPnl   float64
+	UnrealizedPnl float64
+	Fees          float64
+	MarkPrice     float64
+	LastFillTime  time.Time
+}
+
+// PositionDrift is a difference between the tracked size and what the exchange reports.
+// Coin is set for spot balances, Market for futures positions.
+type PositionDrift struct {
+	Market   string
+	Coin     string
+	Local    float64
+	Exchange float64
+}
+
+type PositionDriftHandler func(drifts []PositionDrift)
+
+// PositionTracker keeps per market positions from FillsService history and the live fills
+// channel, feed it with Handle. Spot balances include coins moved by deposits and transfers,
+// so they are compared by their change since the first reconciliation.
+type PositionTracker struct {
+	c            *Client
+	tickers      *TickerCache
+	mu           sync.Mutex
+	positions    map[string]*TrackedPosition
+	fillIDs      map[int64]struct{}
+	tolerance    float64
+	spotOffsets  map[string]float64
+	driftHandler PositionDriftHandler
+}
+
+type trackedFill struct {
+	id     int64
+	market string
+	side   Side
+	size   float64
+	price  float64
+	fee    float64
+	time   time.Time
+}
+
+func NewPositionTracker(c *Client, tickers *TickerCache) *PositionTracker {
+	return &PositionTracker{
+		c:           c,
+		tickers:     tickers,
+		positions:   make(map[string]*TrackedPosition),
+		fillIDs:     make(map[int64]struct{}),
+		tolerance:   DefaultPositionDriftTolerance,
+		spotOffsets: make(map[string]float64),
+	}
+}
+
+// DriftTolerance sets the size difference below which reconciliation reports nothing.
+func (t *PositionTracker) DriftTolerance(tolerance float64) *PositionTracker {
+	t.tolerance = tolerance
+	return t
+}
+
+func (t *PositionTracker) OnDrift(h PositionDriftHandler) *PositionTracker {
+	t.driftHandler = h
+	return t
+}
+
+// Load replays the fill history since start from FillsService.
+func (t *PositionTracker) Load(ctx context.Context, start time.Time) error {
+	fills, err := fillsSince(ctx, t.c, start)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range fills {
+		t.apply(trackedFill{
+			id:     int64(f.ID),
+			market: f.Market,
+			side:   Side(f.Side),
+			size:   f.Size,
+			price:  f.Price,
+			fee:    f.Fee,
+			time:   f.Time,
+		})
+	}
+	return nil
+}
+
+// Handle consumes the fills events of the websocket data handler, other events are ignored.
+func (t *PositionTracker) Handle(res WsReponse) {
+	if res.Fills == nil {
+		return
+	}
+	f := res.Fills.Data
+	t.mu.Lock()
+	t.apply(trackedFill{
+		id:     f.ID,
+		market: f.Market,
+		side:   f.Side,
+		size:   f.Size,
+		price:  f.Price,
+		fee:    f.Fee,
+		time:   f.Time,
+	})
+	t.mu.Unlock()
+}
+
+func (t *PositionTracker) Position(market string) (TrackedPosition, bool) {
+	t.mu.Lock()
+	p, ok := t.positions[market]
+	var res TrackedPosition
+	if ok {
+		res = *p
+	}
+	t.mu.Unlock()
+	if ok {
+		t.mark(&res)
+	}
+	return res, ok
+}
+
+func (t *PositionTracker) Positions() []TrackedPosition {
+	t.mu.Lock()
+	res := make([]TrackedPosition, 0, len(t.positions))
+	for _, p := range t.positions {
+		res = append(res, *p)
+	}
+	t.mu.Unlock()
+	for i := range res {
+		t.mark(&res[i])
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Market < res[j].Market })
+	return res
+}
+
+// Reconcile compares tracked sizes with GetPositionsService and GetBalancesService
+// and reports the drifts to the drift handler as well as returning them.
+func (t *PositionTracker) Reconcile(ctx context.Context) ([]PositionDrift, error) {
+	positions, err := t.c.NewGetPositionsService().Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	balances, err := t.c.NewGetBalancesService().Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	exchangeFutures := make(map[string]float64, len(positions))
+	for _, p := range positions {
+		exchangeFutures[p.Future] = p.NetSize
+	}
+	exchangeCoins := make(map[string]float64, len(balances))
+	for _, b := range balances {
+		exchangeCoins[b.Coin] = b.Total
+	}
+
+	var drifts []PositionDrift
+	t.mu.Lock()
+	localCoins := make(map[string]float64)
+	for market, p := range t.positions {
+		if base, ok := spotBaseCurrency(market); ok {
+			localCoins[base] += p.NetSize
+			continue
+		}
+		if exchange := exchangeFutures[market]; math.Abs(exchange-p.NetSize) > t.tolerance {
+			drifts = append(drifts, PositionDrift{Market: market, Local: p.NetSize, Exchange: exchange})
+		}
+	}
+	for future, size := range exchangeFutures {
+		if _, ok := t.positions[future]; !ok && math.Abs(size) > t.tolerance {
+			drifts = append(drifts, PositionDrift{Market: future, Exchange: size})
+		}
+	}
+	for coin, local := range localCoins {
+		offset, ok := t.spotOffsets[coin]
+		if !ok {
+			t.spotOffsets[coin] = exchangeCoins[coin] - local
+			continue
+		}
+		if exchange := exchangeCoins[coin] - offset; math.Abs(exchange-local) > t.tolerance {
+			drifts = append(drifts, PositionDrift{Coin: coin, Local: local, Exchange: exchange})
+		}
+	}
+	h := t.driftHandler
+	t.mu.Unlock()
+	if h != nil && len(drifts) > 0 {
+		h(drifts)
+	}
+	return drifts, nil
+}
+
+// RunReconcile calls Reconcile every interval until ctx is done, errors go to errHandler.
+func (t *PositionTracker) RunReconcile(ctx context.Context, interval time.Duration, errHandler func(err error)) {
+	tm := time.NewTicker(interval)
+	defer tm.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tm.C:
+			if _, err := t.Reconcile(ctx); err != nil && errHandler != nil {
+				errHandler(err)
+			}
+		}
+	}
+}
+
+// apply must be called with t.mu held.
+func (t *PositionTracker) apply(f trackedFill) {
+	if _, ok := t.fillIDs[f.id]; ok {
+		return
+	}
+	t.fillIDs[f.id] = struct{}{}
+	p, ok := t.positions[f.market]
+	if !ok {
+		p = &TrackedPosition{Market: f.market}
+		t.positions[f.market] = p
+	}
+	signed := f.size
+	if f.side == SideSell {
+		signed = -f.size
+	}
+	switch {
+	case p.NetSize == 0 || (p.NetSize > 0) == (signed > 0):
+		total := math.Abs(p.NetSize) + f.size
+		p.AvgEntryPrice = (p.AvgEntryPrice*math.Abs(p.NetSize) + f.price*f.size) / total
+		p.NetSize += signed
+	default:
+		closed := math.Min(f.size, math.Abs(p.NetSize))
+		direction := 1.0
+		if p.NetSize < 0 {
+			direction = -1
+		}
+		p.RealizedPnl += closed * (f.price - p.AvgEntryPrice) * direction
+		p.NetSize += signed
+		switch {
+		case math.Abs(p.NetSize) <= t.tolerance:
+			p.NetSize = 0
+			p.AvgEntryPrice = 0
+		case (p.NetSize > 0) != (direction > 0):
+			// flipped, the rest opens a new position at the fill price
+			p.AvgEntryPrice = f.price
+		}
+	}
+	p.Fees += f.fee
+	if f.time.After(p.LastFillTime) {
+		p.LastFillTime = f.time
+	}
+}
+
+func (t *PositionTracker) mark(p *TrackedPosition) {
+	if t.tickers == nil || p.NetSize == 0 {
+		return
+	}
+	ticker, ok := t.tickers.Get(p.Market)
+	if !ok {
+		return
+	}
+	p.MarkPrice = ticker.Last
+	if ticker.Bid > 0 && ticker.Ask > 0 {
+		p.MarkPrice = ticker.Mid()
+	}
+	p.UnrealizedPnl = (p.MarkPrice - p.AvgEntryPrice) * p.NetSize
+}
+
+func spotBaseCurrency(market string) (string, bool) {
+	i := strings.Index(market, "/")
+	if i <= 0 {
+		return "", false
+	}
+	return market[:i], true
+}
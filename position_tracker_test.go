@@ -0,0 +1,73 @@
+package ftxapi
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func testFill(id int64, market string, side Side, size, price, fee float64) WsReponse {
+	return WsReponse{Fills: &WsFillsEvent{Data: WsFills{
+		ID:     id,
+		Market: market,
+		Side:   side,
+		Size:   size,
+		Price:  price,
+		Fee:    fee,
+		Time:   time.Date(2022, 10, 5, 20, 0, int(id), 0, time.UTC),
+	}}}
+}
+
+func assertPosition(t *testing.T, tr *PositionTracker, market string, size, entry, realized float64) {
+	t.Helper()
+	p, ok := tr.Position(market)
+	if !ok {
+		t.Fatalf("no position in %s", market)
+	}
+	if math.Abs(p.NetSize-size) > 1e-9 || math.Abs(p.AvgEntryPrice-entry) > 1e-9 || math.Abs(p.RealizedPnl-realized) > 1e-9 {
+		t.Fatalf("position = size %g entry %g realized %g, want %g %g %g", p.NetSize, p.AvgEntryPrice, p.RealizedPnl, size, entry, realized)
+	}
+}
+
+func TestPositionTrackerLongRoundTrip(t *testing.T) {
+	tr := NewPositionTracker(nil, nil)
+	tr.Handle(testFill(1, "BTC-PERP", SideBuy, 1, 100, 0.07))
+	tr.Handle(testFill(2, "BTC-PERP", SideBuy, 3, 120, 0.25))
+	assertPosition(t, tr, "BTC-PERP", 4, 115, 0)
+
+	tr.Handle(testFill(3, "BTC-PERP", SideSell, 1, 130, 0.09))
+	assertPosition(t, tr, "BTC-PERP", 3, 115, 15)
+	// a fill seen again from the backfill is not counted twice
+	tr.Handle(testFill(3, "BTC-PERP", SideSell, 1, 130, 0.09))
+	assertPosition(t, tr, "BTC-PERP", 3, 115, 15)
+
+	tr.Handle(testFill(4, "BTC-PERP", SideSell, 3, 110, 0.23))
+	assertPosition(t, tr, "BTC-PERP", 0, 0, 0)
+	p, _ := tr.Position("BTC-PERP")
+	if math.Abs(p.Fees-0.64) > 1e-9 || !p.LastFillTime.Equal(time.Date(2022, 10, 5, 20, 0, 4, 0, time.UTC)) {
+		t.Fatalf("fees = %g, last fill = %s", p.Fees, p.LastFillTime)
+	}
+}
+
+func TestPositionTrackerFlip(t *testing.T) {
+	tr := NewPositionTracker(nil, nil)
+	tr.Handle(testFill(1, "ETH-PERP", SideSell, 2, 50, 0))
+	assertPosition(t, tr, "ETH-PERP", -2, 50, 0)
+	// closes the short at a loss and opens a long with the rest
+	tr.Handle(testFill(2, "ETH-PERP", SideBuy, 5, 55, 0))
+	assertPosition(t, tr, "ETH-PERP", 3, 55, -10)
+	tr.Handle(testFill(3, "ETH-PERP", SideSell, 1, 60, 0))
+	assertPosition(t, tr, "ETH-PERP", 2, 55, -5)
+}
+
+func TestPositionTrackerMarksAgainstTicker(t *testing.T) {
+	tickers := NewTickerCache()
+	tr := NewPositionTracker(nil, tickers)
+	tr.Handle(testFill(1, "SOL/USD", SideBuy, 10, 20, 0))
+	bid, ask := 21.0, 23.0
+	tickers.Handle(WsReponse{Ticker: &WsTickerEvent{baseWsEvent: baseWsEvent{Market: "SOL/USD"}, Data: WsTicker{Bid: &bid, Ask: &ask}}})
+	p, _ := tr.Position("SOL/USD")
+	if p.MarkPrice != 22 || p.UnrealizedPnl != 20 {
+		t.Fatalf("mark = %g, unrealized = %g, want 22 and 20", p.MarkPrice, p.UnrealizedPnl)
+	}
+}
@@ -0,0 +1,349 @@
+package ftxapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// RiskGate is called by PlaceOrderService, ModifyOrderService, ModifyOrderByClientIDService,
+// PlaceTriggerOrderService and WithdrawService before the request is sent, set it with Config.RiskGate.
+// c is the client sending the request, a non nil error cancels the request.
+type RiskGate interface {
+	CheckOrder(ctx context.Context, c *Client, o RiskOrder) error
+	CheckWithdraw(ctx context.Context, c *Client, params WithdrawParams) error
+}
+
+// RiskOrder is an order about to be sent. Modifications have ModifyOrderID or ModifyClientID
+// set and only carry the changed price and size.
+type RiskOrder struct {
+	Market         string
+	Side           Side
+	Size           float64
+	Price          *float64
+	ReduceOnly     bool
+	Trigger        bool
+	ModifyOrderID  *int64
+	ModifyClientID *string
+}
+
+type RiskCheck string
+
+const (
+	RiskCheckOrderNotional  RiskCheck = "order_notional"
+	RiskCheckPosition       RiskCheck = "position"
+	RiskCheckPriceCollar    RiskCheck = "price_collar"
+	RiskCheckOrderRate      RiskCheck = "order_rate"
+	RiskCheckCollateral     RiskCheck = "collateral"
+	RiskCheckWithdrawSize   RiskCheck = "withdraw_size"
+	RiskCheckReferencePrice RiskCheck = "reference_price"
+)
+
+var ErrRiskRejected = errors.New("rejected by risk engine")
+
+// RiskError is a request rejected by the risk engine, errors.Is matches it with ErrRiskRejected.
+type RiskError struct {
+	Check      RiskCheck
+	SubAccount string
+	Market     string
+	Limit      float64
+	Value      float64
+}
+
+func (e *RiskError) Error() string {
+	return fmt.Sprintf("rejected by risk engine, check = %s, subaccount = %q, market = %s, limit = %g, value = %g",
+		e.Check, e.SubAccount, e.Market, e.Limit, e.Value)
+}
+
+func (e *RiskError) Is(target error) bool {
+	return target == ErrRiskRejected
+}
+
+// RiskLimits are the limits of the risk engine, zero values are no limit. MaxPosition is the
+// absolute base size per market, DefaultMaxPosition applies to markets without their own.
+// PriceCollar is the largest relative distance between a limit price and the reference price.
+// MaxCollateralShare is the largest share of free collateral the notional of one order may take.
+// With MaxWithdrawSize set, coins it doesn't list can't be withdrawn.
+type RiskLimits struct {
+	MaxOrderNotional   float64
+	MaxPosition        map[string]float64
+	DefaultMaxPosition float64
+	PriceCollar        float64
+	MaxOrdersPerSecond int
+	MaxCollateralShare float64
+	MaxWithdrawSize    map[string]float64
+}
+
+const riskCollateralTTL = time.Second
+
+// RiskEngine is the RiskGate of the package. Prices come from the ticker cache and positions
+// from the position tracker of the account when they are set, from the REST API otherwise. Limits can be
+// replaced at any time with SetLimits.
+type RiskEngine struct {
+	mu         sync.RWMutex
+	limits     RiskLimits
+	tickers    *TickerCache
+	positions  map[string]*PositionTracker
+	orders     *OrderManager
+	rateMu     sync.Mutex
+	sent       map[string][]time.Time
+	collateral map[string]riskCollateral
+}
+
+type riskCollateral struct {
+	free float64
+	at   time.Time
+}
+
+func NewRiskEngine(limits RiskLimits) *RiskEngine {
+	return &RiskEngine{
+		limits:     limits,
+		positions:  make(map[string]*PositionTracker),
+		sent:       make(map[string][]time.Time),
+		collateral: make(map[string]riskCollateral),
+	}
+}
+
+// Tickers sets the ticker cache giving the reference price.
+func (e *RiskEngine) Tickers(t *TickerCache) *RiskEngine {
+	e.tickers = t
+	return e
+}
+
+// Positions sets the tracker giving the current positions of the account of its client, call
+// it once per subaccount.
+func (e *RiskEngine) Positions(t *PositionTracker) *RiskEngine {
+	e.mu.Lock()
+	e.positions[t.c.subAccountName()] = t
+	e.mu.Unlock()
+	return e
+}
+
+// Orders sets the order manager used to look up the orders being modified.
+func (e *RiskEngine) Orders(m *OrderManager) *RiskEngine {
+	e.orders = m
+	return e
+}
+
+func (e *RiskEngine) SetLimits(limits RiskLimits) {
+	e.mu.Lock()
+	e.limits = limits
+	e.mu.Unlock()
+}
+
+func (e *RiskEngine) Limits() RiskLimits {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.limits
+}
+
+func (e *RiskEngine) CheckOrder(ctx context.Context, c *Client, o RiskOrder) error {
+	limits := e.Limits()
+	sa := c.subAccountName()
+	if err := e.resolveModify(ctx, c, &o); err != nil {
+		return err
+	}
+	ref, err := e.referencePrice(ctx, c, o.Market)
+	if err != nil {
+		return err
+	}
+	if ref <= 0 {
+		return &RiskError{Check: RiskCheckReferencePrice, SubAccount: sa, Market: o.Market}
+	}
+	price := ref
+	if o.Price != nil && *o.Price > 0 {
+		price = *o.Price
+	}
+	notional := math.Abs(o.Size) * price
+
+	// the trigger price of a stop is far from the book by design
+	if limits.PriceCollar > 0 && o.Price != nil && !o.Trigger {
+		if dist := math.Abs(*o.Price-ref) / ref; dist > limits.PriceCollar {
+			return &RiskError{Check: RiskCheckPriceCollar, SubAccount: sa, Market: o.Market, Limit: limits.PriceCollar, Value: dist}
+		}
+	}
+	if limits.MaxOrderNotional > 0 && notional > limits.MaxOrderNotional {
+		return &RiskError{Check: RiskCheckOrderNotional, SubAccount: sa, Market: o.Market, Limit: limits.MaxOrderNotional, Value: notional}
+	}
+	maxPosition := limits.DefaultMaxPosition
+	if m, ok := limits.MaxPosition[o.Market]; ok {
+		maxPosition = m
+	}
+	if maxPosition > 0 && !o.ReduceOnly {
+		current, err := e.position(ctx, c, o.Market)
+		if err != nil {
+			return err
+		}
+		next := current + o.Size
+		if o.Side == SideSell {
+			next = current - o.Size
+		}
+		if math.Abs(next) > maxPosition && math.Abs(next) > math.Abs(current) {
+			return &RiskError{Check: RiskCheckPosition, SubAccount: sa, Market: o.Market, Limit: maxPosition, Value: next}
+		}
+	}
+	if limits.MaxCollateralShare > 0 && !o.ReduceOnly {
+		free, err := e.freeCollateral(ctx, c, sa)
+		if err != nil {
+			return err
+		}
+		if share := notional / free; free <= 0 || share > limits.MaxCollateralShare {
+			return &RiskError{Check: RiskCheckCollateral, SubAccount: sa, Market: o.Market, Limit: limits.MaxCollateralShare, Value: share}
+		}
+	}
+	// counted last so rejected orders don't use up the rate
+	if limits.MaxOrdersPerSecond > 0 && !e.allowOrder(sa, limits.MaxOrdersPerSecond) {
+		return &RiskError{Check: RiskCheckOrderRate, SubAccount: sa, Market: o.Market, Limit: float64(limits.MaxOrdersPerSecond)}
+	}
+	return nil
+}
+
+func (e *RiskEngine) CheckWithdraw(ctx context.Context, c *Client, params WithdrawParams) error {
+	limits := e.Limits()
+	if limits.MaxWithdrawSize == nil {
+		return nil
+	}
+	max, ok := limits.MaxWithdrawSize[params.Coin]
+	if !ok || params.Size > max {
+		return &RiskError{Check: RiskCheckWithdrawSize, SubAccount: c.subAccountName(), Market: params.Coin, Limit: max, Value: params.Size}
+	}
+	return nil
+}
+
+// resolveModify fills in the market, side and unchanged fields of a modification.
+func (e *RiskEngine) resolveModify(ctx context.Context, c *Client, o *RiskOrder) error {
+	if o.ModifyOrderID == nil && o.ModifyClientID == nil {
+		return nil
+	}
+	var order Order
+	found := false
+	if e.orders != nil {
+		var m ManagedOrder
+		if o.ModifyOrderID != nil {
+			m, found = e.orders.Order(*o.ModifyOrderID)
+		} else {
+			m, found = e.orders.OrderByClientID(*o.ModifyClientID)
+		}
+		if found {
+			order = Order{Market: m.Market, Side: m.Side, Price: m.Price, Size: m.Size, FilledSize: m.FilledSize, ReduceOnly: m.ReduceOnly}
+		}
+	}
+	if !found {
+		var res *Order
+		var err error
+		if o.ModifyOrderID != nil {
+			res, err = c.NewGetOrderStatusService().OrderID(*o.ModifyOrderID).Do(ctx)
+		} else {
+			res, err = c.NewGetOrderStatusByClientIDService().ClientID(*o.ModifyClientID).Do(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		order = *res
+	}
+	o.Market = order.Market
+	o.Side = order.Side
+	o.ReduceOnly = order.ReduceOnly
+	if o.Price == nil {
+		o.Price = &order.Price
+	}
+	// the replacement is checked like a new order for what is left of it
+	size := order.Size
+	if o.Size > 0 {
+		size = o.Size
+	}
+	o.Size = math.Max(size-order.FilledSize, 0)
+	return nil
+}
+
+func (e *RiskEngine) referencePrice(ctx context.Context, c *Client, market string) (float64, error) {
+	if e.tickers != nil {
+		if t, ok := e.tickers.Get(market); ok {
+			if t.Last > 0 {
+				return t.Last, nil
+			}
+			if t.Bid > 0 && t.Ask > 0 {
+				return t.Mid(), nil
+			}
+		}
+	}
+	m, err := c.NewGetSingleMarketsService().MarketName(market).Do(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if m.Last > 0 {
+		return m.Last, nil
+	}
+	return m.Price, nil
+}
+
+func (e *RiskEngine) position(ctx context.Context, c *Client, market string) (float64, error) {
+	e.mu.RLock()
+	tracker, ok := e.positions[c.subAccountName()]
+	e.mu.RUnlock()
+	if ok {
+		p, _ := tracker.Position(market)
+		return p.NetSize, nil
+	}
+	if base, ok := spotBaseCurrency(market); ok {
+		balances, err := c.NewGetBalancesService().Do(ctx)
+		if err != nil {
+			return 0, err
+		}
+		for _, b := range balances {
+			if b.Coin == base {
+				return b.Total, nil
+			}
+		}
+		return 0, nil
+	}
+	positions, err := c.NewGetPositionsService().Do(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range positions {
+		if p.Future == market {
+			return p.NetSize, nil
+		}
+	}
+	return 0, nil
+}
+
+func (e *RiskEngine) freeCollateral(ctx context.Context, c *Client, sa string) (float64, error) {
+	e.rateMu.Lock()
+	cached, ok := e.collateral[sa]
+	e.rateMu.Unlock()
+	if ok && time.Since(cached.at) < riskCollateralTTL {
+		return cached.free, nil
+	}
+	account, err := c.NewGetAccountService().Do(ctx)
+	if err != nil {
+		return 0, err
+	}
+	e.rateMu.Lock()
+	e.collateral[sa] = riskCollateral{free: account.FreeCollateral, at: time.Now()}
+	e.rateMu.Unlock()
+	return account.FreeCollateral, nil
+}
+
+// allowOrder counts an order against the one second window of the subaccount.
+func (e *RiskEngine) allowOrder(sa string, max int) bool {
+	e.rateMu.Lock()
+	defer e.rateMu.Unlock()
+	now := time.Now()
+	window := e.sent[sa]
+	i := 0
+	for i < len(window) && now.Sub(window[i]) >= time.Second {
+		i++
+	}
+	window = window[i:]
+	if len(window) >= max {
+		e.sent[sa] = window
+		return false
+	}
+	e.sent[sa] = append(window, now)
+	return true
+}
@@ -41,6 +41,9 @@ type TransferBetweenSubAccountsResponse struct {
 }
 
 func (s *TransferBetweenSubAccountsService) Do(ctx context.Context) (*TransferBetweenSubAccounts, error) {
+	if s.c.paper != nil {
+		return s.c.paper.transfer(s.params)
+	}
 	r := newRequest(http.MethodPost, "/subaccounts/transfer", true)
 	body, err := json.Marshal(s.params)
 	if err != nil {
@@ -0,0 +1,227 @@
+package ftxapi
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const DefaultTickerLatencySamples = 1024
+
+// Ticker is the latest ticker of a market. Time is the exchange time, ReceivedAt the local one.
+type Ticker struct {
+	Market     string
+	Bid        float64
+	Ask        float64
+	Last       float64
+	BidSize    float64
+	AskSize    float64
+	Time       time.Time
+	ReceivedAt time.Time
+}
+
+// Mid returns the middle of bid and ask.
+func (t Ticker) Mid() float64 {
+	return (t.Bid + t.Ask) / 2
+}
+
+// LatencyStats describes the exchange to local delay over the recent ticker updates of a market.
+// Values include the clock offset between the exchange and this host.
+type LatencyStats struct {
+	Count int
+	Min   time.Duration
+	Max   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+}
+
+// TickerCache keeps the latest ticker per market from the ticker channel, feed it with Handle.
+type TickerCache struct {
+	mu      sync.RWMutex
+	samples int
+	tickers map[string]Ticker
+	latency map[string]*latencyWindow
+	waiters map[string]map[*tickerWaiter]struct{}
+}
+
+type latencyWindow struct {
+	values []time.Duration
+	next   int
+	full   bool
+}
+
+type tickerWaiter struct {
+	predicate func(t Ticker) bool
+	c         chan Ticker
+}
+
+func NewTickerCache() *TickerCache {
+	return &TickerCache{
+		samples: DefaultTickerLatencySamples,
+		tickers: make(map[string]Ticker),
+		latency: make(map[string]*latencyWindow),
+		waiters: make(map[string]map[*tickerWaiter]struct{}),
+	}
+}
+
+// LatencySamples sets how many recent updates per market the latency stats are computed from.
+func (c *TickerCache) LatencySamples(n int) *TickerCache {
+	if n > 0 {
+		c.samples = n
+	}
+	return c
+}
+
+// Handle consumes the ticker events of the websocket data handler, other events are ignored.
+func (c *TickerCache) Handle(res WsReponse) {
+	if res.Ticker == nil {
+		return
+	}
+	now := time.Now()
+	market := res.Ticker.Market
+	data := res.Ticker.Data
+	c.mu.Lock()
+	t := c.tickers[market]
+	t.Market = market
+	if data.Bid != nil {
+		t.Bid = *data.Bid
+	}
+	if data.Ask != nil {
+		t.Ask = *data.Ask
+	}
+	if data.Last != nil {
+		t.Last = *data.Last
+	}
+	if data.BidSize != nil {
+		t.BidSize = *data.BidSize
+	}
+	if data.AskSize != nil {
+		t.AskSize = *data.AskSize
+	}
+	t.Time = data.Timestamp()
+	t.ReceivedAt = now
+	c.tickers[market] = t
+
+	w, ok := c.latency[market]
+	if !ok {
+		w = &latencyWindow{values: make([]time.Duration, c.samples)}
+		c.latency[market] = w
+	}
+	w.add(now.Sub(t.Time))
+
+	waiters := make([]*tickerWaiter, 0, len(c.waiters[market]))
+	for waiter := range c.waiters[market] {
+		waiters = append(waiters, waiter)
+	}
+	c.mu.Unlock()
+
+	// predicates run without the lock, they may call back into the cache
+	for _, waiter := range waiters {
+		if waiter.predicate(t) && c.removeWaiter(market, waiter) {
+			waiter.c <- t
+		}
+	}
+}
+
+func (c *TickerCache) Get(market string) (Ticker, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	t, ok := c.tickers[market]
+	return t, ok
+}
+
+func (c *TickerCache) Latency(market string) LatencyStats {
+	c.mu.RLock()
+	w, ok := c.latency[market]
+	var values []time.Duration
+	if ok {
+		values = w.snapshot()
+	}
+	c.mu.RUnlock()
+	return latencyStats(values)
+}
+
+// WaitForPrice blocks until the ticker of market satisfies predicate or ctx is done.
+// The current ticker is checked first. predicate runs outside the cache lock and may call Get,
+// it may be called from concurrent Handle calls.
+func (c *TickerCache) WaitForPrice(ctx context.Context, market string, predicate func(t Ticker) bool) (Ticker, error) {
+	waiter := &tickerWaiter{predicate: predicate, c: make(chan Ticker, 1)}
+	c.mu.Lock()
+	if c.waiters[market] == nil {
+		c.waiters[market] = make(map[*tickerWaiter]struct{})
+	}
+	c.waiters[market][waiter] = struct{}{}
+	t, ok := c.tickers[market]
+	c.mu.Unlock()
+	// registered before the check, so an update racing with it is not missed
+	if ok && predicate(t) && c.removeWaiter(market, waiter) {
+		return t, nil
+	}
+	select {
+	case t := <-waiter.c:
+		return t, nil
+	case <-ctx.Done():
+		if c.removeWaiter(market, waiter) {
+			return Ticker{}, ctx.Err()
+		}
+		// the ticker matched while we were giving up
+		return <-waiter.c, nil
+	}
+}
+
+// removeWaiter unregisters waiter and reports whether it was still registered,
+// whoever removes it owns the delivery.
+func (c *TickerCache) removeWaiter(market string, waiter *tickerWaiter) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.waiters[market][waiter]; !ok {
+		return false
+	}
+	delete(c.waiters[market], waiter)
+	return true
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.values[w.next] = d
+	w.next++
+	if w.next == len(w.values) {
+		w.next = 0
+		w.full = true
+	}
+}
+
+func (w *latencyWindow) snapshot() []time.Duration {
+	n := w.next
+	if w.full {
+		n = len(w.values)
+	}
+	res := make([]time.Duration, n)
+	copy(res, w.values[:n])
+	return res
+}
+
+func latencyStats(values []time.Duration) LatencyStats {
+	if len(values) == 0 {
+		return LatencyStats{}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	var sum time.Duration
+	for _, v := range values {
+		sum += v
+	}
+	quantile := func(q float64) time.Duration {
+		return values[int(q*float64(len(values)-1))]
+	}
+	return LatencyStats{
+		Count: len(values),
+		Min:   values[0],
+		Max:   values[len(values)-1],
+		Mean:  sum / time.Duration(len(values)),
+		P50:   quantile(0.5),
+		P90:   quantile(0.9),
+		P99:   quantile(0.99),
+	}
+}
@@ -0,0 +1,41 @@
+package ftxapi
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func testTicker(c *TickerCache, market string, bid, ask float64) {
+	c.Handle(WsReponse{Ticker: &WsTickerEvent{
+		baseWsEvent: baseWsEvent{Channel: WsChannelTicker, Market: market},
+		Data:        WsTicker{Bid: &bid, Ask: &ask, Time: float64(time.Now().UnixNano()) / 1e9},
+	}})
+}
+
+func TestTickerCachePredicateMayCallGet(t *testing.T) {
+	c := NewTickerCache()
+	testTicker(c, "BTC-PERP", 99, 100)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the predicate compares against another market of the same cache
+	done := make(chan Ticker, 1)
+	go func() {
+		t, _ := c.WaitForPrice(ctx, "ETH-PERP", func(eth Ticker) bool {
+			btc, ok := c.Get("BTC-PERP")
+			return ok && eth.Bid*10 > btc.Bid
+		})
+		done <- t
+	}()
+	testTicker(c, "ETH-PERP", 9, 10)
+	testTicker(c, "ETH-PERP", 11, 12)
+	select {
+	case got := <-done:
+		if got.Bid != 11 {
+			t.Fatalf("WaitForPrice returned bid %v, want 11", got.Bid)
+		}
+	case <-ctx.Done():
+		t.Fatal("WaitForPrice with a predicate calling Get deadlocked")
+	}
+}
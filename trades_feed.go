@@ -0,0 +1,308 @@
+package ftxapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	tradesFeedSeenWindow    = 1 * time.Minute
+	tradesFeedBackfillLimit = 100
+	tradesFeedMaxPageLimit  = 5000
+	tradesFeedTimeout       = 30 * time.Second
+	tradesFeedRetryDelay    = 1 * time.Second
+	tradesFeedMaxRetryDelay = 1 * time.Minute
+)
+
+type TradesHandler func(market string, trade Trade)
+
+// ErrPageOverflow means more records share one second than the largest page holds, the time
+// paged REST endpoints cannot return the rest of that second.
+var ErrPageOverflow = errors.New("more records in one second than the largest page holds")
+
+// TradesGapError reports a failed backfill, trades of the market since Since are held back
+// until a retry fills the gap. With ErrPageOverflow the backfill is not retried, trades of the
+// overflowing second are missing.
+type TradesGapError struct {
+	Market string
+	Since  time.Time
+	Err    error
+}
+
+func (e *TradesGapError) Error() string {
+	return fmt.Sprintf("trades gap on %s since %s: %s", e.Market, e.Since.Format(time.RFC3339), e.Err)
+}
+
+func (e *TradesGapError) Unwrap() error {
+	return e.Err
+}
+
+// TradesFeed merges the trades channel with GetTradesService so trades printed while
+// the connection was down are not lost. Every (re)connect backfills each market from
+// its last seen trade, trades are de-duplicated by id and emitted in time order.
+// Feed it from the websocket data handler with Handle. A failed backfill is reported to the
+// error handler as a *TradesGapError and retried, live trades are held back until it succeeds.
+type TradesFeed struct {
+	l          *zap.SugaredLogger
+	c          *Client
+	ws         *WebsocketService
+	mu         sync.Mutex
+	emitMu     sync.Mutex
+	handler    TradesHandler
+	errHandler WsErrorHandler
+	markets    map[string]*tradesFeedMarket
+}
+
+type tradesFeedMarket struct {
+	lastTime    time.Time
+	seen        map[int]time.Time
+	backfilling bool
+	buffered    []Trade
+}
+
+func NewTradesFeed(c *Client, ws *WebsocketService, handler TradesHandler, l *zap.SugaredLogger) *TradesFeed {
+	f := &TradesFeed{
+		l:       l,
+		c:       c,
+		ws:      ws,
+		handler: handler,
+		markets: make(map[string]*tradesFeedMarket),
+	}
+	ws.OnStateChange(f.onStateChange)
+	return f
+}
+
+// OnError sets the handler receiving backfill gaps.
+func (f *TradesFeed) OnError(h WsErrorHandler) *TradesFeed {
+	f.mu.Lock()
+	f.errHandler = h
+	f.mu.Unlock()
+	return f
+}
+
+// Subscribe starts the trades subscription of market. A non zero since backfills from that time.
+func (f *TradesFeed) Subscribe(market string, since time.Time) error {
+	f.mu.Lock()
+	m, ok := f.markets[market]
+	if !ok {
+		m = &tradesFeedMarket{lastTime: since, seen: make(map[int]time.Time)}
+		f.markets[market] = m
+	}
+	backfill := !since.IsZero()
+	if backfill {
+		m.backfilling = true
+	}
+	f.mu.Unlock()
+	if err := f.ws.Subscribe(Subscription{Channel: WsChannelTrades, Market: StringPointer(market)}); err != nil {
+		return err
+	}
+	if backfill {
+		go f.backfill(market)
+	}
+	return nil
+}
+
+func (f *TradesFeed) Unsubscribe(market string) error {
+	f.mu.Lock()
+	delete(f.markets, market)
+	f.mu.Unlock()
+	return f.ws.Unsubscribe(Subscription{Channel: WsChannelTrades, Market: StringPointer(market)})
+}
+
+// Handle consumes the trades events of the websocket data handler, other events are ignored.
+func (f *TradesFeed) Handle(res WsReponse) {
+	if res.Trades == nil {
+		return
+	}
+	f.emitMu.Lock()
+	defer f.emitMu.Unlock()
+	var out []Trade
+	f.mu.Lock()
+	m, ok := f.markets[res.Trades.Market]
+	if !ok {
+		f.mu.Unlock()
+		return
+	}
+	for _, t := range res.Trades.Data {
+		trade := Trade{
+			ID:          int(t.ID),
+			Liquidation: t.Liquidation,
+			Price:       t.Price,
+			Side:        string(t.Side),
+			Size:        t.Size,
+			Time:        t.Time,
+		}
+		if m.backfilling {
+			m.buffered = append(m.buffered, trade)
+			continue
+		}
+		f.accept(m, trade, &out)
+	}
+	f.mu.Unlock()
+	f.emit(res.Trades.Market, out)
+}
+
+// accept adds t to out unless it was seen already, must be called with f.mu held.
+func (f *TradesFeed) accept(m *tradesFeedMarket, t Trade, out *[]Trade) {
+	if _, ok := m.seen[t.ID]; ok || t.Time.Before(m.lastTime) {
+		return
+	}
+	m.seen[t.ID] = t.Time
+	if t.Time.After(m.lastTime) {
+		m.lastTime = t.Time
+		for id, tm := range m.seen {
+			if m.lastTime.Sub(tm) > tradesFeedSeenWindow {
+				delete(m.seen, id)
+			}
+		}
+	}
+	*out = append(*out, t)
+}
+
+// emit calls the handler outside f.mu, emitMu keeps the trades of Handle and backfill in order.
+func (f *TradesFeed) emit(market string, trades []Trade) {
+	for _, t := range trades {
+		f.handler(market, t)
+	}
+}
+
+func (f *TradesFeed) onStateChange(state WsConnState) {
+	if state != WsConnStateConnected {
+		return
+	}
+	f.mu.Lock()
+	var markets []string
+	for market, m := range f.markets {
+		if m.lastTime.IsZero() || m.backfilling {
+			continue
+		}
+		m.backfilling = true
+		markets = append(markets, market)
+	}
+	f.mu.Unlock()
+	for _, market := range markets {
+		go f.backfill(market)
+	}
+}
+
+func (f *TradesFeed) backfill(market string) {
+	l := f.l.With("func", "TradesFeed.backfill", "market", market)
+	f.mu.Lock()
+	m, ok := f.markets[market]
+	if !ok {
+		f.mu.Unlock()
+		return
+	}
+	since := m.lastTime
+	f.mu.Unlock()
+
+	var trades []Trade
+	delay := tradesFeedRetryDelay
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), tradesFeedTimeout)
+		var err error
+		trades, err = f.fetchSince(ctx, market, since)
+		cancel()
+		if err == nil {
+			break
+		}
+		if errors.Is(err, ErrPageOverflow) {
+			// a retry gets the same pages, keep what was found and report the gap
+			l.Errorw("trades backfill has a gap", "since", since, "err", err)
+			f.reportGap(&TradesGapError{Market: market, Since: since, Err: err})
+			break
+		}
+		l.Errorw("cannot backfill trades", "since", since, "err", err)
+		f.reportGap(&TradesGapError{Market: market, Since: since, Err: err})
+		time.Sleep(delay)
+		if delay *= 2; delay > tradesFeedMaxRetryDelay {
+			delay = tradesFeedMaxRetryDelay
+		}
+		f.mu.Lock()
+		removed := f.markets[market] != m
+		f.mu.Unlock()
+		if removed {
+			return
+		}
+	}
+
+	f.emitMu.Lock()
+	defer f.emitMu.Unlock()
+	f.mu.Lock()
+	if f.markets[market] != m {
+		f.mu.Unlock()
+		return
+	}
+	trades = append(trades, m.buffered...)
+	sort.SliceStable(trades, func(i, j int) bool {
+		if trades[i].Time.Equal(trades[j].Time) {
+			return trades[i].ID < trades[j].ID
+		}
+		return trades[i].Time.Before(trades[j].Time)
+	})
+	var out []Trade
+	for _, t := range trades {
+		f.accept(m, t, &out)
+	}
+	m.buffered = nil
+	m.backfilling = false
+	f.mu.Unlock()
+	l.Debugw("trades backfilled", "since", since, "count", len(trades))
+	f.emit(market, out)
+}
+
+func (f *TradesFeed) reportGap(err *TradesGapError) {
+	f.mu.Lock()
+	errHandler := f.errHandler
+	f.mu.Unlock()
+	if errHandler != nil {
+		errHandler(err)
+	}
+}
+
+// fetchSince pages backwards through GetTradesService until it reaches since. Pages are bounded
+// by whole seconds, a full page within one second is fetched again with a larger limit. When
+// the largest page is full too the rest of that second is skipped and ErrPageOverflow is
+// returned together with all trades found.
+func (f *TradesFeed) fetchSince(ctx context.Context, market string, since time.Time) ([]Trade, error) {
+	var res []Trade
+	var gap error
+	end := time.Now()
+	limit := int64(tradesFeedBackfillLimit)
+	for {
+		page, err := f.c.NewGetTradesService().MarketName(market).
+			StartTime(since.Unix()).EndTime(end.Unix()).Limit(limit).Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, page...)
+		if int64(len(page)) < limit {
+			return res, gap
+		}
+		oldest := page[0].Time
+		for _, t := range page {
+			if t.Time.Before(oldest) {
+				oldest = t.Time
+			}
+		}
+		if !oldest.After(since) {
+			return res, gap
+		}
+		switch {
+		case oldest.Unix() < end.Unix():
+			end = oldest
+		case limit < tradesFeedMaxPageLimit:
+			limit = int64(math.Min(float64(limit*10), tradesFeedMaxPageLimit))
+		default:
+			gap = ErrPageOverflow
+			end = time.Unix(end.Unix()-1, 0)
+		}
+	}
+}
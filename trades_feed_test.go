@@ -0,0 +1,117 @@
+package ftxapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// serveTrades answers GetTradesService from trades like the exchange: whole second bounds,
+// newest first, at most limit trades.
+func serveTrades(srv *testRestServer, market string, trades []Trade) {
+	srv.handle("GET /markets/"+market+"/trades", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		start, _ := strconv.ParseInt(q.Get("start_time"), 10, 64)
+		end, _ := strconv.ParseInt(q.Get("end_time"), 10, 64)
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		var page []Trade
+		for _, t := range trades {
+			if sec := t.Time.Unix(); sec >= start && sec <= end {
+				page = append(page, t)
+			}
+		}
+		sort.Slice(page, func(i, j int) bool { return page[i].ID > page[j].ID })
+		if len(page) > limit {
+			page = page[:limit]
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": page})
+	})
+}
+
+func testTrades(from time.Time, n, perSecond, firstID int) []Trade {
+	trades := make([]Trade, n)
+	for i := range trades {
+		trades[i] = Trade{ID: firstID + i, Price: 100, Size: 1, Side: "buy",
+			Time: from.Add(time.Duration(i/perSecond) * time.Second).Add(time.Duration(i%perSecond) * time.Microsecond)}
+	}
+	return trades
+}
+
+func newTestTradesFeed(c *Client, handler TradesHandler) *TradesFeed {
+	ws := NewWebsocketService("", "", "", zap.NewNop().Sugar())
+	return NewTradesFeed(c, ws, handler, zap.NewNop().Sugar())
+}
+
+func TestTradesFeedPagesThroughBusySecond(t *testing.T) {
+	c, srv := newTestClient(t)
+	since := time.Now().Add(-time.Minute).Truncate(time.Second)
+	// 10 quiet seconds, then 250 trades printed in one second
+	trades := append(testTrades(since, 10, 1, 1), testTrades(since.Add(20*time.Second), 250, 250, 100)...)
+	serveTrades(srv, "BTC-PERP", trades)
+
+	f := newTestTradesFeed(c, nil)
+	got, err := f.fetchSince(context.Background(), "BTC-PERP", since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := tradeIDs(got); len(ids) != 260 {
+		t.Fatalf("fetched %d distinct trades, want 260", len(ids))
+	}
+}
+
+func TestTradesFeedReportsOverflowingSecond(t *testing.T) {
+	c, srv := newTestClient(t)
+	since := time.Now().Add(-time.Minute).Truncate(time.Second)
+	trades := append(testTrades(since, 10, 1, 1), testTrades(since.Add(20*time.Second), tradesFeedMaxPageLimit+1, tradesFeedMaxPageLimit+1, 100)...)
+	serveTrades(srv, "BTC-PERP", trades)
+
+	f := newTestTradesFeed(c, nil)
+	got, err := f.fetchSince(context.Background(), "BTC-PERP", since)
+	if !errors.Is(err, ErrPageOverflow) {
+		t.Fatalf("err = %v, want ErrPageOverflow", err)
+	}
+	// the seconds before the busy one are still fetched
+	ids := tradeIDs(got)
+	for id := 1; id <= 10; id++ {
+		if _, ok := ids[id]; !ok {
+			t.Fatalf("trade %d before the busy second is missing", id)
+		}
+	}
+}
+
+func TestTradesFeedHandlerMayCallFeed(t *testing.T) {
+	c, _ := newTestClient(t)
+	var f *TradesFeed
+	done := make(chan struct{})
+	f = newTestTradesFeed(c, func(market string, trade Trade) {
+		_ = f.Subscribe("ETH-PERP", time.Time{})
+		close(done)
+	})
+	if err := f.Subscribe("BTC-PERP", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	go f.Handle(WsReponse{Trades: &WsTradesEvent{
+		baseWsEvent: baseWsEvent{Market: "BTC-PERP"},
+		Data:        []WsTrade{{ID: 1, Price: 100, Size: 1, Side: SideBuy, Time: time.Now()}},
+	}})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler calling Subscribe deadlocked")
+	}
+}
+
+func tradeIDs(trades []Trade) map[int]struct{} {
+	ids := make(map[int]struct{}, len(trades))
+	for _, t := range trades {
+		ids[t.ID] = struct{}{}
+	}
+	return ids
+}
@@ -18,6 +18,9 @@ type BalancesResponse struct {
 }
 
 func (s *GetBalancesService) Do(ctx context.Context) ([]Balance, error) {
+	if s.c.paper != nil {
+		return s.c.paper.listBalances(s.c), nil
+	}
 	r := newRequest(http.MethodGet, endPointWithFormat("/wallet/balances"), true)
 	byteData, err := s.c.callAPI(ctx, r)
 	if err != nil {
@@ -33,6 +33,14 @@ type WithdrawResponse struct {
 }
 
 func (s *WithdrawService) Do(ctx context.Context) (*Withdraw, error) {
+	if s.c.risk != nil {
+		if err := s.c.risk.CheckWithdraw(ctx, s.c, s.params); err != nil {
+			return nil, err
+		}
+	}
+	if s.c.paper != nil {
+		return s.c.paper.withdraw(s.c, s.params)
+	}
 	r := newRequest(http.MethodPost, endPointWithFormat("/wallet/withdrawals"), true)
 	body, err := json.Marshal(s.params)
 	if err != nil {
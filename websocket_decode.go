@@ -0,0 +1,164 @@
+package ftxapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// wsEnvelope holds the top level fields of a websocket frame, data is kept raw
+// and decoded straight into the event type of the channel.
+type wsEnvelope struct {
+	Type     string          `json:"type"`
+	Channel  WsChannel       `json:"channel"`
+	Market   string          `json:"market"`
+	Code     int             `json:"code"`
+	Msg      string          `json:"msg"`
+	Grouping float64         `json:"grouping"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// wsDecoder is owned by one read loop, its envelope and data buffer are reused for every frame.
+type wsDecoder struct {
+	env wsEnvelope
+}
+
+func (d *wsDecoder) decodeEnvelope(msg []byte) (*wsEnvelope, error) {
+	data := d.env.Data[:0]
+	d.env = wsEnvelope{Data: data}
+	if err := json.Unmarshal(msg, &d.env); err != nil {
+		return nil, err
+	}
+	return &d.env, nil
+}
+
+// decodeData returns false for channels this package doesn't know. Every frame gets its own
+// event, handlers and the event queue may keep it after the next frame is read.
+func (d *wsDecoder) decodeData(env *wsEnvelope) (WsReponse, bool, error) {
+	base := baseWsEvent{
+		Type:    WsDataAction(env.Type),
+		Channel: env.Channel,
+		Market:  env.Market,
+	}
+	switch env.Channel {
+	case WsChannelTicker:
+		event := &WsTickerEvent{baseWsEvent: base}
+		if err := json.Unmarshal(env.Data, &event.Data); err != nil {
+			return WsReponse{}, false, err
+		}
+		return WsReponse{Ticker: event}, true, nil
+	case WsChannelMarkets:
+		event := &WsMarketsEvent{baseWsEvent: base}
+		if err := json.Unmarshal(env.Data, &event.Data); err != nil {
+			return WsReponse{}, false, err
+		}
+		return WsReponse{Markets: event}, true, nil
+	case WsChannelTrades:
+		event := &WsTradesEvent{baseWsEvent: base}
+		if err := json.Unmarshal(env.Data, &event.Data); err != nil {
+			return WsReponse{}, false, err
+		}
+		return WsReponse{Trades: event}, true, nil
+	case WsChannelOrderBook:
+		event := &WsOrderBookEvent{baseWsEvent: base}
+		presizeLevels(env.Data, &event.Data.Bids, &event.Data.Asks)
+		if err := json.Unmarshal(env.Data, &event.Data); err != nil {
+			return WsReponse{}, false, err
+		}
+		return WsReponse{OrderBookEvent: event}, true, nil
+	case WsChannelOrderbookGrouped:
+		event := &WsGroupedOrderBookEvent{baseWsEvent: base, Grouping: env.Grouping}
+		presizeLevels(env.Data, &event.Data.Bids, &event.Data.Asks)
+		if err := json.Unmarshal(env.Data, &event.Data); err != nil {
+			return WsReponse{}, false, err
+		}
+		return WsReponse{GroupedOrderBookEvent: event}, true, nil
+	case WsChannelFills:
+		event := &WsFillsEvent{baseWsEvent: base}
+		if err := json.Unmarshal(env.Data, &event.Data); err != nil {
+			return WsReponse{}, false, err
+		}
+		return WsReponse{Fills: event}, true, nil
+	case WsChannelOrders:
+		event := &WsOrdersEvent{baseWsEvent: base}
+		if err := json.Unmarshal(env.Data, &event.Data); err != nil {
+			return WsReponse{}, false, err
+		}
+		return WsReponse{Orders: event}, true, nil
+	case WsChannelFTXPay:
+		event := &WsFTXPayEvent{baseWsEvent: base}
+		if err := json.Unmarshal(env.Data, &event.Data); err != nil {
+			return WsReponse{}, false, err
+		}
+		return WsReponse{FTXPay: event}, true, nil
+	}
+	return WsReponse{}, false, nil
+}
+
+// presizeLevels gives bids and asks one backing array large enough for all levels of the
+// book data, so decoding doesn't grow them level by level.
+func presizeLevels(data []byte, bids, asks *[]Feed) {
+	// every level is an array, plus the bids and asks arrays themselves
+	n := bytes.Count(data, []byte{'['}) - 2
+	if n <= 0 {
+		return
+	}
+	levels := make([]Feed, 2*n)
+	*bids = levels[:0:n]
+	*asks = levels[n : n : 2*n]
+}
+
+// parseFeed reads a [price, size] pair without going through reflection.
+func parseFeed(buf []byte, f *Feed) error {
+	i := skipSpace(buf, 0)
+	if i >= len(buf) || buf[i] != '[' {
+		return fmt.Errorf("feed: expected array, got %q", buf)
+	}
+	var err error
+	var end int
+	if f.Price, end, err = parseNumber(buf, i+1); err != nil {
+		return err
+	}
+	i = skipSpace(buf, end)
+	if i >= len(buf) || buf[i] != ',' {
+		return fmt.Errorf("wrong number of fields: 1 != 2")
+	}
+	if f.Size, end, err = parseNumber(buf, i+1); err != nil {
+		return err
+	}
+	i = skipSpace(buf, end)
+	if i >= len(buf) || buf[i] != ']' {
+		return fmt.Errorf("wrong number of fields: >2 != 2")
+	}
+	return nil
+}
+
+func skipSpace(buf []byte, i int) int {
+	for i < len(buf) && (buf[i] == ' ' || buf[i] == '\t' || buf[i] == '\n' || buf[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+func parseNumber(buf []byte, i int) (float64, int, error) {
+	i = skipSpace(buf, i)
+	start := i
+	for i < len(buf) {
+		c := buf[i]
+		if (c >= '0' && c <= '9') || c == '-' || c == '+' || c == '.' || c == 'e' || c == 'E' {
+			i++
+			continue
+		}
+		break
+	}
+	if start == i {
+		return 0, i, fmt.Errorf("feed: expected number at %d in %q", start, buf)
+	}
+	v, err := parseFloat(buf[start:i])
+	return v, i, err
+}
+
+func parseFloat(b []byte) (float64, error) {
+	return strconv.ParseFloat(string(b), 64)
+}
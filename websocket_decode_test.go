@@ -0,0 +1,197 @@
+package ftxapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testWsFrames is a BTC-PERP session of the public channels in the wire format of the exchange:
+// one 100 level orderbook partial followed by book updates, trades and tickers.
+const testWsFrames = "testdata/ws_frames_btc_perp.txt"
+
+func loadTestFrames(tb testing.TB) [][]byte {
+	tb.Helper()
+	f, err := os.Open(testWsFrames)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	var frames [][]byte
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		frames = append(frames, append([]byte(nil), sc.Bytes()...))
+	}
+	if err := sc.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return frames
+}
+
+// legacyFeed decodes a level like Feed did before the single pass decoder.
+type legacyFeed Feed
+
+func (f *legacyFeed) UnmarshalJSON(buf []byte) error {
+	tmp := []interface{}{&f.Price, &f.Size}
+	wantLen := len(tmp)
+	if err := json.Unmarshal(buf, &tmp); err != nil {
+		return err
+	}
+	if g, e := len(tmp), wantLen; g != e {
+		return fmt.Errorf("wrong number of fields: %d != %d", g, e)
+	}
+	return nil
+}
+
+type legacyOrderBookEvent struct {
+	baseWsEvent
+	Data struct {
+		Action   WsDataAction `json:"action"`
+		Asks     []legacyFeed `json:"asks"`
+		Bids     []legacyFeed `json:"bids"`
+		Checksum int64        `json:"checksum"`
+		Time     float64      `json:"time"`
+	} `json:"data"`
+}
+
+// legacyDecode is the old read path: the frame is parsed into a generic tree to find its type
+// and channel, as go-simplejson did, then parsed again into the event.
+func legacyDecode(msg []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	var tree map[string]interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	channel, _ := tree["channel"].(string)
+	switch WsChannel(channel) {
+	case WsChannelOrderBook:
+		var event legacyOrderBookEvent
+		err := json.Unmarshal(msg, &event)
+		return &event, err
+	case WsChannelTrades:
+		var event WsTradesEvent
+		err := json.Unmarshal(msg, &event)
+		return &event, err
+	case WsChannelTicker:
+		var event WsTickerEvent
+		err := json.Unmarshal(msg, &event)
+		return &event, err
+	}
+	return nil, nil
+}
+
+func decodeFrame(dec *wsDecoder, msg []byte) (WsReponse, error) {
+	env, err := dec.decodeEnvelope(msg)
+	if err != nil {
+		return WsReponse{}, err
+	}
+	res, _, err := dec.decodeData(env)
+	return res, err
+}
+
+func legacyLevels(levels []legacyFeed) []Feed {
+	res := make([]Feed, len(levels))
+	for i, l := range levels {
+		res[i] = Feed(l)
+	}
+	return res
+}
+
+func TestDecodeMatchesLegacy(t *testing.T) {
+	var dec wsDecoder
+	for n, frame := range loadTestFrames(t) {
+		res, err := decodeFrame(&dec, frame)
+		if err != nil {
+			t.Fatalf("frame %d: %v", n, err)
+		}
+		old, err := legacyDecode(frame)
+		if err != nil {
+			t.Fatalf("frame %d: legacy: %v", n, err)
+		}
+		var got, want interface{}
+		switch {
+		case res.OrderBookEvent != nil:
+			legacy := old.(*legacyOrderBookEvent)
+			got = *res.OrderBookEvent
+			want = WsOrderBookEvent{baseWsEvent: legacy.baseWsEvent, Data: WsOrderBook{
+				Action:   legacy.Data.Action,
+				Asks:     legacyLevels(legacy.Data.Asks),
+				Bids:     legacyLevels(legacy.Data.Bids),
+				Checksum: legacy.Data.Checksum,
+				Time:     legacy.Data.Time,
+			}}
+		case res.Trades != nil:
+			got, want = res.Trades, old
+		case res.Ticker != nil:
+			got, want = res.Ticker, old
+		default:
+			t.Fatalf("frame %d: nothing decoded", n)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("frame %d: decoded %+v, legacy %+v", n, got, want)
+		}
+	}
+}
+
+// benchmarkDecode decodes the session frames matching channel and typ, one frame per op.
+func benchmarkDecode(b *testing.B, channel WsChannel, typ string) {
+	var frames [][]byte
+	for _, frame := range loadTestFrames(b) {
+		var env wsEnvelope
+		if err := json.Unmarshal(frame, &env); err != nil {
+			b.Fatal(err)
+		}
+		if (channel == "" || env.Channel == channel) && (typ == "" || env.Type == typ) {
+			frames = append(frames, frame)
+		}
+	}
+	var size int64
+	for _, frame := range frames {
+		size += int64(len(frame))
+	}
+	b.Run("single_pass", func(b *testing.B) {
+		var dec wsDecoder
+		b.ReportAllocs()
+		b.SetBytes(size / int64(len(frames)))
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeFrame(&dec, frames[i%len(frames)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("legacy", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(size / int64(len(frames)))
+		for i := 0; i < b.N; i++ {
+			if _, err := legacyDecode(frames[i%len(frames)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkDecodeSession(b *testing.B) {
+	benchmarkDecode(b, "", "")
+}
+
+func BenchmarkDecodeOrderBookPartial(b *testing.B) {
+	benchmarkDecode(b, WsChannelOrderBook, "partial")
+}
+
+func BenchmarkDecodeOrderBookUpdate(b *testing.B) {
+	benchmarkDecode(b, WsChannelOrderBook, "update")
+}
+
+func BenchmarkDecodeTrades(b *testing.B) {
+	benchmarkDecode(b, WsChannelTrades, "")
+}
+
+func BenchmarkDecodeTicker(b *testing.B) {
+	benchmarkDecode(b, WsChannelTicker, "")
+}
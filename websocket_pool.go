@@ -0,0 +1,379 @@
+package ftxapi
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultWsPoolConnections       = 1
+	DefaultWsPoolSubsPerConnection = 50
+	wsPoolReconnectDelay           = 1 * time.Second
+	wsPoolMaxReconnectDelay        = 30 * time.Second
+)
+
+var ErrWsPoolClosed = errors.New("websocket pool closed")
+
+// WebsocketPool spreads subscriptions over several WebsocketService connections
+// and delivers data from all of them to one handler.
+type WebsocketPool struct {
+	l                 *zap.SugaredLogger
+	mu                sync.Mutex
+	connectMu         sync.Mutex
+	handlerMu         sync.Mutex
+	apiKey            string
+	apiSecret         string
+	wsEndpoint        string
+	subAccount        *string
+	connections       int
+	subsPerConnection int
+	members           []*wsPoolMember
+	mapSubscriptions  map[Subscription]*wsPoolMember
+	dataHandler       WsDataHandler
+	errHandler        WsErrorHandler
+	connected         bool
+	closed            bool
+}
+
+type wsPoolMember struct {
+	ws   *WebsocketService
+	subs map[Subscription]struct{}
+	dead bool
+}
+
+func NewWebsocketPool(apiKey, apiSecret, wsEndpoint string, l *zap.SugaredLogger) *WebsocketPool {
+	return &WebsocketPool{
+		l:                 l,
+		apiKey:            apiKey,
+		apiSecret:         apiSecret,
+		wsEndpoint:        wsEndpoint,
+		connections:       DefaultWsPoolConnections,
+		subsPerConnection: DefaultWsPoolSubsPerConnection,
+		mapSubscriptions:  make(map[Subscription]*wsPoolMember),
+	}
+}
+
+// Connections sets how many connections are opened by Connect. More are opened when all are full.
+func (p *WebsocketPool) Connections(n int) *WebsocketPool {
+	if n > 0 {
+		p.connections = n
+	}
+	return p
+}
+
+// SubscriptionsPerConnection limits how many subscriptions share one connection.
+func (p *WebsocketPool) SubscriptionsPerConnection(n int) *WebsocketPool {
+	if n > 0 {
+		p.subsPerConnection = n
+	}
+	return p
+}
+
+func (p *WebsocketPool) SubAccount(sa string) *WebsocketPool {
+	p.subAccount = &sa
+	return p
+}
+
+// Connect opens the connections. Events of all connections are merged into one stream, the
+// handlers are called one at a time and events of one connection arrive in order. When a
+// connection fails to open or a subscription cannot be sent, the connections opened by this
+// call are closed and the pool can be connected again.
+func (p *WebsocketPool) Connect(dataHandler WsDataHandler, errHandler WsErrorHandler) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrWsPoolClosed
+	}
+	p.dataHandler = dataHandler
+	p.errHandler = errHandler
+	p.connected = true
+	existing := make(map[*wsPoolMember]struct{}, len(p.members))
+	for _, m := range p.members {
+		existing[m] = struct{}{}
+	}
+	p.mu.Unlock()
+
+	for i := 0; i < p.connections; i++ {
+		if _, err := p.openMember(); err != nil {
+			p.dropMembers(p.membersSince(existing))
+			return err
+		}
+	}
+	p.mu.Lock()
+	var pending []Subscription
+	for sub, m := range p.mapSubscriptions {
+		if m == nil {
+			pending = append(pending, sub)
+		}
+	}
+	p.mu.Unlock()
+	for _, sub := range pending {
+		if err := p.assign(sub); err != nil {
+			p.dropMembers(p.membersSince(existing))
+			return err
+		}
+	}
+	return nil
+}
+
+// membersSince returns the members that are not in existing.
+func (p *WebsocketPool) membersSince(existing map[*wsPoolMember]struct{}) []*wsPoolMember {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var res []*wsPoolMember
+	for _, m := range p.members {
+		if _, ok := existing[m]; !ok {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+func (p *WebsocketPool) handleData(res WsReponse) {
+	p.mu.Lock()
+	h := p.dataHandler
+	p.mu.Unlock()
+	p.handlerMu.Lock()
+	defer p.handlerMu.Unlock()
+	if h != nil {
+		h(res)
+	}
+}
+
+func (p *WebsocketPool) handleError(err error) {
+	p.mu.Lock()
+	h := p.errHandler
+	p.mu.Unlock()
+	p.handlerMu.Lock()
+	defer p.handlerMu.Unlock()
+	if h != nil {
+		h(err)
+	}
+}
+
+// Subscribe sends sub on the least loaded connection that still has room, opening a new one if needed.
+func (p *WebsocketPool) Subscribe(sub Subscription) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrWsPoolClosed
+	}
+	if _, ok := p.mapSubscriptions[sub]; ok {
+		p.mu.Unlock()
+		return nil
+	}
+	p.mapSubscriptions[sub] = nil
+	connected := p.connected
+	p.mu.Unlock()
+	if !connected {
+		return nil
+	}
+	return p.assign(sub)
+}
+
+func (p *WebsocketPool) Unsubscribe(sub Subscription) error {
+	p.mu.Lock()
+	m, ok := p.mapSubscriptions[sub]
+	delete(p.mapSubscriptions, sub)
+	if m != nil {
+		delete(m.subs, sub)
+	}
+	p.mu.Unlock()
+	if !ok || m == nil {
+		return nil
+	}
+	return m.ws.Unsubscribe(sub)
+}
+
+// Subscriptions returns the number of subscriptions held by each open connection.
+func (p *WebsocketPool) Subscriptions() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make([]int, 0, len(p.members))
+	for _, m := range p.members {
+		res = append(res, len(m.subs))
+	}
+	return res
+}
+
+func (p *WebsocketPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	members := p.members
+	p.members = nil
+	p.mu.Unlock()
+	for _, m := range members {
+		m.ws.Close()
+	}
+}
+
+// dropMembers closes connections without replacing them, their subscriptions wait for the next Connect.
+func (p *WebsocketPool) dropMembers(members []*wsPoolMember) {
+	p.mu.Lock()
+	for _, dropped := range members {
+		dropped.dead = true
+		for i, m := range p.members {
+			if m == dropped {
+				p.members = append(p.members[:i], p.members[i+1:]...)
+				break
+			}
+		}
+		for sub := range dropped.subs {
+			if p.mapSubscriptions[sub] == dropped {
+				p.mapSubscriptions[sub] = nil
+			}
+		}
+	}
+	if len(p.members) == 0 {
+		p.connected = false
+	}
+	p.mu.Unlock()
+	for _, m := range members {
+		m.ws.Close()
+	}
+}
+
+func (p *WebsocketPool) assign(sub Subscription) error {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return ErrWsPoolClosed
+		}
+		if m, ok := p.mapSubscriptions[sub]; !ok || m != nil {
+			// unsubscribed or already assigned meanwhile
+			p.mu.Unlock()
+			return nil
+		}
+		m := p.leastLoaded()
+		if m != nil {
+			m.subs[sub] = struct{}{}
+			p.mapSubscriptions[sub] = m
+			p.mu.Unlock()
+			return m.ws.Subscribe(sub)
+		}
+		p.mu.Unlock()
+		if _, err := p.openMember(); err != nil {
+			return err
+		}
+	}
+}
+
+// leastLoaded must be called with p.mu held.
+func (p *WebsocketPool) leastLoaded() *wsPoolMember {
+	var best *wsPoolMember
+	for _, m := range p.members {
+		if m.dead || len(m.subs) >= p.subsPerConnection {
+			continue
+		}
+		if best == nil || len(m.subs) < len(best.subs) {
+			best = m
+		}
+	}
+	return best
+}
+
+func (p *WebsocketPool) openMember() (*wsPoolMember, error) {
+	p.connectMu.Lock()
+	defer p.connectMu.Unlock()
+	p.mu.Lock()
+	// another goroutine may have opened a connection with room while we waited
+	if m := p.leastLoaded(); m != nil && len(p.members) >= p.connections {
+		p.mu.Unlock()
+		return m, nil
+	}
+	p.mu.Unlock()
+
+	ws := NewWebsocketService(p.apiKey, p.apiSecret, p.wsEndpoint, p.l)
+	if p.subAccount != nil {
+		ws.SubAccount(*p.subAccount)
+	}
+	m := &wsPoolMember{ws: ws, subs: make(map[Subscription]struct{})}
+	ws.OnStateChange(func(state WsConnState) {
+		if state == WsConnStateDisconnected {
+			go p.rebalance(m)
+		}
+	})
+	if err := ws.Connect(p.handleData, p.handleError); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		ws.Close()
+		return nil, ErrWsPoolClosed
+	}
+	if m.dead {
+		// dropped before it was added, rebalance already ran for it
+		p.mu.Unlock()
+		return nil, ErrWsConnectionClosed
+	}
+	p.members = append(p.members, m)
+	p.mu.Unlock()
+	return m, nil
+}
+
+// rebalance moves the subscriptions of a dropped connection to the remaining ones
+// and opens replacements until the pool is back to its configured size.
+func (p *WebsocketPool) rebalance(dropped *wsPoolMember) {
+	l := p.l.With("func", "WebsocketPool.rebalance")
+	p.mu.Lock()
+	if p.closed || dropped.dead {
+		p.mu.Unlock()
+		return
+	}
+	dropped.dead = true
+	for i, m := range p.members {
+		if m == dropped {
+			p.members = append(p.members[:i], p.members[i+1:]...)
+			break
+		}
+	}
+	orphans := make([]Subscription, 0, len(dropped.subs))
+	for sub := range dropped.subs {
+		if p.mapSubscriptions[sub] == dropped {
+			p.mapSubscriptions[sub] = nil
+			orphans = append(orphans, sub)
+		}
+	}
+	p.mu.Unlock()
+	l.Infow("connection dropped, rebalancing", "subscriptions", len(orphans))
+
+	delay := wsPoolReconnectDelay
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		missing := p.connections - len(p.members)
+		p.mu.Unlock()
+		if missing <= 0 {
+			break
+		}
+		if _, err := p.openMember(); err != nil {
+			l.Errorw("cannot open replacement connection", "err", err)
+			time.Sleep(delay)
+			if delay *= 2; delay > wsPoolMaxReconnectDelay {
+				delay = wsPoolMaxReconnectDelay
+			}
+		}
+	}
+	for _, sub := range orphans {
+		for {
+			err := p.assign(sub)
+			if err == nil || errors.Is(err, ErrWsPoolClosed) {
+				break
+			}
+			l.Errorw("cannot move subscription", "channel", sub.Channel, "market", sub.Market, "err", err)
+			time.Sleep(delay)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package ftxapi
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func newTestPool(srv *testWsServer) *WebsocketPool {
+	return NewWebsocketPool("", "", srv.endpoint(), zap.NewNop().Sugar())
+}
+
+func testPoolSubscription(i int) Subscription {
+	market := fmt.Sprintf("M%d-PERP", i)
+	return Subscription{Channel: WsChannelTicker, Market: &market}
+}
+
+// waitFor polls cond for up to five seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func sum(counts []int) int {
+	var n int
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
+
+func TestWebsocketPoolSpreadsSubscriptions(t *testing.T) {
+	srv := newTestWsServer(t)
+	p := newTestPool(srv).Connections(2).SubscriptionsPerConnection(3)
+	if err := p.Connect(func(WsReponse) {}, func(error) {}); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	for i := 0; i < 7; i++ {
+		if err := p.Subscribe(testPoolSubscription(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts := p.Subscriptions()
+	if len(counts) != 3 || sum(counts) != 7 {
+		t.Fatalf("subscriptions per connection = %v, want 7 over 3 connections", counts)
+	}
+	for _, c := range counts {
+		if c > 3 {
+			t.Fatalf("subscriptions per connection = %v, want at most 3", counts)
+		}
+	}
+	waitFor(t, "subscribes", func() bool { return srv.count("subscribe") == 7 })
+}
+
+func TestWebsocketPoolSerializesHandlers(t *testing.T) {
+	srv := newTestWsServer(t)
+	p := newTestPool(srv).Connections(3)
+	var inside, overlaps, received int32
+	err := p.Connect(func(WsReponse) {
+		if atomic.AddInt32(&inside, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		time.Sleep(50 * time.Microsecond)
+		atomic.AddInt32(&inside, -1)
+		atomic.AddInt32(&received, 1)
+	}, func(error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	conns := srv.live()
+	if len(conns) != 3 {
+		t.Fatalf("server has %d connections, want 3", len(conns))
+	}
+	const n = 100
+	for _, c := range conns {
+		go func(c *testWsConn) {
+			for i := 0; i < n; i++ {
+				_ = c.writeJSON(map[string]interface{}{"channel": "ticker", "market": "BTC-PERP", "type": "update",
+					"data": map[string]float64{"bid": 1, "ask": 2, "last": 1, "time": float64(i)}})
+			}
+		}(c)
+	}
+	waitFor(t, "events", func() bool { return atomic.LoadInt32(&received) == 3*n })
+	if overlaps != 0 {
+		t.Fatalf("handler ran concurrently %d times", overlaps)
+	}
+}
+
+func TestWebsocketPoolRebalancesDroppedConnection(t *testing.T) {
+	srv := newTestWsServer(t)
+	p := newTestPool(srv).Connections(2).SubscriptionsPerConnection(10)
+	if err := p.Connect(func(WsReponse) {}, func(error) {}); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	for i := 0; i < 6; i++ {
+		if err := p.Subscribe(testPoolSubscription(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "subscribes", func() bool { return srv.count("subscribe") == 6 })
+	srv.live()[0].conn.Close()
+
+	// the orphans are sent again on the remaining or the replacement connection
+	waitFor(t, "rebalance", func() bool {
+		counts := p.Subscriptions()
+		return len(counts) == 2 && sum(counts) == 6 && srv.count("subscribe") == 9 && len(srv.live()) == 2
+	})
+}
+
+func TestWebsocketPoolConnectFailureClosesConnections(t *testing.T) {
+	srv := newTestWsServer(t)
+	srv.refuseAfter(1)
+	p := newTestPool(srv).Connections(1).SubscriptionsPerConnection(1)
+	for i := 0; i < 2; i++ {
+		if err := p.Subscribe(testPoolSubscription(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the second subscription needs a second connection, which the server refuses
+	if err := p.Connect(func(WsReponse) {}, func(error) {}); err == nil {
+		t.Fatal("Connect succeeded, want an error")
+	}
+	if counts := p.Subscriptions(); len(counts) != 0 {
+		t.Fatalf("subscriptions per connection = %v, want no connections", counts)
+	}
+	waitFor(t, "connections to close", func() bool { return len(srv.live()) == 0 })
+
+	srv.refuseAfter(0)
+	if err := p.Connect(func(WsReponse) {}, func(error) {}); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if counts := p.Subscriptions(); sum(counts) != 2 {
+		t.Fatalf("subscriptions per connection = %v after reconnecting, want 2", counts)
+	}
+}
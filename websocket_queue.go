@@ -0,0 +1,286 @@
+package ftxapi
+
+import (
+	"sort"
+	"sync"
+)
+
+type WsQueuePolicy string
+
+const (
+	// WsQueuePolicyBlock waits for room in the queue, events are never dropped
+	WsQueuePolicyBlock WsQueuePolicy = "block"
+	// WsQueuePolicyConflate keeps only the latest event per market while it waits in the queue
+	WsQueuePolicyConflate WsQueuePolicy = "conflate"
+	// WsQueuePolicyDropOldest drops the oldest queued event of the same channel to make room,
+	// the incoming event when none is queued
+	WsQueuePolicyDropOldest WsQueuePolicy = "drop_oldest"
+	// WsQueuePolicyDropNewest drops the incoming event when the queue is full
+	WsQueuePolicyDropNewest WsQueuePolicy = "drop_newest"
+)
+
+const DefaultWsQueueSize = 1024
+
+// DefaultWsQueuePolicies conflates tickers and grouped books, everything else is lossless.
+var DefaultWsQueuePolicies = map[WsChannel]WsQueuePolicy{
+	WsChannelTicker:           WsQueuePolicyConflate,
+	WsChannelOrderbookGrouped: WsQueuePolicyConflate,
+	WsChannelMarkets:          WsQueuePolicyBlock,
+	WsChannelTrades:           WsQueuePolicyBlock,
+	WsChannelOrderBook:        WsQueuePolicyBlock,
+	WsChannelFills:            WsQueuePolicyBlock,
+	WsChannelOrders:           WsQueuePolicyBlock,
+	WsChannelFTXPay:           WsQueuePolicyBlock,
+}
+
+type WsQueueStats struct {
+	Depth    int
+	MaxDepth int
+	Channels map[WsChannel]WsQueueChannelStats
+}
+
+type WsQueueChannelStats struct {
+	Enqueued  uint64
+	Delivered uint64
+	Dropped   uint64
+	Conflated uint64
+}
+
+// wsQueueKey identifies a subscription, grouped orderbooks of one market differ by grouping.
+type wsQueueKey struct {
+	channel  WsChannel
+	market   string
+	grouping float64
+}
+
+type wsQueueItem struct {
+	key     wsQueueKey
+	res     WsReponse
+	handler WsDataHandler
+}
+
+// wsEventQueue decouples the socket reader from the data handler.
+// Conflated entries are bounded by the number of subscribed markets, so they never wait for room.
+type wsEventQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	size     int
+	policies map[WsChannel]WsQueuePolicy
+	items    []*wsQueueItem
+	pending  map[wsQueueKey]*wsQueueItem
+	stats    map[WsChannel]*WsQueueChannelStats
+	maxDepth int
+	closed   bool
+}
+
+func newWsEventQueue(size int, policies map[WsChannel]WsQueuePolicy) *wsEventQueue {
+	q := &wsEventQueue{
+		size:     size,
+		policies: policies,
+		pending:  make(map[wsQueueKey]*wsQueueItem),
+		stats:    make(map[WsChannel]*WsQueueChannelStats),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+func (q *wsEventQueue) channelStats(ch WsChannel) *WsQueueChannelStats {
+	st, ok := q.stats[ch]
+	if !ok {
+		st = &WsQueueChannelStats{}
+		q.stats[ch] = st
+	}
+	return st
+}
+
+func (q *wsEventQueue) push(handler WsDataHandler, res WsReponse) {
+	key := res.queueKey()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	st := q.channelStats(key.channel)
+	st.Enqueued++
+	policy, ok := q.policies[key.channel]
+	if !ok {
+		policy = WsQueuePolicyBlock
+	}
+	switch policy {
+	case WsQueuePolicyConflate:
+		if it, ok := q.pending[key]; ok {
+			it.res = conflateWsReponse(it.res, res)
+			st.Conflated++
+			return
+		}
+	case WsQueuePolicyBlock:
+		for len(q.items) >= q.size && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			return
+		}
+	case WsQueuePolicyDropNewest:
+		if len(q.items) >= q.size {
+			st.Dropped++
+			return
+		}
+	case WsQueuePolicyDropOldest:
+		if len(q.items) >= q.size && !q.dropOldest(key.channel) {
+			// the rest of the queue may not be dropped
+			st.Dropped++
+			return
+		}
+	}
+	it := &wsQueueItem{key: key, res: res, handler: handler}
+	if policy == WsQueuePolicyConflate {
+		q.pending[key] = it
+	}
+	q.items = append(q.items, it)
+	if len(q.items) > q.maxDepth {
+		q.maxDepth = len(q.items)
+	}
+	q.cond.Broadcast()
+}
+
+// dropOldest removes the oldest queued event of ch and reports whether there was one.
+func (q *wsEventQueue) dropOldest(ch WsChannel) bool {
+	for i, it := range q.items {
+		if it.key.channel != ch {
+			continue
+		}
+		copy(q.items[i:], q.items[i+1:])
+		q.items[len(q.items)-1] = nil
+		q.items = q.items[:len(q.items)-1]
+		if q.pending[it.key] == it {
+			delete(q.pending, it.key)
+		}
+		q.channelStats(ch).Dropped++
+		return true
+	}
+	return false
+}
+
+func (q *wsEventQueue) run() {
+	for {
+		q.mu.Lock()
+		for len(q.items) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.items) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		it := q.items[0]
+		q.items[0] = nil
+		q.items = q.items[1:]
+		if q.pending[it.key] == it {
+			delete(q.pending, it.key)
+		}
+		q.channelStats(it.key.channel).Delivered++
+		q.cond.Broadcast()
+		q.mu.Unlock()
+		it.handler(it.res)
+	}
+}
+
+// close stops accepting events, the ones already queued are still delivered.
+func (q *wsEventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+func (q *wsEventQueue) Stats() WsQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	res := WsQueueStats{
+		Depth:    len(q.items),
+		MaxDepth: q.maxDepth,
+		Channels: make(map[WsChannel]WsQueueChannelStats, len(q.stats)),
+	}
+	for ch, st := range q.stats {
+		res.Channels[ch] = *st
+	}
+	return res
+}
+
+func (r WsReponse) queueKey() wsQueueKey {
+	switch {
+	case r.OrderBookEvent != nil:
+		return wsQueueKey{channel: WsChannelOrderBook, market: r.OrderBookEvent.Market}
+	case r.GroupedOrderBookEvent != nil:
+		return wsQueueKey{channel: WsChannelOrderbookGrouped, market: r.GroupedOrderBookEvent.Market, grouping: r.GroupedOrderBookEvent.Grouping}
+	case r.Ticker != nil:
+		return wsQueueKey{channel: WsChannelTicker, market: r.Ticker.Market}
+	case r.Markets != nil:
+		return wsQueueKey{channel: WsChannelMarkets, market: r.Markets.Market}
+	case r.Trades != nil:
+		return wsQueueKey{channel: WsChannelTrades, market: r.Trades.Market}
+	case r.Fills != nil:
+		return wsQueueKey{channel: WsChannelFills, market: r.Fills.Market}
+	case r.Orders != nil:
+		return wsQueueKey{channel: WsChannelOrders, market: r.Orders.Market}
+	case r.FTXPay != nil:
+		return wsQueueKey{channel: WsChannelFTXPay, market: r.FTXPay.Market}
+	}
+	return wsQueueKey{}
+}
+
+// conflateWsReponse folds next into prev. Tickers are replaced, book updates are merged
+// level by level so a queued partial stays a complete snapshot.
+func conflateWsReponse(prev, next WsReponse) WsReponse {
+	switch {
+	case prev.GroupedOrderBookEvent != nil && next.GroupedOrderBookEvent != nil:
+		if next.GroupedOrderBookEvent.Type == PartialWsDataAction {
+			return next
+		}
+		merged := *prev.GroupedOrderBookEvent
+		snapshot := merged.Type == PartialWsDataAction
+		merged.Data.Asks = mergeFeeds(merged.Data.Asks, next.GroupedOrderBookEvent.Data.Asks, snapshot, false)
+		merged.Data.Bids = mergeFeeds(merged.Data.Bids, next.GroupedOrderBookEvent.Data.Bids, snapshot, true)
+		return WsReponse{GroupedOrderBookEvent: &merged}
+	case prev.OrderBookEvent != nil && next.OrderBookEvent != nil:
+		if next.OrderBookEvent.Data.Action == PartialWsDataAction {
+			return next
+		}
+		merged := *prev.OrderBookEvent
+		snapshot := merged.Data.Action == PartialWsDataAction
+		merged.Data.Asks = mergeFeeds(merged.Data.Asks, next.OrderBookEvent.Data.Asks, snapshot, false)
+		merged.Data.Bids = mergeFeeds(merged.Data.Bids, next.OrderBookEvent.Data.Bids, snapshot, true)
+		// the checksum and time of the latest update describe the merged book
+		merged.Data.Checksum = next.OrderBookEvent.Data.Checksum
+		merged.Data.Time = next.OrderBookEvent.Data.Time
+		return WsReponse{OrderBookEvent: &merged}
+	}
+	return next
+}
+
+// mergeFeeds applies updates to levels by price, the last value of a price wins. The result is
+// sorted best first, descending for bids.
+func mergeFeeds(levels, updates []Feed, snapshot, desc bool) []Feed {
+	byPrice := make(map[float64]float64, len(levels)+len(updates))
+	for _, f := range levels {
+		byPrice[f.Price] = f.Size
+	}
+	for _, f := range updates {
+		byPrice[f.Price] = f.Size
+	}
+	res := make([]Feed, 0, len(byPrice))
+	for price, size := range byPrice {
+		// a snapshot has no removed levels
+		if snapshot && size == 0 {
+			continue
+		}
+		res = append(res, Feed{Price: price, Size: size})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if desc {
+			return res[i].Price > res[j].Price
+		}
+		return res[i].Price < res[j].Price
+	})
+	return res
+}
@@ -0,0 +1,92 @@
+package ftxapi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConflateOrderBookUpdates(t *testing.T) {
+	update := func(bids, asks []Feed) WsReponse {
+		return WsReponse{GroupedOrderBookEvent: &WsGroupedOrderBookEvent{
+			baseWsEvent: baseWsEvent{Type: UpdateWsDataAction},
+			Data:        WsGroupedOrderBook{Bids: bids, Asks: asks},
+		}}
+	}
+	first := update([]Feed{{Price: 99, Size: 1}, {Price: 100, Size: 2}}, []Feed{{Price: 102, Size: 1}})
+	second := update([]Feed{{Price: 101, Size: 3}, {Price: 100, Size: 5}}, []Feed{{Price: 101.5, Size: 1}, {Price: 102, Size: 0}})
+	got := conflateWsReponse(first, second).GroupedOrderBookEvent.Data
+
+	wantBids := []Feed{{Price: 101, Size: 3}, {Price: 100, Size: 5}, {Price: 99, Size: 1}}
+	wantAsks := []Feed{{Price: 101.5, Size: 1}, {Price: 102, Size: 0}}
+	if !reflect.DeepEqual(got.Bids, wantBids) {
+		t.Fatalf("bids = %v, want %v", got.Bids, wantBids)
+	}
+	if !reflect.DeepEqual(got.Asks, wantAsks) {
+		t.Fatalf("asks = %v, want %v", got.Asks, wantAsks)
+	}
+}
+
+func TestConflateOrderBookSnapshotDropsRemovedLevels(t *testing.T) {
+	partial := WsReponse{OrderBookEvent: &WsOrderBookEvent{Data: WsOrderBook{
+		Action: PartialWsDataAction,
+		Asks:   []Feed{{Price: 102, Size: 1}, {Price: 101, Size: 1}},
+	}}}
+	update := WsReponse{OrderBookEvent: &WsOrderBookEvent{Data: WsOrderBook{
+		Action:   UpdateWsDataAction,
+		Asks:     []Feed{{Price: 101, Size: 0}, {Price: 103, Size: 2}},
+		Checksum: 42,
+	}}}
+	got := conflateWsReponse(partial, update).OrderBookEvent.Data
+	want := []Feed{{Price: 102, Size: 1}, {Price: 103, Size: 2}}
+	if got.Action != PartialWsDataAction || got.Checksum != 42 || !reflect.DeepEqual(got.Asks, want) {
+		t.Fatalf("merged = %+v, want partial asks %v with checksum 42", got, want)
+	}
+}
+
+func TestQueueDropOldestKeepsOtherChannels(t *testing.T) {
+	q := newWsEventQueue(2, map[WsChannel]WsQueuePolicy{
+		WsChannelTicker: WsQueuePolicyDropOldest,
+		WsChannelFills:  WsQueuePolicyBlock,
+	})
+	defer q.close()
+	release := make(chan struct{})
+	delivered := make(chan WsReponse, 10)
+	handler := func(res WsReponse) {
+		<-release
+		delivered <- res
+	}
+	ticker := func(last float64) WsReponse {
+		return WsReponse{Ticker: &WsTickerEvent{baseWsEvent: baseWsEvent{Market: "BTC-PERP"}, Data: WsTicker{Last: &last}}}
+	}
+	fill := func(id int64) WsReponse {
+		return WsReponse{Fills: &WsFillsEvent{Data: WsFills{ID: id}}}
+	}
+
+	// the first event is taken by the handler, the fills fill the queue
+	q.push(handler, fill(1))
+	for q.Stats().Depth != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	q.push(handler, fill(2))
+	q.push(handler, fill(3))
+	q.push(handler, ticker(1))
+	close(release)
+
+	var ids []int64
+	for len(ids) < 3 {
+		select {
+		case res := <-delivered:
+			if res.Fills == nil {
+				t.Fatalf("delivered %+v, want only fills", res)
+			}
+			ids = append(ids, res.Fills.Data.ID)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("fills delivered = %v, want 3", ids)
+		}
+	}
+	stats := q.Stats().Channels
+	if stats[WsChannelFills].Dropped != 0 || stats[WsChannelTicker].Dropped != 1 {
+		t.Fatalf("stats = %+v, want only the ticker dropped", stats)
+	}
+}
@@ -0,0 +1,259 @@
+package ftxapi
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	wsRecordingExt          = ".ftxws.gz"
+	wsRecordHeaderSize      = 12
+	DefaultWsRecordMaxBytes = 256 << 20
+	DefaultWsRecordMaxAge   = 1 * time.Hour
+)
+
+// WsRecorderConfig controls where frames are written and when files rotate.
+// MaxBytes counts uncompressed frame bytes.
+type WsRecorderConfig struct {
+	Dir      string
+	Prefix   string
+	MaxBytes int64
+	MaxAge   time.Duration
+}
+
+// WsRecorder writes raw websocket frames with their local receive time into rotating gzip files.
+// Each record is an 8 byte unix nano timestamp, a 4 byte length and the frame, big endian.
+type WsRecorder struct {
+	mu      sync.Mutex
+	cfg     WsRecorderConfig
+	file    *os.File
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	written int64
+	opened  time.Time
+	header  [wsRecordHeaderSize]byte
+}
+
+func NewWsRecorder(cfg WsRecorderConfig) (*WsRecorder, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("recorder dir is required")
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "ftx"
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultWsRecordMaxBytes
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = DefaultWsRecordMaxAge
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &WsRecorder{cfg: cfg}, nil
+}
+
+// Record makes the service write every received frame to r.
+func (s *WebsocketService) Record(r *WsRecorder) *WebsocketService {
+	s.recorder = r
+	return s
+}
+
+func (r *WsRecorder) Write(t time.Time, msg []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil || r.written >= r.cfg.MaxBytes || t.Sub(r.opened) >= r.cfg.MaxAge {
+		if err := r.rotate(t); err != nil {
+			return err
+		}
+	}
+	binary.BigEndian.PutUint64(r.header[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(r.header[8:], uint32(len(msg)))
+	if _, err := r.buf.Write(r.header[:]); err != nil {
+		return err
+	}
+	if _, err := r.buf.Write(msg); err != nil {
+		return err
+	}
+	r.written += int64(len(msg)) + wsRecordHeaderSize
+	return nil
+}
+
+// Flush pushes buffered frames to the current file, the gzip stream stays open.
+func (r *WsRecorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	if err := r.buf.Flush(); err != nil {
+		return err
+	}
+	return r.gz.Flush()
+}
+
+func (r *WsRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closeFile()
+}
+
+func (r *WsRecorder) rotate(t time.Time) error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s%s", r.cfg.Prefix, t.UTC().Format("20060102T150405.000000000"), wsRecordingExt)
+	f, err := os.OpenFile(filepath.Join(r.cfg.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	r.file = f
+	r.gz = gzip.NewWriter(f)
+	r.buf = bufio.NewWriterSize(r.gz, 64<<10)
+	r.written = 0
+	r.opened = t
+	return nil
+}
+
+func (r *WsRecorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.buf.Flush()
+	if e := r.gz.Close(); err == nil {
+		err = e
+	}
+	if e := r.file.Close(); err == nil {
+		err = e
+	}
+	r.file, r.gz, r.buf = nil, nil, nil
+	return err
+}
+
+// WsRecordingFiles lists the recordings in dir with the given prefix in recording order.
+func WsRecordingFiles(dir, prefix string) ([]string, error) {
+	if prefix == "" {
+		prefix = "ftx"
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), prefix+"-") || !strings.HasSuffix(e.Name(), wsRecordingExt) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// WsReplayer feeds recorded frames through the same decode and handler path as a live connection.
+type WsReplayer struct {
+	l     *zap.SugaredLogger
+	files []string
+	speed float64
+}
+
+func NewWsReplayer(files []string, l *zap.SugaredLogger) *WsReplayer {
+	return &WsReplayer{l: l, files: files, speed: 1}
+}
+
+// Speed sets the replay rate relative to the recording, 1 is the original speed
+// and 0 replays as fast as possible.
+func (r *WsReplayer) Speed(speed float64) *WsReplayer {
+	if speed >= 0 {
+		r.speed = speed
+	}
+	return r
+}
+
+func (r *WsReplayer) Replay(ctx context.Context, dataHandler WsDataHandler, errHandler WsErrorHandler) error {
+	s := NewWebsocketService("", "", "", r.l)
+	var dec wsDecoder
+	pong := make(chan struct{}, 1)
+	var first, started time.Time
+	for _, path := range r.files {
+		err := readWsRecording(path, func(t time.Time, msg []byte) error {
+			if r.speed > 0 {
+				if first.IsZero() {
+					first, started = t, time.Now()
+				}
+				wait := time.Duration(float64(t.Sub(first))/r.speed) - time.Since(started)
+				if wait > 0 {
+					tm := time.NewTimer(wait)
+					select {
+					case <-ctx.Done():
+						tm.Stop()
+						return ctx.Err()
+					case <-tm.C:
+					}
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			s.handleMessage(&dec, msg, pong, dataHandler, errHandler)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readWsRecording(path string, fn func(t time.Time, msg []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer gz.Close()
+	br := bufio.NewReaderSize(gz, 64<<10)
+	var header [wsRecordHeaderSize]byte
+	var msg []byte
+	for {
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// a recorder killed mid write leaves a truncated tail
+				return nil
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		t := time.Unix(0, int64(binary.BigEndian.Uint64(header[:8])))
+		n := int(binary.BigEndian.Uint32(header[8:]))
+		if cap(msg) < n {
+			msg = make([]byte, n)
+		}
+		msg = msg[:n]
+		if _, err := io.ReadFull(br, msg); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := fn(t, msg); err != nil {
+			return err
+		}
+	}
+}
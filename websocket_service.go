@@ -4,20 +4,27 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
-	"github.com/bitly/go-simplejson"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 )
 
 const (
 	WebsocketEndpoint string = "wss://ftx.com/ws/"
+
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 2 * time.Second
+	wsPingPeriod     = 15 * time.Second
+	wsWriteQueueSize = 64
 )
 
+var ErrWsNotConnected = errors.New("websocket is not connected")
+var ErrWsConnectionClosed = errors.New("websocket connection closed")
+
 type WebsocketService struct {
 	l                     *zap.SugaredLogger
 	mu                    sync.Mutex
@@ -30,9 +37,20 @@ type WebsocketService struct {
 	conn                  *websocket.Conn
 	subAccount            *string
 	stopC                 chan struct{}
-	stopPing              chan struct{}
+	connDone              chan struct{}
 	reconnectC            chan struct{}
 	receivePong           chan struct{}
+	writeC                chan wsWriteRequest
+	stateHandlers         []WsStateHandler
+	queue                 *wsEventQueue
+	watchdog              *wsWatchdog
+	recorder              *WsRecorder
+}
+
+// wsWriteRequest is a message queued for the connection writer, errC receives the write result.
+type wsWriteRequest struct {
+	msg  RequestMsg
+	errC chan error
 }
 
 func NewWebsocketService(apiKey, apiSecret, wsEndpoint string, l *zap.SugaredLogger) *WebsocketService {
@@ -43,7 +61,6 @@ func NewWebsocketService(apiKey, apiSecret, wsEndpoint string, l *zap.SugaredLog
 		apiSecret:        apiSecret,
 		wsEndpoint:       wsEndpoint,
 		mapSubscriptions: make(map[Subscription]struct{}),
-		receivePong:      make(chan struct{}),
 	}
 }
 
@@ -58,6 +75,48 @@ func (s *WebsocketService) SubAccount(sa string) *WebsocketService {
 	return s
 }
 
+// EventQueue puts a queue of the given size between the socket reader and the data handler,
+// so a slow handler doesn't stall reads. Policies missing from the map use DefaultWsQueuePolicies.
+func (s *WebsocketService) EventQueue(size int, policies map[WsChannel]WsQueuePolicy) *WebsocketService {
+	if size <= 0 {
+		size = DefaultWsQueueSize
+	}
+	merged := make(map[WsChannel]WsQueuePolicy, len(DefaultWsQueuePolicies))
+	for ch, p := range DefaultWsQueuePolicies {
+		merged[ch] = p
+	}
+	for ch, p := range policies {
+		merged[ch] = p
+	}
+	s.queue = newWsEventQueue(size, merged)
+	return s
+}
+
+// QueueStats reports the event queue depth and per channel counters, zero if EventQueue wasn't set.
+func (s *WebsocketService) QueueStats() WsQueueStats {
+	if s.queue == nil {
+		return WsQueueStats{}
+	}
+	return s.queue.Stats()
+}
+
+// OnStateChange registers h to be called each time the connection is established or lost.
+func (s *WebsocketService) OnStateChange(h WsStateHandler) *WebsocketService {
+	s.mu.Lock()
+	s.stateHandlers = append(s.stateHandlers, h)
+	s.mu.Unlock()
+	return s
+}
+
+func (s *WebsocketService) notifyState(state WsConnState) {
+	s.mu.Lock()
+	handlers := s.stateHandlers
+	s.mu.Unlock()
+	for _, h := range handlers {
+		h(state)
+	}
+}
+
 func (s *WebsocketService) Connect(dataHandler WsDataHandler, errHandler WsErrorHandler) error {
 	l := s.l.With("func", "WebsocketService.Connect")
 	conn, _, err := websocket.DefaultDialer.Dial(s.wsEndpoint, nil)
@@ -65,166 +124,180 @@ func (s *WebsocketService) Connect(dataHandler WsDataHandler, errHandler WsError
 		l.Errorw("cannot connect ws", "err", err)
 		return err
 	}
+	connDone := make(chan struct{})
+	reconnectC := make(chan struct{})
+	writeC := make(chan wsWriteRequest, wsWriteQueueSize)
+	// buffered so the read loop never blocks on a pong nobody waits for
+	receivePong := make(chan struct{}, 1)
+
+	s.mu.Lock()
+	s.conn = conn
+	s.mapCheckSubscriptions = make(map[Subscription]struct{})
+	s.connDone = connDone
+	s.reconnectC = reconnectC
+	s.receivePong = receivePong
+	s.writeC = writeC
+	subs := make([]Subscription, 0, len(s.mapSubscriptions))
+	for sub := range s.mapSubscriptions {
+		subs = append(subs, sub)
+	}
+	s.mu.Unlock()
+
+	go s.runWriter(conn, writeC, connDone)
 
 	// login
 	if s.apiKey != "" && s.apiSecret != "" {
-		if err := conn.WriteJSON(s.authenticationRequest()); err != nil {
+		if err := s.write(s.authenticationRequest()); err != nil {
 			l.Errorw("failed to log in", "err", err)
+			_ = conn.Close()
+			close(connDone)
 			return err
 		}
 	}
 
-	s.conn = conn
-	s.mapCheckSubscriptions = make(map[Subscription]struct{})
-	s.stopPing = make(chan struct{})
-	s.reconnectC = make(chan struct{})
-
-	go s.runPing()
+	go s.runPing(receivePong, connDone)
 
 	// subscribe
-	for sub := range s.mapSubscriptions {
+	for _, sub := range subs {
 		if err := s.Subscribe(sub); err != nil {
 			l.Errorw("failed to subscribe", "sub channel", sub.Channel,
 				"market", sub.Market, "grouping", sub.Grouping, "err", err)
+			_ = conn.Close()
+			close(connDone)
 			return err
 		}
 	}
 
-	go s.handleData(dataHandler, errHandler)
+	if s.watchdog != nil {
+		s.watchdog.reset()
+		go s.runWatchdog(connDone)
+	}
+	s.notifyState(WsConnStateConnected)
+	handler := dataHandler
+	if s.queue != nil {
+		handler = func(res WsReponse) {
+			s.queue.push(dataHandler, res)
+		}
+	}
+	go s.handleData(conn, connDone, reconnectC, receivePong, handler, errHandler)
 	if s.autoReconnect {
-		go s.reconnect(dataHandler, errHandler)
+		go s.reconnect(reconnectC, dataHandler, errHandler)
 	}
 	return nil
 }
 
-func (s *WebsocketService) handleData(dataHandler WsDataHandler, errHandler WsErrorHandler) {
+// runWriter is the only goroutine writing to conn, gorilla/websocket supports one concurrent writer.
+func (s *WebsocketService) runWriter(conn *websocket.Conn, writeC <-chan wsWriteRequest, connDone <-chan struct{}) {
+	for {
+		select {
+		case req := <-writeC:
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := conn.WriteJSON(req.msg)
+			req.errC <- err
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+		case <-connDone:
+			return
+		}
+	}
+}
+
+// write queues msg for the current connection and waits until it is written.
+func (s *WebsocketService) write(msg RequestMsg) error {
+	s.mu.Lock()
+	writeC, connDone := s.writeC, s.connDone
+	s.mu.Unlock()
+	if writeC == nil {
+		return ErrWsNotConnected
+	}
+	req := wsWriteRequest{msg: msg, errC: make(chan error, 1)}
+	select {
+	case writeC <- req:
+	case <-connDone:
+		return ErrWsConnectionClosed
+	}
+	select {
+	case err := <-req.errC:
+		return err
+	case <-connDone:
+		return ErrWsConnectionClosed
+	}
+}
+
+func (s *WebsocketService) handleData(conn *websocket.Conn, connDone, reconnectC, receivePong chan struct{},
+	dataHandler WsDataHandler, errHandler WsErrorHandler) {
 	defer func() {
-		close(s.stopPing)
-		close(s.reconnectC)
+		_ = conn.Close()
+		close(connDone)
+		s.notifyState(WsConnStateDisconnected)
+		close(reconnectC)
 	}()
 	l := s.l.With("func", "WebsocketService.handleData")
+	var dec wsDecoder
 	for {
-		_, msg, err := s.conn.ReadMessage()
+		_, msg, err := conn.ReadMessage()
 		if err != nil {
 			l.Errorw("cannot read msg from ws client", "err", err)
 			errHandler(fmt.Errorf("cannot read msg from ws client, err = %s", err))
 			return
 		}
-		j, err := simplejson.NewJson(msg)
-		if err != nil {
-			l.Errorw("cannot read json", "err", err)
-			errHandler(fmt.Errorf("cannot read json, err = %s", err))
+		if s.recorder != nil {
+			if err := s.recorder.Write(time.Now(), msg); err != nil {
+				l.Errorw("cannot record msg", "err", err)
+			}
+		}
+		if !s.handleMessage(&dec, msg, receivePong, dataHandler, errHandler) {
 			return
 		}
+	}
+}
 
-		typeEvent := j.Get("type").MustString("")
-		channelEvent := j.Get("channel").MustString("")
-		marketEvent := j.Get("market").MustString("")
-		switch typeEvent {
-		case "pong":
-			s.receivePong <- struct{}{}
-		case "subscribed":
-			l.Infow("subscribe successfully", "channel", channelEvent, "market", marketEvent)
-		case "unsubscribed":
-			l.Infow("unsubscribe successfully", "channel", channelEvent, "market", marketEvent)
-		case "error":
-			var er errorResponse
-			if err := json.Unmarshal(msg, &er); err != nil {
-				l.Errorw("cannot unmarshal error data", "err", err)
-				errHandler(fmt.Errorf("cannot unmarshal error data, err = %s", err))
-				return
-			}
-			errHandler(fmt.Errorf("error from server, code = %d, msg = %s", er.Code, er.Msg))
-		case "info":
-			var info errorResponse
-			if err := json.Unmarshal(msg, &info); err != nil {
-				l.Errorw("cannot unmarshal info data", "err", err)
-				errHandler(fmt.Errorf("cannot unmarshal info data, err = %s", err))
-				return
-			}
-			if info.Code == 20001 {
-				l.Infow("server suggest restart connection", "msg", info.Msg)
-				return
-			}
-		case "partial", "update":
-			switch WsChannel(channelEvent) {
-			case WsChannelTicker:
-				var event WsTickerEvent
-				if err := json.Unmarshal(msg, &event); err != nil {
-					l.Errorw("cannot unmarshal orderbook data", "err", err)
-					errHandler(fmt.Errorf("cannot unmarshal orderbook data, err = %s", err))
-				}
-				dataHandler(WsReponse{
-					Ticker: &event,
-				})
-			case WsChannelMarkets:
-				var event WsMarketsEvent
-				if err := json.Unmarshal(msg, &event); err != nil {
-					l.Errorw("cannot unmarshal orderbook data", "err", err)
-					errHandler(fmt.Errorf("cannot unmarshal orderbook data, err = %s", err))
-				}
-				dataHandler(WsReponse{
-					Markets: &event,
-				})
-			case WsChannelTrades:
-				var event WsTradesEvent
-				if err := json.Unmarshal(msg, &event); err != nil {
-					l.Errorw("cannot unmarshal orderbook data", "err", err)
-					errHandler(fmt.Errorf("cannot unmarshal orderbook data, err = %s", err))
-				}
-				dataHandler(WsReponse{
-					Trades: &event,
-				})
-			case WsChannelOrderBook:
-				var event WsOrderBookEvent
-				if err := json.Unmarshal(msg, &event); err != nil {
-					l.Errorw("cannot unmarshal orderbook data", "err", err)
-					errHandler(fmt.Errorf("cannot unmarshal orderbook data, err = %s", err))
-				}
-				dataHandler(WsReponse{
-					OrderBookEvent: &event,
-				})
-			case WsChannelOrderbookGrouped:
-				var event WsGroupedOrderBookEvent
-				if err := json.Unmarshal(msg, &event); err != nil {
-					l.Errorw("cannot unmarshal grouped orderbook data", "err", err)
-					errHandler(fmt.Errorf("cannot unmarshal grouped orderbook data, err = %s", err))
-				}
-				dataHandler(WsReponse{
-					GroupedOrderBookEvent: &event,
-				})
-			case WsChannelFills:
-				var event WsFillsEvent
-				if err := json.Unmarshal(msg, &event); err != nil {
-					l.Errorw("cannot unmarshal fills data", "err", err)
-					errHandler(fmt.Errorf("cannot unmarshal fills data, err = %s", err))
-				}
-				dataHandler(WsReponse{
-					Fills: &event,
-				})
-			case WsChannelOrders:
-				var event WsOrdersEvent
-				if err := json.Unmarshal(msg, &event); err != nil {
-					l.Errorw("cannot unmarshal orders data", "err", err)
-					errHandler(fmt.Errorf("cannot unmarshal orders data, err = %s", err))
-				}
-				dataHandler(WsReponse{
-					Orders: &event,
-				})
-			case WsChannelFTXPay:
-				var event WsFTXPayEvent
-				if err := json.Unmarshal(msg, &event); err != nil {
-					l.Errorw("cannot unmarshal ftx pay data", "err", err)
-					errHandler(fmt.Errorf("cannot unmarshal ftx pay data, err = %s", err))
-				}
-				dataHandler(WsReponse{
-					FTXPay: &event,
-				})
-			}
+// handleMessage decodes one frame and dispatches it, false means the connection should be dropped.
+func (s *WebsocketService) handleMessage(dec *wsDecoder, msg []byte, receivePong chan struct{},
+	dataHandler WsDataHandler, errHandler WsErrorHandler) bool {
+	l := s.l.With("func", "WebsocketService.handleMessage")
+	env, err := dec.decodeEnvelope(msg)
+	if err != nil {
+		l.Errorw("cannot read json", "err", err)
+		errHandler(fmt.Errorf("cannot read json, err = %s", err))
+		return false
+	}
+	switch env.Type {
+	case "pong":
+		select {
+		case receivePong <- struct{}{}:
 		default:
-			l.Infow("not supported type", "type", typeEvent)
 		}
+	case "subscribed":
+		l.Infow("subscribe successfully", "channel", env.Channel, "market", env.Market)
+	case "unsubscribed":
+		l.Infow("unsubscribe successfully", "channel", env.Channel, "market", env.Market)
+	case "error":
+		errHandler(&WsServerError{Code: env.Code, Msg: env.Msg})
+	case "info":
+		if env.Code == 20001 {
+			l.Infow("server suggest restart connection", "msg", env.Msg)
+			return false
+		}
+	case "partial", "update":
+		if s.watchdog != nil {
+			s.watchdog.touch(env.Channel, env.Market, env.Grouping)
+		}
+		res, ok, err := dec.decodeData(env)
+		if err != nil {
+			l.Errorw("cannot unmarshal data", "channel", env.Channel, "err", err)
+			errHandler(fmt.Errorf("cannot unmarshal %s data, err = %s", env.Channel, err))
+			return true
+		}
+		if ok {
+			dataHandler(res)
+		}
+	default:
+		l.Infow("not supported type", "type", env.Type)
 	}
+	return true
 }
 
 func (s *WebsocketService) authenticationRequest() RequestMsg {
@@ -247,44 +320,60 @@ func (s *WebsocketService) authenticationRequest() RequestMsg {
 }
 
 func (s *WebsocketService) closeConnection() {
-	_ = s.conn.Close()
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn != nil {
+		_ = conn.Close()
+	}
 }
 
-func (s *WebsocketService) runPing() {
-	t := time.NewTicker(15 * time.Second)
+func (s *WebsocketService) runPing(receivePong <-chan struct{}, connDone <-chan struct{}) {
+	t := time.NewTicker(wsPingPeriod)
 	defer t.Stop()
-loop:
 	for {
 		select {
 		case <-t.C:
-			tm := time.NewTimer(2 * time.Second)
-			if err := s.conn.WriteJSON(RequestMsg{
-				OP: "ping",
-			}); err != nil {
+			// drop a late pong left over from the previous round
+			select {
+			case <-receivePong:
+			default:
+			}
+			if err := s.write(RequestMsg{OP: "ping"}); err != nil {
 				s.closeConnection()
-				break loop
+				return
 			}
+			tm := time.NewTimer(wsPongWait)
 			select {
-			case <-s.receivePong:
+			case <-receivePong:
 				tm.Stop()
 			case <-tm.C:
 				s.closeConnection()
-				break loop
+				return
+			case <-connDone:
+				tm.Stop()
+				return
 			}
-		case <-s.stopPing:
-			break loop
+		case <-connDone:
+			return
 		}
 	}
 }
 
 func (s *WebsocketService) Subscribe(sub Subscription) error {
 	s.mu.Lock()
-	mapCheckSub := s.mapCheckSubscriptions
-	s.mu.Unlock()
-	if _, ok := mapCheckSub[sub]; ok {
+	// remembered even if not connected yet, Connect sends it
+	s.mapSubscriptions[sub] = struct{}{}
+	if s.writeC == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	if _, ok := s.mapCheckSubscriptions[sub]; ok {
+		s.mu.Unlock()
 		return nil
 	}
-	if err := s.conn.WriteJSON(RequestMsg{
+	s.mu.Unlock()
+	if err := s.write(RequestMsg{
 		OP:       "subscribe",
 		Channel:  StringPointer(string(sub.Channel)),
 		Market:   sub.Market,
@@ -294,19 +383,23 @@ func (s *WebsocketService) Subscribe(sub Subscription) error {
 	}
 	s.mu.Lock()
 	s.mapCheckSubscriptions[sub] = struct{}{}
-	s.mapSubscriptions[sub] = struct{}{}
 	s.mu.Unlock()
 	return nil
 }
 
 func (s *WebsocketService) Unsubscribe(sub Subscription) error {
 	s.mu.Lock()
-	mapCheckSub := s.mapCheckSubscriptions
-	s.mu.Unlock()
-	if _, ok := mapCheckSub[sub]; !ok {
+	delete(s.mapSubscriptions, sub)
+	if s.writeC == nil {
+		s.mu.Unlock()
 		return nil
 	}
-	if err := s.conn.WriteJSON(RequestMsg{
+	if _, ok := s.mapCheckSubscriptions[sub]; !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+	if err := s.write(RequestMsg{
 		OP:       "unsubscribe",
 		Channel:  StringPointer(string(sub.Channel)),
 		Market:   sub.Market,
@@ -316,20 +409,25 @@ func (s *WebsocketService) Unsubscribe(sub Subscription) error {
 	}
 	s.mu.Lock()
 	delete(s.mapCheckSubscriptions, sub)
-	delete(s.mapSubscriptions, sub)
 	s.mu.Unlock()
 	return nil
 }
 
-func (s *WebsocketService) reconnect(dataHandler WsDataHandler, errHandler WsErrorHandler) {
+func (s *WebsocketService) reconnect(reconnectC <-chan struct{}, dataHandler WsDataHandler, errHandler WsErrorHandler) {
 	l := s.l.With("func", "reconnect")
 	select {
 	case <-s.stopC:
 		l.Infow("connection will be stopped")
-	case <-s.reconnectC:
+	case <-reconnectC:
 		l.Infow("reconnect...")
 		time.Sleep(1 * time.Second)
 		for {
+			select {
+			case <-s.stopC:
+				l.Infow("connection will be stopped")
+				return
+			default:
+			}
 			if err := s.Connect(dataHandler, errHandler); err != nil {
 				l.Errorw("reconnect: connect error", "err", err)
 				time.Sleep(5 * time.Second)
@@ -341,6 +439,17 @@ func (s *WebsocketService) reconnect(dataHandler WsDataHandler, errHandler WsErr
 	}
 }
 
+// Subscriptions returns the subscriptions that are sent on every (re)connect.
+func (s *WebsocketService) Subscriptions() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := make([]Subscription, 0, len(s.mapSubscriptions))
+	for sub := range s.mapSubscriptions {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
 func (s *WebsocketService) ResetConnection() {
 	s.closeConnection()
 }
@@ -350,4 +459,7 @@ func (s *WebsocketService) Close() {
 		close(s.stopC)
 	}
 	s.closeConnection()
+	if s.queue != nil {
+		s.queue.close()
+	}
 }
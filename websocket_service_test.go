@@ -0,0 +1,154 @@
+package ftxapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// testWsServer accepts websocket connections, answers pings and records the ops it reads.
+// Frames can be pushed to a connection with send, and upgrades beyond limit are refused.
+type testWsServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	ops   map[string]int
+	conns []*testWsConn
+	limit int
+}
+
+type testWsConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *testWsConn) writeJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(v)
+}
+
+func newTestWsServer(t *testing.T) *testWsServer {
+	s := &testWsServer{ops: make(map[string]int)}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		refuse := s.limit > 0 && len(s.conns) >= s.limit
+		s.mu.Unlock()
+		if refuse {
+			http.Error(w, "too many connections", http.StatusServiceUnavailable)
+			return
+		}
+		raw, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := &testWsConn{conn: raw}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		defer func() {
+			raw.Close()
+			s.mu.Lock()
+			for i, c := range s.conns {
+				if c == conn {
+					s.conns = append(s.conns[:i], s.conns[i+1:]...)
+					break
+				}
+			}
+			s.mu.Unlock()
+		}()
+		for {
+			var msg RequestMsg
+			if err := raw.ReadJSON(&msg); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.ops[msg.OP]++
+			s.mu.Unlock()
+			if msg.OP == "ping" {
+				if err := conn.writeJSON(map[string]string{"type": "pong"}); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// live returns the open connections in the order they were accepted.
+func (s *testWsServer) live() []*testWsConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*testWsConn(nil), s.conns...)
+}
+
+func (s *testWsServer) refuseAfter(n int) {
+	s.mu.Lock()
+	s.limit = n
+	s.mu.Unlock()
+}
+
+func (s *testWsServer) endpoint() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+func (s *testWsServer) count(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ops[op]
+}
+
+func TestWebsocketServiceConcurrentWrites(t *testing.T) {
+	srv := newTestWsServer(t)
+	ws := NewWebsocketService("", "", srv.endpoint(), zap.NewNop().Sugar())
+	if err := ws.Connect(func(WsReponse) {}, func(error) {}); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	const n = 50
+	var wg sync.WaitGroup
+	errC := make(chan error, 3*n)
+	for i := 0; i < n; i++ {
+		wg.Add(3)
+		market := fmt.Sprintf("M%d-PERP", i)
+		go func() {
+			defer wg.Done()
+			errC <- ws.Subscribe(Subscription{Channel: WsChannelTrades, Market: &market})
+		}()
+		go func() {
+			defer wg.Done()
+			errC <- ws.write(RequestMsg{OP: "ping"})
+		}()
+		go func() {
+			defer wg.Done()
+			errC <- ws.Unsubscribe(Subscription{Channel: WsChannelTicker, Market: &market})
+		}()
+	}
+	wg.Wait()
+	close(errC)
+	for err := range errC {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// write returns once the message is on the wire, the server may still be reading it
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.count("ping") < n || srv.count("subscribe") < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("server read %d pings and %d subscribes, want %d", srv.count("ping"), srv.count("subscribe"), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := len(ws.Subscriptions()); got != n {
+		t.Fatalf("subscriptions = %d, want %d", got, n)
+	}
+}
@@ -1,6 +1,7 @@
 package ftxapi
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -52,15 +53,29 @@ type WsReponse struct {
 	FTXPay                *WsFTXPayEvent
 }
 
-type errorResponse struct {
-	Code int    `json:"code"`
-	Msg  string `json:"msg"`
+// WsServerError is an error message sent by the server, e.g. a failed login or a bad subscription.
+type WsServerError struct {
+	Code int
+	Msg  string
+}
+
+func (e *WsServerError) Error() string {
+	return fmt.Sprintf("error from server, code = %d, msg = %s", e.Code, e.Msg)
 }
 
 type WsDataHandler func(res WsReponse)
 
 type WsErrorHandler func(err error)
 
+type WsConnState string
+
+const (
+	WsConnStateConnected    WsConnState = "connected"
+	WsConnStateDisconnected WsConnState = "disconnected"
+)
+
+type WsStateHandler func(state WsConnState)
+
 type baseWsEvent struct {
 	Type    WsDataAction `json:"type"`
 	Channel WsChannel    `json:"channel"`
@@ -81,6 +96,11 @@ type WsTicker struct {
 	Time    float64  `json:"time"`
 }
 
+// Timestamp converts the exchange time of the ticker.
+func (t WsTicker) Timestamp() time.Time {
+	return floatSecondsToTime(t.Time)
+}
+
 type WsMarketsData struct {
 	Action WsDataAction `json:"action"`
 	Data   map[string]WsMarket
@@ -130,6 +150,7 @@ type WsTradesEvent struct {
 }
 
 type WsTrade struct {
+	ID          int64     `json:"id"`
 	Price       float64   `json:"price"`
 	Size        float64   `json:"size"`
 	Side        Side      `json:"side"`
@@ -183,6 +204,8 @@ type WsFills struct {
 	Time          time.Time `json:"time"`
 	TradeID       int64     `json:"tradeId"`
 	Type          string    `json:"type"`
+	// Backfilled is set on fills missed while disconnected and fetched from FillsService
+	Backfilled bool `json:"-"`
 }
 
 type WsOrdersEvent struct {
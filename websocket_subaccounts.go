@@ -0,0 +1,380 @@
+package ftxapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	subAccountStreamsRetryDelay    = 1 * time.Second
+	subAccountStreamsMaxRetryDelay = 1 * time.Minute
+	subAccountBackfillTimeout      = 30 * time.Second
+	subAccountBackfillAttempts     = 5
+	subAccountSeenFills            = 10000
+)
+
+// WsSubAccountReponse is an event of a private stream tagged with the subaccount it came from,
+// an empty SubAccount is the main account.
+type WsSubAccountReponse struct {
+	SubAccount string
+	WsReponse
+}
+
+type WsSubAccountDataHandler func(res WsSubAccountReponse)
+
+// WsSubAccountError wraps an error of one subaccount stream.
+type WsSubAccountError struct {
+	SubAccount  string
+	LoginFailed bool
+	Err         error
+}
+
+func (e *WsSubAccountError) Error() string {
+	return fmt.Sprintf("subaccount %q: %s", e.SubAccount, e.Err)
+}
+
+func (e *WsSubAccountError) Unwrap() error {
+	return e.Err
+}
+
+// SubAccountStreams keeps one authenticated connection per subaccount subscribed to the
+// private channels and delivers all their events to one handler. With Backfill, fills missed
+// while a connection was down are fetched after it logs in again and delivered, with
+// Backfilled set, before the live fills that came in meanwhile.
+type SubAccountStreams struct {
+	l           *zap.SugaredLogger
+	mu          sync.Mutex
+	handlerMu   sync.Mutex
+	apiKey      string
+	apiSecret   string
+	wsEndpoint  string
+	subAccounts []string
+	channels    []WsChannel
+	streams     map[string]*subAccountStream
+	dataHandler WsSubAccountDataHandler
+	errHandler  WsErrorHandler
+	backfill    *Client
+	since       time.Time
+	closed      bool
+	stopC       chan struct{}
+}
+
+type subAccountStream struct {
+	subAccount string
+	ws         *WebsocketService
+	mu         sync.Mutex
+	retryDelay time.Duration
+	resetting  bool
+	// fill backfill state, guarded by mu
+	lastFillTime time.Time
+	lastFillID   int64
+	seenFills    map[int64]struct{}
+	// ring of the seen fill ids in arrival order, the oldest is forgotten first
+	seenRing    []int64
+	seenNext    int
+	backfilling bool
+	pending     []WsReponse
+}
+
+func NewSubAccountStreams(apiKey, apiSecret, wsEndpoint string, subAccounts []string, l *zap.SugaredLogger) *SubAccountStreams {
+	return &SubAccountStreams{
+		l:           l,
+		apiKey:      apiKey,
+		apiSecret:   apiSecret,
+		wsEndpoint:  wsEndpoint,
+		subAccounts: subAccounts,
+		channels:    []WsChannel{WsChannelFills, WsChannelOrders},
+		streams:     make(map[string]*subAccountStream),
+		stopC:       make(chan struct{}),
+	}
+}
+
+// Channels replaces the default fills and orders subscriptions.
+func (m *SubAccountStreams) Channels(channels ...WsChannel) *SubAccountStreams {
+	m.channels = channels
+	return m
+}
+
+// Backfill enables fetching missed fills with c after every reconnect, c is switched to each
+// subaccount. Fills are fetched from since on the first connection, a zero since starts from then.
+// A failed fetch is retried with a backoff while the live fills wait.
+func (m *SubAccountStreams) Backfill(c *Client, since time.Time) *SubAccountStreams {
+	m.backfill = c
+	m.since = since
+	return m
+}
+
+// Connect opens a connection per subaccount. Subaccounts that fail to connect are reported
+// to errHandler and retried in the background.
+func (m *SubAccountStreams) Connect(dataHandler WsSubAccountDataHandler, errHandler WsErrorHandler) error {
+	if m.apiKey == "" || m.apiSecret == "" {
+		return errors.New("api key and secret are required for private streams")
+	}
+	m.dataHandler = dataHandler
+	m.errHandler = errHandler
+	for _, sa := range m.subAccounts {
+		st := m.newStream(sa)
+		m.mu.Lock()
+		m.streams[sa] = st
+		m.mu.Unlock()
+		if err := m.connect(st); err != nil {
+			m.handleError(st, err)
+			go m.retryConnect(st)
+		}
+	}
+	return nil
+}
+
+// Service returns the connection of a subaccount, nil if it isn't managed.
+func (m *SubAccountStreams) Service(subAccount string) *WebsocketService {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.streams[subAccount]
+	if !ok {
+		return nil
+	}
+	return st.ws
+}
+
+func (m *SubAccountStreams) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	close(m.stopC)
+	streams := m.streams
+	m.mu.Unlock()
+	for _, st := range streams {
+		st.ws.Close()
+	}
+}
+
+func (m *SubAccountStreams) newStream(subAccount string) *subAccountStream {
+	ws := NewWebsocketService(m.apiKey, m.apiSecret, m.wsEndpoint, m.l.With("subaccount", subAccount)).AutoReconnect()
+	if subAccount != "" {
+		ws.SubAccount(subAccount)
+	}
+	for _, ch := range m.channels {
+		_ = ws.Subscribe(Subscription{Channel: ch})
+	}
+	st := &subAccountStream{
+		subAccount:   subAccount,
+		ws:           ws,
+		retryDelay:   subAccountStreamsRetryDelay,
+		lastFillTime: m.since,
+		seenFills:    make(map[int64]struct{}, subAccountSeenFills),
+		seenRing:     make([]int64, 0, subAccountSeenFills),
+	}
+	if m.backfill != nil {
+		ws.OnStateChange(func(state WsConnState) {
+			if state == WsConnStateConnected {
+				m.startBackfill(st)
+			}
+		})
+	}
+	return st
+}
+
+func (m *SubAccountStreams) connect(st *subAccountStream) error {
+	return st.ws.Connect(func(res WsReponse) {
+		st.mu.Lock()
+		// data on the private channels means the login went through
+		st.retryDelay = subAccountStreamsRetryDelay
+		if res.Fills != nil && st.backfilling {
+			st.pending = append(st.pending, res)
+			st.mu.Unlock()
+			return
+		}
+		deliver := res.Fills == nil || st.seeFill(res.Fills.Data)
+		st.mu.Unlock()
+		if deliver {
+			m.deliver(st, res)
+		}
+	}, func(err error) {
+		m.handleError(st, err)
+	})
+}
+
+func (m *SubAccountStreams) deliver(st *subAccountStream, res WsReponse) {
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
+	m.dataHandler(WsSubAccountReponse{SubAccount: st.subAccount, WsReponse: res})
+}
+
+// startBackfill holds the live fills back until the missed ones are delivered. It runs when the
+// connection logs in, before any of its data is read.
+func (m *SubAccountStreams) startBackfill(st *subAccountStream) {
+	st.mu.Lock()
+	if st.lastFillTime.IsZero() {
+		// nothing to catch up with on the first connection, later ones start from here
+		st.lastFillTime = time.Now()
+		st.mu.Unlock()
+		return
+	}
+	if st.backfilling {
+		st.mu.Unlock()
+		return
+	}
+	st.backfilling = true
+	since := st.lastFillTime
+	st.mu.Unlock()
+	go m.runBackfill(st, since)
+}
+
+func (m *SubAccountStreams) runBackfill(st *subAccountStream, since time.Time) {
+	fills := m.fetchFills(st, since)
+	st.mu.Lock()
+	var out []WsReponse
+	for _, f := range fills {
+		wf := f.wsFills()
+		if !st.seeFill(wf) {
+			continue
+		}
+		wf.Backfilled = true
+		out = append(out, WsReponse{Fills: &WsFillsEvent{
+			baseWsEvent: baseWsEvent{Type: UpdateWsDataAction, Channel: WsChannelFills},
+			Data:        wf,
+		}})
+	}
+	// live fills keep being queued while a batch is delivered so none can overtake it,
+	// the handler runs without st.mu and may call back into the streams
+	for {
+		for _, res := range st.pending {
+			if st.seeFill(res.Fills.Data) {
+				out = append(out, res)
+			}
+		}
+		st.pending = nil
+		if len(out) == 0 {
+			st.backfilling = false
+			st.mu.Unlock()
+			return
+		}
+		st.mu.Unlock()
+		for _, res := range out {
+			m.deliver(st, res)
+		}
+		out = nil
+		st.mu.Lock()
+	}
+}
+
+// fetchFills gets the fills since with a backoff between failed attempts. Live fills are held
+// back meanwhile, after the last attempt they go out without the missed ones.
+func (m *SubAccountStreams) fetchFills(st *subAccountStream, since time.Time) []Fill {
+	c := m.backfill.withSubAccount(st.subAccount)
+	delay := subAccountStreamsRetryDelay
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), subAccountBackfillTimeout)
+		fills, err := fillsSince(ctx, c, since)
+		cancel()
+		if err == nil {
+			return fills
+		}
+		m.handleError(st, fmt.Errorf("fills backfill: %w", err))
+		if errors.Is(err, ErrPageOverflow) || attempt == subAccountBackfillAttempts {
+			// a retry can't fill an overflowing second
+			return fills
+		}
+		select {
+		case <-m.stopC:
+			return nil
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > subAccountStreamsMaxRetryDelay {
+			delay = subAccountStreamsMaxRetryDelay
+		}
+	}
+}
+
+// seeFill records a fill and reports whether it is new, must be called with st.mu held.
+func (st *subAccountStream) seeFill(f WsFills) bool {
+	if _, ok := st.seenFills[f.ID]; ok {
+		return false
+	}
+	if len(st.seenRing) < subAccountSeenFills {
+		st.seenRing = append(st.seenRing, f.ID)
+	} else {
+		delete(st.seenFills, st.seenRing[st.seenNext])
+		st.seenRing[st.seenNext] = f.ID
+		st.seenNext = (st.seenNext + 1) % subAccountSeenFills
+	}
+	st.seenFills[f.ID] = struct{}{}
+	if f.Time.After(st.lastFillTime) || (f.Time.Equal(st.lastFillTime) && f.ID > st.lastFillID) {
+		st.lastFillTime = f.Time
+		st.lastFillID = f.ID
+	}
+	return true
+}
+
+func (m *SubAccountStreams) retryConnect(st *subAccountStream) {
+	for {
+		select {
+		case <-m.stopC:
+			return
+		case <-time.After(st.nextDelay()):
+		}
+		if err := m.connect(st); err != nil {
+			m.handleError(st, err)
+			continue
+		}
+		return
+	}
+}
+
+func (m *SubAccountStreams) handleError(st *subAccountStream, err error) {
+	var serverErr *WsServerError
+	loginFailed := errors.As(err, &serverErr) && isWsLoginError(serverErr)
+	m.handlerMu.Lock()
+	m.errHandler(&WsSubAccountError{SubAccount: st.subAccount, LoginFailed: loginFailed, Err: err})
+	m.handlerMu.Unlock()
+	if loginFailed {
+		go m.relogin(st)
+	}
+}
+
+// relogin drops the connection after a back off, the auto reconnect logs in again.
+func (m *SubAccountStreams) relogin(st *subAccountStream) {
+	st.mu.Lock()
+	if st.resetting {
+		st.mu.Unlock()
+		return
+	}
+	st.resetting = true
+	st.mu.Unlock()
+	defer func() {
+		st.mu.Lock()
+		st.resetting = false
+		st.mu.Unlock()
+	}()
+	select {
+	case <-m.stopC:
+		return
+	case <-time.After(st.nextDelay()):
+	}
+	m.l.Infow("login failed, reconnecting", "subaccount", st.subAccount)
+	st.ws.ResetConnection()
+}
+
+func (st *subAccountStream) nextDelay() time.Duration {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	d := st.retryDelay
+	if st.retryDelay *= 2; st.retryDelay > subAccountStreamsMaxRetryDelay {
+		st.retryDelay = subAccountStreamsMaxRetryDelay
+	}
+	return d
+}
+
+func isWsLoginError(err *WsServerError) bool {
+	msg := strings.ToLower(err.Msg)
+	return strings.Contains(msg, "login") || strings.Contains(msg, "logged in")
+}
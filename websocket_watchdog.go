@@ -0,0 +1,144 @@
+package ftxapi
+
+import (
+	"sync"
+	"time"
+)
+
+const wsWatchdogMinCheckPeriod = 100 * time.Millisecond
+
+type WsStaleEvent struct {
+	Subscription Subscription
+	LastMessage  time.Time
+	Since        time.Duration
+}
+
+type WsStaleHandler func(event WsStaleEvent)
+
+// wsWatchdog tracks when each market subscription last received data.
+type wsWatchdog struct {
+	mu         sync.Mutex
+	threshold  time.Duration
+	thresholds map[WsChannel]time.Duration
+	handler    WsStaleHandler
+	lastSeen   map[wsQueueKey]time.Time
+}
+
+// StaleWatchdog emits a stale event when a market subscription receives nothing for threshold
+// and resubscribes it to get a fresh partial. Channels without a market aren't watched.
+func (s *WebsocketService) StaleWatchdog(threshold time.Duration, handler WsStaleHandler) *WebsocketService {
+	s.watchdog = &wsWatchdog{
+		threshold:  threshold,
+		thresholds: make(map[WsChannel]time.Duration),
+		handler:    handler,
+		lastSeen:   make(map[wsQueueKey]time.Time),
+	}
+	return s
+}
+
+// StaleThreshold overrides the StaleWatchdog threshold of a channel, e.g. a longer one for the
+// trades of quiet markets. Zero stops watching the channel. Set it before Connect.
+func (s *WebsocketService) StaleThreshold(channel WsChannel, threshold time.Duration) *WebsocketService {
+	if s.watchdog == nil {
+		return s
+	}
+	s.watchdog.mu.Lock()
+	s.watchdog.thresholds[channel] = threshold
+	s.watchdog.mu.Unlock()
+	return s
+}
+
+// thresholdOf must be called with w.mu held.
+func (w *wsWatchdog) thresholdOf(channel WsChannel) time.Duration {
+	if t, ok := w.thresholds[channel]; ok {
+		return t
+	}
+	return w.threshold
+}
+
+// checkPeriod is a quarter of the shortest threshold.
+func (w *wsWatchdog) checkPeriod() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	shortest := w.threshold
+	for _, t := range w.thresholds {
+		if t > 0 && (shortest <= 0 || t < shortest) {
+			shortest = t
+		}
+	}
+	if period := shortest / 4; period > wsWatchdogMinCheckPeriod {
+		return period
+	}
+	return wsWatchdogMinCheckPeriod
+}
+
+func (w *wsWatchdog) touch(channel WsChannel, market string, grouping float64) {
+	w.mu.Lock()
+	w.lastSeen[wsQueueKey{channel: channel, market: market, grouping: grouping}] = time.Now()
+	w.mu.Unlock()
+}
+
+func (w *wsWatchdog) reset() {
+	w.mu.Lock()
+	w.lastSeen = make(map[wsQueueKey]time.Time)
+	w.mu.Unlock()
+}
+
+func (s *WebsocketService) runWatchdog(connDone <-chan struct{}) {
+	l := s.l.With("func", "WebsocketService.runWatchdog")
+	w := s.watchdog
+	t := time.NewTicker(w.checkPeriod())
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-connDone:
+			return
+		}
+		now := time.Now()
+		var stale []WsStaleEvent
+		s.mu.Lock()
+		w.mu.Lock()
+		for sub := range s.mapCheckSubscriptions {
+			threshold := w.thresholdOf(sub.Channel)
+			if sub.Market == nil || threshold <= 0 {
+				continue
+			}
+			key := wsQueueKey{channel: sub.Channel, market: *sub.Market}
+			if sub.Grouping != nil {
+				key.grouping = float64(*sub.Grouping)
+			}
+			last, ok := w.lastSeen[key]
+			if !ok {
+				// nothing received since subscribe, count from now
+				w.lastSeen[key] = now
+				continue
+			}
+			if since := now.Sub(last); since > threshold {
+				stale = append(stale, WsStaleEvent{Subscription: sub, LastMessage: last, Since: since})
+				w.lastSeen[key] = now
+			}
+		}
+		w.mu.Unlock()
+		s.mu.Unlock()
+
+		for _, event := range stale {
+			l.Infow("stale subscription, resubscribing", "channel", event.Subscription.Channel,
+				"market", *event.Subscription.Market, "since", event.Since)
+			if w.handler != nil {
+				w.handler(event)
+			}
+			if err := s.resubscribe(event.Subscription); err != nil {
+				l.Errorw("cannot resubscribe", "channel", event.Subscription.Channel,
+					"market", *event.Subscription.Market, "err", err)
+			}
+		}
+	}
+}
+
+func (s *WebsocketService) resubscribe(sub Subscription) error {
+	if err := s.Unsubscribe(sub); err != nil {
+		return err
+	}
+	return s.Subscribe(sub)
+}
@@ -0,0 +1,52 @@
+package ftxapi
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestStaleWatchdogPerChannelThreshold(t *testing.T) {
+	srv := newTestWsServer(t)
+	var mu sync.Mutex
+	stale := make(map[WsChannel]int)
+	ws := NewWebsocketService("", "", srv.endpoint(), zap.NewNop().Sugar()).
+		StaleWatchdog(200*time.Millisecond, func(event WsStaleEvent) {
+			mu.Lock()
+			stale[event.Subscription.Channel]++
+			mu.Unlock()
+		}).
+		StaleThreshold(WsChannelTrades, 0)
+	market := "BTC-PERP"
+	for _, ch := range []WsChannel{WsChannelTicker, WsChannelTrades} {
+		if err := ws.Subscribe(Subscription{Channel: ch, Market: &market}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ws.Connect(func(WsReponse) {}, func(error) {}); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	waitFor(t, "stale ticker", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return stale[WsChannelTicker] > 0
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if stale[WsChannelTrades] != 0 {
+		t.Fatalf("stale events = %v, want none for trades", stale)
+	}
+}
+
+func TestQueueKeyIncludesGrouping(t *testing.T) {
+	grouped := func(grouping float64) WsReponse {
+		return WsReponse{GroupedOrderBookEvent: &WsGroupedOrderBookEvent{baseWsEvent: baseWsEvent{Market: "BTC-PERP"}, Grouping: grouping}}
+	}
+	if grouped(5).queueKey() == grouped(500).queueKey() {
+		t.Fatal("grouped books of one market share a key")
+	}
+}